@@ -0,0 +1,92 @@
+package main
+
+import (
+	"path"
+	"sort"
+)
+
+// --- Cycle paths ---
+//
+// The Datalog closure says that an import closes a cycle, but not which
+// packages the cycle runs through. For each cycle violation the linter
+// rebuilds the dependency graph from the PR's files, and for a diff from
+// its head tree as well, and finds the shortest way back from the
+// imported package to the importing one, so the report can print the
+// whole loop.
+
+// packageOf returns the package of a file: its directory relative to the
+// module root. Import paths are packages already.
+func packageOf(file string) string {
+	return path.Dir(file)
+}
+
+// depGraph maps a node to the nodes it depends on, sorted.
+type depGraph map[string][]string
+
+// packageGraph is the package dependency graph of the PR's files.
+func packageGraph(files []PRFile) depGraph {
+	return buildGraph(files, func(file, imp string) (string, string, bool) {
+		from := packageOf(file)
+		return from, imp, from != imp
+	})
+}
+
+// layerGraph is the dependency graph between the layers of m.
+func layerGraph(files []PRFile, m *LayerMap) depGraph {
+	return buildGraph(files, func(file, imp string) (string, string, bool) {
+		from, to := m.Layer(file), m.Layer(imp)
+		return from, to, from != to && from != "unknown" && to != "unknown"
+	})
+}
+
+func buildGraph(files []PRFile, edge func(file, imp string) (string, string, bool)) depGraph {
+	seen := make(map[[2]string]bool)
+	g := make(depGraph)
+	for _, file := range files {
+		if file.Status == StatusDeleted {
+			continue
+		}
+		for _, imp := range file.Imports {
+			from, to, ok := edge(file.Path, imp)
+			if !ok || seen[[2]string{from, to}] {
+				continue
+			}
+			seen[[2]string{from, to}] = true
+			g[from] = append(g[from], to)
+		}
+	}
+	for _, next := range g {
+		sort.Strings(next)
+	}
+	return g
+}
+
+// cyclePath returns the shortest cycle that starts with the edge from →
+// to, as the list of nodes from from back to from, or nil if to does not
+// lead back to from.
+func (g depGraph) cyclePath(from, to string) []string {
+	prev := map[string]string{to: ""}
+	queue := []string{to}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node == from {
+			var back []string
+			for n := from; n != ""; n = prev[n] {
+				back = append(back, n)
+			}
+			cycle := []string{from}
+			for i := len(back) - 1; i >= 0; i-- {
+				cycle = append(cycle, back[i])
+			}
+			return cycle
+		}
+		for _, next := range g[node] {
+			if _, ok := prev[next]; !ok {
+				prev[next] = node
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// --- Linting a diff instead of a whole checkout ---
+//
+// A review should only fail on what the change introduces. ParseDiff reads
+// a unified diff (git diff base..head) and builds one PRFile per changed
+// Go file: AddedLines/DeletedLines are counted from the hunks, and Imports
+// holds only the imports on added lines, so the import rules never fire on
+// a dependency that was already there. A package cycle, though, is usually
+// closed by one new import and several old ones, so the package graph is
+// built from the head tree (see PullRequest.Tree) and the diff only
+// decides which import is reported. Files that existed before the change
+// are marked file_preexisting, which exempts them from the naming rules; a
+// legacy file with a bad name is not something the PR introduced.
+// //arch:ignore comments are read from the new side of the hunks, so a
+// suppression outside them is not seen.
+//
+// Imports and suppressions are only read before the file's first func,
+// type, var or const declaration, the part of a file AnalyzeModule parses,
+// so a string literal that looks like an import path or a comment in a
+// function body gives the same verdict in both modes. A hunk whose header
+// names a declaration as its context starts past that point.
+
+// File statuses in a diff.
+const (
+	StatusAdded    = "added"
+	StatusModified = "modified"
+	StatusRenamed  = "renamed"
+	StatusDeleted  = "deleted"
+)
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+(\d+)(?:,(\d+))? @@ ?(.*)$`)
+
+// declStart matches the first line of a top-level declaration other than
+// an import.
+var declStart = regexp.MustCompile(`^(?:func|type|var|const)\b`)
+
+// importLine matches an import spec on its own line, either inside an
+// import block or as a single import declaration, with an optional name
+// and trailing comment.
+var importLine = regexp.MustCompile(`^\s*(?:import\s+)?(?:[\p{L}_][\p{L}\p{N}_]*\s+|\.\s+)?("(?:[^"\\]|\\.)*"|` + "`[^`]*`" + `)\s*(?://.*)?$`)
+
+// ParseDiff reads a unified diff and returns its changed Go files. Import
+// paths under modulePath are recorded relative to it, as AnalyzeModule
+// does; with an empty modulePath they are kept as written.
+func ParseDiff(r io.Reader, modulePath string) ([]PRFile, error) {
+	var (
+		files   []PRFile
+		cur     *PRFile
+		removed map[string]bool
+		// imports and suppressions on the new side, by line
+		importAt map[int]string
+		sups     []Suppression
+		// lines left in the current hunk, old and new side
+		oldLeft, newLeft int
+		// line number of the next new-side line
+		newLine int
+		// whether the old and new side are past the imports
+		oldDecls, newDecls bool
+	)
+
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		// an import that was only moved or reordered is not new
+		kept := cur.Imports[:0]
+		for _, imp := range cur.Imports {
+			if removed[imp] {
+				delete(cur.ImportLines, imp)
+			} else {
+				kept = append(kept, imp)
+			}
+		}
+		if len(cur.ImportLines) == 0 {
+			cur.ImportLines = nil
+		}
+		cur.Imports = kept
+		cur.Suppressions = attachSuppressions(sups, importAt)
+		if strings.HasSuffix(cur.Path, ".go") {
+			files = append(files, *cur)
+		}
+		cur = nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				newLeft--
+				cur.AddedLines++
+				newDecls = newDecls || declStart.MatchString(line[1:])
+				if !newDecls {
+					if imp, ok := parseImportLine(line[1:], modulePath); ok {
+						cur.Imports = append(cur.Imports, imp)
+						cur.ImportLines[imp] = newLine
+					}
+					if err := scanNewLine(line[1:], newLine, modulePath, importAt, &sups); err != nil {
+						return nil, fmt.Errorf("%s: %w", cur.Path, err)
+					}
+				}
+				newLine++
+			case strings.HasPrefix(line, "-"):
+				oldLeft--
+				cur.DeletedLines++
+				oldDecls = oldDecls || declStart.MatchString(line[1:])
+				if imp, ok := parseImportLine(line[1:], modulePath); ok && !oldDecls {
+					removed[imp] = true
+				}
+			case strings.HasPrefix(line, " "), line == "":
+				oldLeft--
+				newLeft--
+				if line != "" {
+					oldDecls = oldDecls || declStart.MatchString(line[1:])
+					newDecls = newDecls || declStart.MatchString(line[1:])
+				}
+				if line != "" && !newDecls {
+					if err := scanNewLine(line[1:], newLine, modulePath, importAt, &sups); err != nil {
+						return nil, fmt.Errorf("%s: %w", cur.Path, err)
+					}
+				}
+				newLine++
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file"
+			default:
+				return nil, fmt.Errorf("line %d: unexpected line in hunk: %q", lineNo, line)
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			cur = &PRFile{Path: diffGitPath(line), Imports: []string{}, ImportLines: map[string]int{}, Status: StatusModified}
+			removed = make(map[string]bool)
+			importAt = make(map[int]string)
+			sups = nil
+			oldDecls, newDecls = false, false
+		case cur == nil:
+			// preamble before the first file, e.g. a commit message
+		case strings.HasPrefix(line, "new file mode"):
+			cur.Status = StatusAdded
+		case strings.HasPrefix(line, "deleted file mode"):
+			cur.Status = StatusDeleted
+		case strings.HasPrefix(line, "rename to "):
+			cur.Status = StatusRenamed
+			cur.Path = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "+++ "):
+			if path := strings.TrimPrefix(line, "+++ "); path != "/dev/null" {
+				cur.Path = strings.TrimPrefix(path, "b/")
+			}
+		case strings.HasPrefix(line, "@@"):
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: malformed hunk header: %q", lineNo, line)
+			}
+			oldLeft, newLeft = hunkLen(m[1]), hunkLen(m[3])
+			newLine, _ = strconv.Atoi(m[2])
+			if declStart.MatchString(m[4]) {
+				oldDecls, newDecls = true, true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read diff: %w", err)
+	}
+	if oldLeft > 0 || newLeft > 0 {
+		return nil, fmt.Errorf("diff ends in the middle of a hunk")
+	}
+	flush()
+	return files, nil
+}
+
+// diffGitPath returns the new path from a "diff --git a/x b/y" line. It
+// is overridden by "+++" or "rename to" when the diff has them.
+func diffGitPath(line string) string {
+	paths := strings.TrimPrefix(line, "diff --git ")
+	if i := strings.LastIndex(paths, " b/"); i >= 0 {
+		return paths[i+3:]
+	}
+	return paths
+}
+
+// hunkLen parses a hunk range length, which is 1 when omitted.
+func hunkLen(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// parseImportLine returns the module-local import on a source line, if
+// the line is an import spec.
+func parseImportLine(line, modulePath string) (string, bool) {
+	importPath, ok := importSpec(line)
+	if !ok || modulePath == "" {
+		return importPath, ok
+	}
+	return localImport(modulePath, importPath)
+}
+
+// importSpec returns the import path on a source line, if the line is an
+// import spec.
+func importSpec(line string) (string, bool) {
+	m := importLine.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+	importPath, err := strconv.Unquote(m[1])
+	return importPath, err == nil
+}
+
+// scanNewLine records the import and the //arch:ignore comment on a
+// new-side source line.
+func scanNewLine(line string, lineNo int, modulePath string, importAt map[int]string, sups *[]Suppression) error {
+	if imp, ok := importSpec(line); ok {
+		if local, ok := parseImportLine(line, modulePath); ok {
+			imp = local
+		}
+		importAt[lineNo] = imp
+	}
+	i := strings.Index(line, "//")
+	if i < 0 {
+		return nil
+	}
+	s, ok, err := parseSuppression(line[i:], lineNo)
+	if ok {
+		*sups = append(*sups, s)
+	}
+	return err
+}
+
+// GitDiff runs git diff between two revisions of the repository at dir
+// and parses it. Paths are relative to dir, so dir can be a module inside
+// a larger repository.
+func GitDiff(dir, base, head, modulePath string) ([]PRFile, error) {
+	out, err := git(dir, "diff", "--no-color", "--no-ext-diff", "-M", "--relative", base, head)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDiff(bytes.NewReader(out), modulePath)
+}
+
+// git runs a git command in dir and returns its output.
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
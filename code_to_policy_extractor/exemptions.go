@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// --- Suppressions and the baseline ---
+//
+// A codebase adopting the linter usually breaks its rules in many places
+// already. Two kinds of exemption keep those from failing every review:
+//
+//   - An //arch:ignore <rule> <reason> comment on an import, or on the line
+//     above it, suppresses that rule for that import. Anywhere else before
+//     the end of the import block it suppresses the rule for the whole
+//     file. Suppressed violations are not reported at all.
+//   - A baseline file records the (rule, file, import) violations a
+//     codebase had when it adopted the linter. They are still reported,
+//     but as existing, and do not fail the review. Lines are not recorded,
+//     so editing a file does not invalidate its entries.
+//
+// Both become Datalog facts, and the halt rules only fire for violations
+// that are neither ignored nor existing.
+
+// suppressionDirective starts an //arch:ignore comment.
+const suppressionDirective = "//arch:ignore"
+
+// Suppression is an //arch:ignore comment.
+type Suppression struct {
+	Rule string `json:"rule"`
+	// Import is the import the comment is on; empty for the whole file.
+	Import string `json:"import,omitempty"`
+	Reason string `json:"reason"`
+	Line   int    `json:"line"`
+}
+
+// parseSuppression parses a // comment found on line. It returns false
+// if the comment is not an //arch:ignore directive.
+func parseSuppression(comment string, line int) (Suppression, bool, error) {
+	rest, ok := strings.CutPrefix(comment, suppressionDirective)
+	if !ok || rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return Suppression{}, false, nil
+	}
+	rule, reason, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	reason = strings.TrimSpace(reason)
+	if rule == "" || reason == "" {
+		return Suppression{}, false, fmt.Errorf("line %d: %s needs a rule and a reason", line, suppressionDirective)
+	}
+	if strings.Contains(rule, `"`) {
+		return Suppression{}, false, fmt.Errorf("line %d: bad rule %q in %s", line, rule, suppressionDirective)
+	}
+	return Suppression{Rule: rule, Reason: reason, Line: line}, true, nil
+}
+
+// attachSuppressions scopes each suppression to the import on its own
+// line or, failing that, on the next one; importAt maps lines to imports.
+// The others apply to the whole file.
+func attachSuppressions(sups []Suppression, importAt map[int]string) []Suppression {
+	for i, s := range sups {
+		if imp, ok := importAt[s.Line]; ok {
+			sups[i].Import = imp
+		} else if imp, ok := importAt[s.Line+1]; ok {
+			sups[i].Import = imp
+		}
+	}
+	return sups
+}
+
+// suppressionFacts returns the exemption facts of a file's suppressions.
+func suppressionFacts(file PRFile) []string {
+	var facts []string
+	for _, s := range file.Suppressions {
+		if s.Import == "" {
+			facts = append(facts, fmt.Sprintf(`suppressed_file("%s", "%s")`, file.Path, s.Rule))
+		} else {
+			facts = append(facts, fmt.Sprintf(`suppressed("%s", "%s", "%s")`, file.Path, s.Rule, s.Import))
+		}
+	}
+	return facts
+}
+
+// BaselineEntry is one known violation.
+type BaselineEntry struct {
+	Rule   string `json:"rule"`
+	File   string `json:"file"`
+	Import string `json:"import,omitempty"`
+}
+
+// Baseline is the set of violations a codebase is allowed to keep.
+type Baseline struct {
+	Violations []BaselineEntry `json:"violations"`
+}
+
+// NewBaseline records violations, sorted and without duplicates. A halt
+// no compiled rule explains cannot be recorded.
+func NewBaseline(violations []Violation) *Baseline {
+	seen := make(map[BaselineEntry]bool)
+	b := &Baseline{Violations: []BaselineEntry{}}
+	for _, v := range violations {
+		e := BaselineEntry{Rule: v.Rule, File: v.File, Import: v.Import}
+		if v.Rule == haltRule || seen[e] {
+			continue
+		}
+		seen[e] = true
+		b.Violations = append(b.Violations, e)
+	}
+	sort.Slice(b.Violations, func(i, j int) bool {
+		x, y := b.Violations[i], b.Violations[j]
+		if x.File != y.File {
+			return x.File < y.File
+		}
+		if x.Rule != y.Rule {
+			return x.Rule < y.Rule
+		}
+		return x.Import < y.Import
+	})
+	return b
+}
+
+// LoadBaseline reads a baseline file.
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline: %w", err)
+	}
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline %s: %w", path, err)
+	}
+	for _, e := range b.Violations {
+		if e.Rule == "" || e.File == "" || strings.Contains(e.Rule+e.File+e.Import, `"`) {
+			return nil, fmt.Errorf("bad baseline entry in %s: %+v", path, e)
+		}
+	}
+	return &b, nil
+}
+
+// Save writes the baseline to path.
+func (b *Baseline) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write baseline: %w", err)
+	}
+	return nil
+}
+
+// facts returns the baselined facts of the entries.
+func (b *Baseline) facts() []string {
+	var facts []string
+	for _, e := range b.Violations {
+		facts = append(facts, fmt.Sprintf(`baselined("%s", "%s", "%s")`, e.Rule, e.File, e.Import))
+	}
+	return facts
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/duynguyendang/manglekit/core"
+)
+
+// --- LLM-backed extraction for free-form guidelines ---
+//
+// Guidelines written as prose do not fit the compiler's sentence forms.
+// LLMExtractor asks a model to read them and answer with the same Rule
+// records the compiler produces, so the Datalog is still rendered by
+// Guidelines.Policy and never written by the model. Because the model can
+// misread, its rules are only trusted after ValidatePolicy has replayed
+// the example PRs against them.
+
+const extractorPrompt = `Read the architecture guidelines below and list the rules they state.
+Answer with JSON only, in this form:
+{"layers": ["controllers/", ...],
+ "rules": [{"kind": "forbidden_import", "layer": "controllers/", "import": "domain/"},
+           {"kind": "naming", "layer": "controllers/", "suffix": "_controller.go"},
+           {"kind": "singular_names", "layer": "domain/"},
+           {"kind": "location", "layer": "domain/", "suffix": "_repository.go", "dir": "domain/repositories/"},
+           {"kind": "no_cycles"}, {"kind": "no_package_cycles"}]}
+Write every layer as its directory with a trailing slash. When a layer may
+import only some layers, list a forbidden_import rule for each of the others.
+
+Guidelines:
+`
+
+var suffixPattern = regexp.MustCompile(`^_[a-z_]+\.go$`)
+
+// LLMExtractor extracts rules from guidelines with a language model.
+type LLMExtractor struct {
+	LLM core.TextGenerator
+}
+
+// Extract asks the model for the rules of markdown and checks that its
+// answer is well-formed.
+func (e LLMExtractor) Extract(ctx context.Context, markdown string) (*Guidelines, error) {
+	reply, err := e.LLM.Complete(ctx, extractorPrompt+markdown)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract rules: %w", err)
+	}
+
+	// models like to wrap JSON in a code fence
+	reply = strings.TrimSpace(reply)
+	reply = strings.TrimPrefix(reply, "```json")
+	reply = strings.TrimPrefix(reply, "```")
+	reply = strings.TrimSuffix(reply, "```")
+
+	var g Guidelines
+	if err := json.Unmarshal([]byte(reply), &g); err != nil {
+		return nil, fmt.Errorf("failed to parse extracted rules: %w", err)
+	}
+	if err := g.check(); err != nil {
+		return nil, fmt.Errorf("extracted rules are invalid: %w", err)
+	}
+	return &g, nil
+}
+
+// check rejects rules that refer to undeclared layers or are incomplete.
+func (g *Guidelines) check() error {
+	if len(g.Layers) == 0 {
+		return fmt.Errorf("no layers")
+	}
+	if len(g.Rules) == 0 {
+		return fmt.Errorf("no rules")
+	}
+	known := make(map[string]bool)
+	for _, l := range g.Layers {
+		if !strings.HasSuffix(l, "/") {
+			return fmt.Errorf("layer %q must end with a slash", l)
+		}
+		known[l] = true
+	}
+	for i, r := range g.Rules {
+		switch r.Kind {
+		case RuleForbiddenImport:
+			if !known[r.Layer] || !known[r.Import] || r.Layer == r.Import {
+				return fmt.Errorf("rule %d: bad forbidden_import %s -> %s", i, r.Layer, r.Import)
+			}
+		case RuleNaming:
+			if !known[r.Layer] || !suffixPattern.MatchString(r.Suffix) {
+				return fmt.Errorf("rule %d: bad naming rule %s %q", i, r.Layer, r.Suffix)
+			}
+		case RuleSingularNames:
+			if !known[r.Layer] {
+				return fmt.Errorf("rule %d: bad singular_names rule %s", i, r.Layer)
+			}
+		case RuleLocation:
+			if !known[r.Layer] || !suffixPattern.MatchString(r.Suffix) || !strings.HasPrefix(r.Dir, r.Layer) || r.Dir == r.Layer || !strings.HasSuffix(r.Dir, "/") || strings.Contains(r.Dir, `"`) {
+				return fmt.Errorf("rule %d: bad location rule %s %q %q", i, r.Layer, r.Suffix, r.Dir)
+			}
+		case RuleNoCycles, RuleNoPackageCycles:
+		default:
+			return fmt.Errorf("rule %d: unknown kind %q", i, r.Kind)
+		}
+	}
+	return nil
+}
+
+// PolicyExample is a PR with a known verdict, used to validate rules.
+type PolicyExample struct {
+	PR      PullRequest
+	Blocked bool
+}
+
+// LoadPolicyExamples returns the example PRs: sample_pr.json, which
+// follows the guidelines, and violatingPR, which does not.
+func LoadPolicyExamples() ([]PolicyExample, error) {
+	data, err := os.ReadFile(filepath.Join(exampleDir(), "sample_pr.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sample_pr.json: %w", err)
+	}
+	var sample PullRequest
+	if err := json.Unmarshal(data, &sample); err != nil {
+		return nil, fmt.Errorf("failed to parse sample_pr.json: %w", err)
+	}
+	return []PolicyExample{
+		{PR: sample, Blocked: false},
+		{PR: violatingPR(), Blocked: true},
+	}, nil
+}
+
+// ValidatePolicy reviews every example with the rules of g and fails if
+// any verdict differs from the expected one.
+func ValidatePolicy(ctx context.Context, g *Guidelines, examples []PolicyExample) error {
+	linter := NewLinter(g)
+	for _, ex := range examples {
+		violations, err := linter.Review(ctx, ex.PR)
+		if err != nil {
+			return err
+		}
+		introduced, _ := splitExisting(violations)
+		if blocked := len(introduced) > 0; blocked != ex.Blocked {
+			verdict := "approves"
+			if blocked {
+				verdict = "rejects"
+			}
+			return fmt.Errorf("policy %s example %s (%s)", verdict, ex.PR.PRID, ex.PR.Title)
+		}
+	}
+	return nil
+}
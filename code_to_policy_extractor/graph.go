@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/duynguyendang/manglekit/sdk"
+)
+
+// --- Layer graph export ---
+//
+// With -graph dot or -graph mermaid the linter writes the reviewed code's
+// layer dependency graph instead of a report. It is built from the
+// file_path and file_import facts queried from the engine the review ran
+// on, so it shows exactly the dependencies the rules were evaluated on:
+// one edge per pair of layers, labelled with the number of files
+// importing across it. Edges the guidelines allow are drawn plainly, edges with a
+// violation are highlighted and edges whose violations are all in the
+// baseline are dashed, so reviewers see at a glance where a flagged
+// change breaks the architecture.
+
+// Graph formats accepted by -graph.
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+)
+
+// Kinds of layer graph edges.
+const (
+	EdgeAllowed   = "allowed"
+	EdgeViolating = "violating"
+	EdgeExisting  = "existing"
+	// EdgeUnchecked is neither allowed nor a violation, e.g. an import
+	// into a layer the guidelines list without its allowed dependencies.
+	EdgeUnchecked = "unchecked"
+)
+
+// LayerEdge is the dependency of one layer on another.
+type LayerEdge struct {
+	From  string
+	To    string
+	Files int
+	Kind  string
+}
+
+// LayerGraph is the layer dependency graph of a PR.
+type LayerGraph struct {
+	Layers []string
+	Edges  []LayerEdge
+}
+
+// ImportFact is a file_import fact joined with the file_path fact of its
+// file: File in layer Layer imports Import in layer ImportLayer.
+type ImportFact struct {
+	File        string
+	Layer       string
+	Import      string
+	ImportLayer string
+}
+
+// QueryImportFacts returns the import facts loaded into client, sorted by
+// file and import.
+func QueryImportFacts(ctx context.Context, client *sdk.Client) ([]ImportFact, error) {
+	paths, err := client.Engine().Query(ctx, nil, `file_path(F, L)`)
+	if err != nil {
+		return nil, fmt.Errorf("query file_path failed: %w", err)
+	}
+	layers := make(map[string]string)
+	for _, sol := range paths {
+		layers[unquote(sol["F"])] = unquote(sol["L"])
+	}
+	imports, err := client.Engine().Query(ctx, nil, `file_import(F, I, L)`)
+	if err != nil {
+		return nil, fmt.Errorf("query file_import failed: %w", err)
+	}
+	var facts []ImportFact
+	for _, sol := range imports {
+		file := unquote(sol["F"])
+		facts = append(facts, ImportFact{File: file, Layer: layers[file], Import: unquote(sol["I"]), ImportLayer: unquote(sol["L"])})
+	}
+	sort.Slice(facts, func(i, j int) bool {
+		a, b := facts[i], facts[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Import < b.Import
+	})
+	return facts, nil
+}
+
+// BuildLayerGraph builds the layer graph of the import facts a review
+// loaded, and classifies each edge with the guidelines' allowed
+// dependencies and the violations the review found.
+func BuildLayerGraph(g *Guidelines, imports []ImportFact, violations []Violation) *LayerGraph {
+	edges := make(map[[2]string][2]string)
+	files := make(map[[2]string]map[string]bool)
+	for _, f := range imports {
+		if f.Layer == f.ImportLayer || f.Layer == "unknown" || f.ImportLayer == "unknown" {
+			continue
+		}
+		e := [2]string{f.Layer, f.ImportLayer}
+		edges[[2]string{f.File, f.Import}] = e
+		if files[e] == nil {
+			files[e] = make(map[string]bool)
+		}
+		files[e][f.File] = true
+	}
+
+	violating := make(map[[2]string]bool)
+	existing := make(map[[2]string]bool)
+	for _, v := range violations {
+		if e, ok := edges[[2]string{v.File, v.Import}]; ok {
+			if v.Existing {
+				existing[e] = true
+			} else {
+				violating[e] = true
+			}
+		}
+	}
+
+	lg := &LayerGraph{Layers: g.Layers}
+	for e, fs := range files {
+		kind := EdgeUnchecked
+		switch {
+		case violating[e]:
+			kind = EdgeViolating
+		case existing[e]:
+			kind = EdgeExisting
+		case allows(g, e[0], e[1]):
+			kind = EdgeAllowed
+		}
+		lg.Edges = append(lg.Edges, LayerEdge{From: e[0], To: e[1], Files: len(fs), Kind: kind})
+	}
+	sort.Slice(lg.Edges, func(i, j int) bool {
+		a, b := lg.Edges[i], lg.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return lg
+}
+
+// allows reports whether the guidelines allow layer from to import to.
+func allows(g *Guidelines, from, to string) bool {
+	for _, l := range g.Allowed[from] {
+		if l == to {
+			return true
+		}
+	}
+	return false
+}
+
+// edgeLabel is the file count an edge is labelled with.
+func edgeLabel(e LayerEdge) string {
+	if e.Files == 1 {
+		return "1 file"
+	}
+	return fmt.Sprintf("%d files", e.Files)
+}
+
+// dotEdgeStyle are the DOT attributes of each kind of edge.
+var dotEdgeStyle = map[string]string{
+	EdgeAllowed:   `color="darkgreen"`,
+	EdgeViolating: `color="red", fontcolor="red", penwidth=2`,
+	EdgeExisting:  `color="orange", fontcolor="orange", style="dashed"`,
+	EdgeUnchecked: `color="gray50"`,
+}
+
+// WriteDOT writes the graph in Graphviz DOT.
+func WriteDOT(w io.Writer, lg *LayerGraph) error {
+	var b strings.Builder
+	b.WriteString("digraph architecture {\n\trankdir=TB;\n\tnode [shape=box];\n")
+	for _, l := range lg.Layers {
+		fmt.Fprintf(&b, "\t%q;\n", strings.TrimSuffix(l, "/"))
+	}
+	for _, e := range lg.Edges {
+		fmt.Fprintf(&b, "\t%q -> %q [label=%q, %s];\n", strings.TrimSuffix(e.From, "/"), strings.TrimSuffix(e.To, "/"), edgeLabel(e), dotEdgeStyle[e.Kind])
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mermaidEdgeStyle are the Mermaid arrow and link style of each kind of
+// edge.
+var mermaidEdgeStyle = map[string][2]string{
+	EdgeAllowed:   {"-->", "stroke:darkgreen"},
+	EdgeViolating: {"==>", "stroke:red,stroke-width:3px"},
+	EdgeExisting:  {"-.->", "stroke:orange"},
+	EdgeUnchecked: {"-->", "stroke:gray"},
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart. Layers get
+// generated node IDs, so any layer name is safe to use.
+func WriteMermaid(w io.Writer, lg *LayerGraph) error {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	ids := make(map[string]string)
+	node := func(layer string) string {
+		id, ok := ids[layer]
+		if !ok {
+			id = fmt.Sprintf("L%d", len(ids))
+			ids[layer] = id
+			fmt.Fprintf(&b, "\t%s[%q]\n", id, strings.TrimSuffix(layer, "/"))
+		}
+		return id
+	}
+	for _, l := range lg.Layers {
+		node(l)
+	}
+	for _, e := range lg.Edges {
+		from, to := node(e.From), node(e.To)
+		fmt.Fprintf(&b, "\t%s %s|%q| %s\n", from, mermaidEdgeStyle[e.Kind][0], edgeLabel(e), to)
+	}
+	for i, e := range lg.Edges {
+		fmt.Fprintf(&b, "\tlinkStyle %d %s\n", i, mermaidEdgeStyle[e.Kind][1])
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteGraph writes the graph in a -graph format.
+func WriteGraph(w io.Writer, format string, lg *LayerGraph) error {
+	switch format {
+	case GraphDOT:
+		return WriteDOT(w, lg)
+	case GraphMermaid:
+		return WriteMermaid(w, lg)
+	default:
+		return fmt.Errorf("unknown graph format %q", format)
+	}
+}
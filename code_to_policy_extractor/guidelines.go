@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// --- Compiling architecture_guidelines.md into Datalog ---
+//
+// The compiler understands the rule sentences of the guidelines and
+// nothing else: bullets under "Dependency Rules" and "File Naming
+// Conventions", plus the numbered layer list under "Layer Structure".
+// Every such bullet must compile; a sentence it cannot read is an error
+// rather than a rule that is quietly not enforced. Guidance that is not
+// meant to be linted is written as prose, outside the bullets.
+//
+// Recognized sentences:
+//
+//	controllers → usecases (only)        controllers may import nothing else
+//	domain → (nothing, must be pure)     domain may import no other layer
+//	usecases → domain, gateways          allowed, nothing is forbidden
+//	controllers must NOT import domain   forbidden ("directly" is allowed)
+//	domain must NOT import any other layer
+//	No circular dependencies between any layers   (between layers and
+//	                                               between packages)
+//	Must be located in `controllers/` directory   (names the layer of a
+//	Must end with `_controller.go`                 naming subsection)
+//	Must be singular nouns (e.g., ...)   no file name whose last word
+//	                                     is plural, see pluralName
+//	Repository interfaces must be in `domain/repositories/`
+//	                                     the layer's _repository.go files
+//	                                     live in that directory
+//	Example: ...                         illustration, ignored
+
+// Rule kinds.
+const (
+	RuleForbiddenImport = "forbidden_import"
+	RuleNaming          = "naming"
+	RuleNoCycles        = "no_cycles"
+	RuleNoPackageCycles = "no_package_cycles"
+	RuleSingularNames   = "singular_names"
+	RuleLocation        = "location"
+)
+
+// Rule is one lint rule compiled from the guidelines. Layers are written
+// as LayerMap.Layer returns them, e.g. "controllers/".
+type Rule struct {
+	Kind string `json:"kind"`
+	// Layer is the importing layer, or the layer a naming rule covers.
+	Layer string `json:"layer,omitempty"`
+	// Import is the layer a forbidden_import rule forbids.
+	Import string `json:"import,omitempty"`
+	// Suffix is the file name ending a naming rule requires, or the one
+	// a location rule places.
+	Suffix string `json:"suffix,omitempty"`
+	// Dir is the directory a location rule requires, e.g.
+	// "domain/repositories/".
+	Dir string `json:"dir,omitempty"`
+}
+
+// ID names the rule in violation reports, e.g.
+// "controllers-must-not-import-domain".
+func (r Rule) ID() string {
+	switch r.Kind {
+	case RuleForbiddenImport:
+		return strings.TrimSuffix(r.Layer, "/") + "-must-not-import-" + strings.TrimSuffix(r.Import, "/")
+	case RuleNaming:
+		return strings.TrimSuffix(r.Layer, "/") + "-naming"
+	case RuleSingularNames:
+		return strings.TrimSuffix(r.Layer, "/") + "-singular-names"
+	case RuleLocation:
+		return strings.TrimSuffix(r.Layer, "/") + "-" + strings.TrimSuffix(strings.TrimPrefix(r.Suffix, "_"), ".go") + "-location"
+	case RuleNoCycles:
+		return "no-layer-cycles"
+	case RuleNoPackageCycles:
+		return "no-package-cycles"
+	default:
+		return r.Kind
+	}
+}
+
+// Message is the halt message the rule produces.
+func (r Rule) Message() string {
+	switch r.Kind {
+	case RuleForbiddenImport:
+		return fmt.Sprintf("Clean Architecture violation: %s must not import %s", strings.TrimSuffix(r.Layer, "/"), strings.TrimSuffix(r.Import, "/"))
+	case RuleNaming:
+		return fmt.Sprintf("Naming convention violation: %s files must end with %s", strings.TrimSuffix(r.Layer, "/"), r.Suffix)
+	case RuleSingularNames:
+		return fmt.Sprintf("Naming convention violation: %s files must be named after singular nouns", strings.TrimSuffix(r.Layer, "/"))
+	case RuleLocation:
+		return fmt.Sprintf("Clean Architecture violation: %s %s files must be in %s", strings.TrimSuffix(r.Layer, "/"), r.Suffix, r.Dir)
+	case RuleNoCycles:
+		return "Clean Architecture violation: circular dependency between layers"
+	case RuleNoPackageCycles:
+		return "Clean Architecture violation: circular dependency between packages"
+	default:
+		return r.Kind
+	}
+}
+
+// Guidelines is the compiled form of an architecture guidelines document.
+type Guidelines struct {
+	Layers []string `json:"layers"`
+	// Allowed lists the dependencies the guidelines explicitly allow.
+	Allowed map[string][]string `json:"-"`
+	Rules   []Rule              `json:"rules"`
+	// Map places files in layers; nil means one directory per layer,
+	// see layerMapOf.
+	Map *LayerMap `json:"-"`
+}
+
+var (
+	layerItem     = regexp.MustCompile(`^\d+\.\s+\*\*([a-z_]+)\*\*`)
+	allowedDep    = regexp.MustCompile(`^([a-z_]+)\s*(?:→|->)\s*(.+)$`)
+	forbiddenDep  = regexp.MustCompile(`^([a-z_]+) must NOT import (.+?)(?: directly)?$`)
+	noCycles      = regexp.MustCompile(`^No circular dependencies between any layers$`)
+	locatedIn     = regexp.MustCompile("^Must be located in `([a-z_]+)/` directory$")
+	mustEndWith   = regexp.MustCompile("^Must end with `(_[a-z_]+\\.go)`$")
+	singularNouns = regexp.MustCompile(`^Must be singular nouns(?: \(e\.g\., .*\))?$`)
+	mustBeIn      = regexp.MustCompile("^([A-Z][a-z]+) interfaces must be in `([a-z_]+(?:/[a-z_]+)*/)`$")
+	exampleBullet = regexp.MustCompile(`^Example: `)
+)
+
+// CompileGuidelines compiles the rule sentences of a guidelines document.
+func CompileGuidelines(markdown string) (*Guidelines, error) {
+	g := &Guidelines{Allowed: make(map[string][]string)}
+	known := make(map[string]bool)
+	forbidden := make(map[[2]string]bool)
+
+	layer := func(lineNo int, name string) (string, error) {
+		name = strings.TrimSuffix(strings.TrimSpace(name), "/")
+		if !known[name] {
+			return "", fmt.Errorf("line %d: unknown layer %q", lineNo, name)
+		}
+		return name + "/", nil
+	}
+	forbid := func(from, to string) {
+		if from == to || forbidden[[2]string{from, to}] {
+			return
+		}
+		forbidden[[2]string{from, to}] = true
+		g.Rules = append(g.Rules, Rule{Kind: RuleForbiddenImport, Layer: from, Import: to})
+	}
+
+	var section, subsectionLayer string
+	inCode := false
+	scanner := bufio.NewScanner(strings.NewReader(markdown))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		switch {
+		case inCode:
+			continue
+		case strings.HasPrefix(line, "## "):
+			section, subsectionLayer = strings.TrimPrefix(line, "## "), ""
+			continue
+		case strings.HasPrefix(line, "### "):
+			subsectionLayer = ""
+			continue
+		}
+
+		if section == "Layer Structure" {
+			if m := layerItem.FindStringSubmatch(line); m != nil {
+				known[m[1]] = true
+				g.Layers = append(g.Layers, m[1]+"/")
+			}
+			continue
+		}
+		sentence, ok := strings.CutPrefix(line, "- ")
+		if !ok || (section != "Dependency Rules" && section != "File Naming Conventions") {
+			continue
+		}
+		sentence = strings.TrimSpace(sentence)
+
+		switch section {
+		case "Dependency Rules":
+			if m := forbiddenDep.FindStringSubmatch(sentence); m != nil {
+				from, err := layer(lineNo, m[1])
+				if err != nil {
+					return nil, err
+				}
+				if m[2] == "any other layer" {
+					for _, to := range g.Layers {
+						forbid(from, to)
+					}
+					continue
+				}
+				to, err := layer(lineNo, m[2])
+				if err != nil {
+					return nil, err
+				}
+				forbid(from, to)
+			} else if m := allowedDep.FindStringSubmatch(sentence); m != nil {
+				from, err := layer(lineNo, m[1])
+				if err != nil {
+					return nil, err
+				}
+				targets, only := strings.CutSuffix(m[2], "(only)")
+				allowed := make(map[string]bool)
+				if strings.HasPrefix(targets, "(nothing") {
+					only = true
+				} else {
+					for _, name := range strings.Split(targets, ",") {
+						to, err := layer(lineNo, name)
+						if err != nil {
+							return nil, err
+						}
+						allowed[to] = true
+						g.Allowed[from] = append(g.Allowed[from], to)
+					}
+				}
+				if only {
+					for _, to := range g.Layers {
+						if !allowed[to] {
+							forbid(from, to)
+						}
+					}
+				}
+			} else if noCycles.MatchString(sentence) {
+				g.Rules = append(g.Rules, Rule{Kind: RuleNoCycles}, Rule{Kind: RuleNoPackageCycles})
+			} else {
+				return nil, fmt.Errorf("line %d: cannot compile dependency rule %q", lineNo, sentence)
+			}
+
+		case "File Naming Conventions":
+			if m := locatedIn.FindStringSubmatch(sentence); m != nil {
+				l, err := layer(lineNo, m[1])
+				if err != nil {
+					return nil, err
+				}
+				subsectionLayer = l
+			} else if m := mustEndWith.FindStringSubmatch(sentence); m != nil {
+				if subsectionLayer == "" {
+					return nil, fmt.Errorf("line %d: naming rule %q comes before the layer's \"Must be located in\" line", lineNo, sentence)
+				}
+				g.Rules = append(g.Rules, Rule{Kind: RuleNaming, Layer: subsectionLayer, Suffix: m[1]})
+			} else if singularNouns.MatchString(sentence) {
+				if subsectionLayer == "" {
+					return nil, fmt.Errorf("line %d: naming rule %q comes before the layer's \"Must be located in\" line", lineNo, sentence)
+				}
+				g.Rules = append(g.Rules, Rule{Kind: RuleSingularNames, Layer: subsectionLayer})
+			} else if m := mustBeIn.FindStringSubmatch(sentence); m != nil {
+				if subsectionLayer == "" {
+					return nil, fmt.Errorf("line %d: location rule %q comes before the layer's \"Must be located in\" line", lineNo, sentence)
+				}
+				if !strings.HasPrefix(m[2], subsectionLayer) || m[2] == subsectionLayer {
+					return nil, fmt.Errorf("line %d: %s is not a directory inside %s", lineNo, m[2], subsectionLayer)
+				}
+				g.Rules = append(g.Rules, Rule{Kind: RuleLocation, Layer: subsectionLayer, Suffix: "_" + strings.ToLower(m[1]) + ".go", Dir: m[2]})
+			} else if !exampleBullet.MatchString(sentence) {
+				return nil, fmt.Errorf("line %d: cannot compile naming rule %q", lineNo, sentence)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read guidelines: %w", err)
+	}
+	if len(g.Layers) == 0 {
+		return nil, fmt.Errorf("no layers found under \"## Layer Structure\"")
+	}
+	if len(g.Rules) == 0 {
+		return nil, fmt.Errorf("no rules found in the guidelines")
+	}
+	return g, nil
+}
+
+// Policy renders the rules as Datalog over the facts built by buildFacts.
+// Each rule derives violation(RuleID, File, Import) facts and halts the
+// review when any of them is not exempt.
+func (g *Guidelines) Policy() string {
+	var b strings.Builder
+	b.WriteString(`% ============================================
+% Compiled from architecture_guidelines.md
+% ============================================
+
+Decl file_path(File, Layer).
+Decl file_imports(File, ImportLayer).
+Decl file_import(File, Import, ImportLayer).
+Decl file_name_matches(File, Suffix).
+Decl file_suffix(File, Suffix).
+Decl file_dir(File, Dir).
+Decl file_plural_name(File).
+Decl file_preexisting(File).
+Decl file_package(File, Package).
+Decl package_imports(Package, ImportedPackage).
+Decl suppressed(File, Rule, Import).
+Decl suppressed_file(File, Rule).
+Decl baselined(Rule, File, Import).
+`)
+	for _, r := range g.Rules {
+		b.WriteString("\n")
+		switch r.Kind {
+		case RuleForbiddenImport:
+			fmt.Fprintf(&b, "violation(%q, File, Import) :-\n\tfile_path(File, %q),\n\tfile_import(File, Import, %q).\n", r.ID(), r.Layer, r.Import)
+		case RuleNaming:
+			// only files the change introduces, see diff.go
+			fmt.Fprintf(&b, "violation(%q, File, \"\") :-\n\tfile_path(File, %q),\n\t!file_name_matches(File, %q),\n\t!file_preexisting(File).\n", r.ID(), r.Layer, r.Suffix)
+		case RuleSingularNames:
+			fmt.Fprintf(&b, "violation(%q, File, \"\") :-\n\tfile_path(File, %q),\n\tfile_plural_name(File),\n\t!file_preexisting(File).\n", r.ID(), r.Layer)
+		case RuleLocation:
+			fmt.Fprintf(&b, "violation(%q, File, \"\") :-\n\tfile_path(File, %q),\n\tfile_suffix(File, %q),\n\t!file_dir(File, %q),\n\t!file_preexisting(File).\n", r.ID(), r.Layer, r.Suffix, r.Dir)
+		case RuleNoCycles:
+			// every import that closes a loop back to its own layer
+			fmt.Fprintf(&b, "violation(%q, File, Import) :-\n\tfile_path(File, A),\n\tfile_import(File, Import, B),\n\tlayer(A), layer(B), A != B,\n\tlayer_depends_star(B, A).\n", r.ID())
+		case RuleNoPackageCycles:
+			// every import on a cycle, i.e. every edge of some
+			// depends_star(P, P)
+			fmt.Fprintf(&b, "violation(%q, File, Import) :-\n\tfile_package(File, P),\n\tfile_import(File, Import, _),\n\tdepends_star(Import, P).\n", r.ID())
+		}
+		fmt.Fprintf(&b, "halt(\"Req\", %q) :-\n\taction_operation(\"Req\", \"review_pr\"),\n\tviolation(%q, File, Import),\n\t!exempt(%q, File, Import).\n", r.Message(), r.ID(), r.ID())
+	}
+
+	if g.has(RuleNoCycles) {
+		b.WriteString("\n% Layer dependency graph and its transitive closure\n")
+		for _, l := range g.Layers {
+			fmt.Fprintf(&b, "layer(%q).\n", l)
+		}
+		b.WriteString(`layer_depends(A, B) :- file_path(F, A), file_imports(F, B), layer(A), layer(B), A != B.
+layer_depends_star(A, B) :- layer_depends(A, B).
+layer_depends_star(A, C) :- layer_depends(A, B), layer_depends_star(B, C).
+`)
+	}
+	if g.has(RuleNoPackageCycles) {
+		b.WriteString(`
+% Package dependency graph and its transitive closure
+depends(A, B) :- package_imports(A, B).
+depends_star(A, B) :- depends(A, B).
+depends_star(A, C) :- depends(A, B), depends_star(B, C).
+`)
+	}
+	b.WriteString(`
+% Exemptions: //arch:ignore comments and the baseline, see exemptions.go
+ignored(Rule, File, Import) :- violation(Rule, File, Import), suppressed(File, Rule, Import).
+ignored(Rule, File, Import) :- violation(Rule, File, Import), suppressed_file(File, Rule).
+existing(Rule, File, Import) :- violation(Rule, File, Import), baselined(Rule, File, Import).
+exempt(Rule, File, Import) :- ignored(Rule, File, Import).
+exempt(Rule, File, Import) :- existing(Rule, File, Import).
+`)
+	return b.String()
+}
+
+func (g *Guidelines) has(kind string) bool {
+	for _, r := range g.Rules {
+		if r.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadGuidelines reads and compiles a guidelines document.
+func LoadGuidelines(path string) (*Guidelines, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read guidelines: %w", err)
+	}
+	g, err := CompileGuidelines(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile %s: %w", filepath.Base(path), err)
+	}
+	return g, nil
+}
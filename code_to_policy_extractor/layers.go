@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// --- Configurable layer map ---
+//
+// Which layer a file or package belongs to, and which file name suffix the
+// layer requires, comes from a LayerMap: an ordered list of layers, each
+// with glob patterns over module-relative paths. "**" matches any number
+// of path segments, so "internal/**/domain/**" finds a domain package at
+// any depth. The first layer with a matching pattern wins; paths no layer
+// matches are "unknown" and never checked.
+//
+// A layer config file (JSON) adds the allowed-dependency matrix, from
+// which the rules are generated, so a hexagonal or onion layout needs a
+// new config rather than new code:
+//
+//	{
+//	  "layers": [
+//	    {"name": "core", "paths": ["internal/core/**"]},
+//	    {"name": "adapters", "paths": ["internal/adapters/**"], "suffix": "_adapter.go"}
+//	  ],
+//	  "allowed": {"core": [], "adapters": ["core"]},
+//	  "no_cycles": true,
+//	  "singular_names": ["core"],
+//	  "locations": [{"layer": "core", "suffix": "_repository.go", "dir": "internal/core/repositories/"}]
+//	}
+
+// LayerDef is one layer of a LayerMap.
+type LayerDef struct {
+	Name string `json:"name"`
+	// Paths are glob patterns over module-relative paths.
+	Paths []string `json:"paths"`
+	// Suffix is the file name ending the layer's files need, if any.
+	Suffix string `json:"suffix,omitempty"`
+}
+
+// LayerMap assigns paths to layers.
+type LayerMap struct {
+	Layers []LayerDef `json:"layers"`
+}
+
+// Layer returns the layer of a file or package path, written with a
+// trailing slash as the rules use it ("domain/"), or "unknown".
+func (m *LayerMap) Layer(p string) string {
+	for _, l := range m.Layers {
+		for _, pattern := range l.Paths {
+			if matchGlob(pattern, p) {
+				return l.Name + "/"
+			}
+		}
+	}
+	return "unknown"
+}
+
+// Suffix returns the file name suffix layer requires, or "".
+func (m *LayerMap) Suffix(layer string) string {
+	for _, l := range m.Layers {
+		if l.Name+"/" == layer {
+			return l.Suffix
+		}
+	}
+	return ""
+}
+
+// matchGlob reports whether p matches pattern, segment by segment, with
+// "**" standing for zero or more segments.
+func matchGlob(pattern, p string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(p, "/"))
+}
+
+func matchSegments(pattern, segs []string) bool {
+	if len(pattern) == 0 {
+		return len(segs) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchSegments(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segs[0])
+	return ok && matchSegments(pattern[1:], segs[1:])
+}
+
+// layerMapOf returns the layer map of the guidelines: the one from their
+// config file, or one directory per layer with the suffixes of the naming
+// rules for guidelines compiled from markdown.
+func layerMapOf(g *Guidelines) *LayerMap {
+	if g.Map != nil {
+		return g.Map
+	}
+	m := &LayerMap{}
+	for _, layer := range g.Layers {
+		name := strings.TrimSuffix(layer, "/")
+		def := LayerDef{Name: name, Paths: []string{name + "/**"}}
+		for _, r := range g.Rules {
+			if r.Kind == RuleNaming && r.Layer == layer {
+				def.Suffix = r.Suffix
+			}
+		}
+		m.Layers = append(m.Layers, def)
+	}
+	return m
+}
+
+// layerConfig is the layer config file.
+type layerConfig struct {
+	LayerMap
+	// Allowed lists, per layer, the other layers it may import.
+	Allowed  map[string][]string `json:"allowed"`
+	NoCycles bool                `json:"no_cycles"`
+	// SingularNames lists the layers whose file names must be singular
+	// nouns.
+	SingularNames []string `json:"singular_names,omitempty"`
+	// Locations place a layer's files with a suffix in one directory.
+	Locations []locationConfig `json:"locations,omitempty"`
+}
+
+// locationConfig is one location rule of the layer config file.
+type locationConfig struct {
+	Layer  string `json:"layer"`
+	Suffix string `json:"suffix"`
+	Dir    string `json:"dir"`
+}
+
+// LoadLayerConfig reads a layer config file and generates its rules: a
+// forbidden import for every pair of layers the matrix does not allow, a
+// naming rule per suffix, the singular name and location rules it lists
+// and, with no_cycles, the cycle rules.
+func LoadLayerConfig(file string) (*Guidelines, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layer config: %w", err)
+	}
+	var cfg layerConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse layer config %s: %w", file, err)
+	}
+	g, err := cfg.guidelines()
+	if err != nil {
+		return nil, fmt.Errorf("invalid layer config %s: %w", file, err)
+	}
+	return g, nil
+}
+
+func (cfg *layerConfig) guidelines() (*Guidelines, error) {
+	if len(cfg.Layers) == 0 {
+		return nil, fmt.Errorf("no layers")
+	}
+	known := make(map[string]bool)
+	for _, l := range cfg.Layers {
+		if l.Name == "" || strings.ContainsAny(l.Name, `/"`) {
+			return nil, fmt.Errorf("bad layer name %q", l.Name)
+		}
+		if known[l.Name] {
+			return nil, fmt.Errorf("layer %q is defined twice", l.Name)
+		}
+		known[l.Name] = true
+		if len(l.Paths) == 0 {
+			return nil, fmt.Errorf("layer %q has no paths", l.Name)
+		}
+		for _, pattern := range l.Paths {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("layer %q: bad path pattern %q", l.Name, pattern)
+			}
+		}
+		if l.Suffix != "" && !suffixPattern.MatchString(l.Suffix) {
+			return nil, fmt.Errorf("layer %q: bad suffix %q", l.Name, l.Suffix)
+		}
+	}
+	for from, targets := range cfg.Allowed {
+		if !known[from] {
+			return nil, fmt.Errorf("allowed: unknown layer %q", from)
+		}
+		for _, to := range targets {
+			if !known[to] {
+				return nil, fmt.Errorf("allowed: %s may import unknown layer %q", from, to)
+			}
+		}
+	}
+
+	g := &Guidelines{Allowed: make(map[string][]string), Map: &cfg.LayerMap}
+	for _, l := range cfg.Layers {
+		g.Layers = append(g.Layers, l.Name+"/")
+	}
+	for _, l := range cfg.Layers {
+		targets, ok := cfg.Allowed[l.Name]
+		if !ok {
+			// an unlisted layer would silently be unchecked
+			return nil, fmt.Errorf("allowed: no entry for layer %q (use [] for none)", l.Name)
+		}
+		allowed := make(map[string]bool)
+		for _, to := range targets {
+			allowed[to] = true
+			g.Allowed[l.Name+"/"] = append(g.Allowed[l.Name+"/"], to+"/")
+		}
+		for _, other := range cfg.Layers {
+			if other.Name != l.Name && !allowed[other.Name] {
+				g.Rules = append(g.Rules, Rule{Kind: RuleForbiddenImport, Layer: l.Name + "/", Import: other.Name + "/"})
+			}
+		}
+	}
+	if cfg.NoCycles {
+		g.Rules = append(g.Rules, Rule{Kind: RuleNoCycles}, Rule{Kind: RuleNoPackageCycles})
+	}
+	for _, l := range cfg.Layers {
+		if l.Suffix != "" {
+			g.Rules = append(g.Rules, Rule{Kind: RuleNaming, Layer: l.Name + "/", Suffix: l.Suffix})
+		}
+	}
+	for _, name := range cfg.SingularNames {
+		if !known[name] {
+			return nil, fmt.Errorf("singular_names: unknown layer %q", name)
+		}
+		g.Rules = append(g.Rules, Rule{Kind: RuleSingularNames, Layer: name + "/"})
+	}
+	for _, loc := range cfg.Locations {
+		if !known[loc.Layer] {
+			return nil, fmt.Errorf("locations: unknown layer %q", loc.Layer)
+		}
+		if !suffixPattern.MatchString(loc.Suffix) || !strings.HasSuffix(loc.Dir, "/") || strings.Contains(loc.Dir, `"`) {
+			return nil, fmt.Errorf("locations: bad location %q in %q for layer %q", loc.Suffix, loc.Dir, loc.Layer)
+		}
+		g.Rules = append(g.Rules, Rule{Kind: RuleLocation, Layer: loc.Layer + "/", Suffix: loc.Suffix, Dir: loc.Dir})
+	}
+	return g, nil
+}
@@ -1,15 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/duynguyendang/manglekit/core"
 	"github.com/duynguyendang/manglekit/sdk"
 )
 
-func TestGetLayer(t *testing.T) {
+// defaultLayers is the Clean Architecture layout of
+// architecture_guidelines.md: one top-level directory per layer.
+var defaultLayers = &LayerMap{Layers: []LayerDef{
+	{Name: "controllers", Paths: []string{"controllers/**"}, Suffix: "_controller.go"},
+	{Name: "usecases", Paths: []string{"usecases/**"}, Suffix: "_usecase.go"},
+	{Name: "domain", Paths: []string{"domain/**"}},
+	{Name: "gateways", Paths: []string{"gateways/**"}, Suffix: "_gateway.go"},
+}}
+
+func TestLayerMap_Layer(t *testing.T) {
 	tests := []struct {
 		path string
 		want string
@@ -22,14 +39,14 @@ func TestGetLayer(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.path, func(t *testing.T) {
-			if got := getLayer(tt.path); got != tt.want {
-				t.Errorf("getLayer(%q) = %q, want %q", tt.path, got, tt.want)
+			if got := defaultLayers.Layer(tt.path); got != tt.want {
+				t.Errorf("Layer(%q) = %q, want %q", tt.path, got, tt.want)
 			}
 		})
 	}
 }
 
-func TestHasValidName(t *testing.T) {
+func TestLayerMap_NameMatchesSuffix(t *testing.T) {
 	tests := []struct {
 		path string
 		want bool
@@ -41,43 +58,41 @@ func TestHasValidName(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.path, func(t *testing.T) {
-			if got := hasValidName(tt.path); got != tt.want {
-				t.Errorf("hasValidName(%q) = %v, want %v", tt.path, got, tt.want)
+			suffix := defaultLayers.Suffix(defaultLayers.Layer(tt.path))
+			if got := strings.HasSuffix(tt.path, suffix); got != tt.want {
+				t.Errorf("%q ends with %q = %v, want %v", tt.path, suffix, got, tt.want)
 			}
 		})
 	}
 }
 
-func TestGetSuffix(t *testing.T) {
+func TestLayerMap_Suffix(t *testing.T) {
 	tests := []struct {
 		path string
 		want string
 	}{
 		{"controllers/auth_controller.go", "_controller.go"},
 		{"usecases/auth_usecase.go", "_usecase.go"},
+		{"gateways/jwt_gateway.go", "_gateway.go"},
 		{"domain/user.go", ""},
 	}
 	for _, tt := range tests {
 		t.Run(tt.path, func(t *testing.T) {
-			if got := getSuffix(tt.path); got != tt.want {
-				t.Errorf("getSuffix(%q) = %q, want %q", tt.path, got, tt.want)
+			if got := defaultLayers.Suffix(defaultLayers.Layer(tt.path)); got != tt.want {
+				t.Errorf("Suffix(Layer(%q)) = %q, want %q", tt.path, got, tt.want)
 			}
 		})
 	}
 }
 
-func buildFacts(pr PullRequest) []string {
-	var facts []string
-	for _, file := range pr.Files {
-		facts = append(facts, fmt.Sprintf(`file_path("%s", "%s")`, file.Path, getLayer(file.Path)))
-		for _, imp := range file.Imports {
-			facts = append(facts, fmt.Sprintf(`file_imports("%s", "%s")`, file.Path, getLayer(imp)))
-		}
-		if hasValidName(file.Path) {
-			facts = append(facts, fmt.Sprintf(`file_name_matches("%s", "%s")`, file.Path, getSuffix(file.Path)))
-		}
+// compiledPolicy compiles the example's architecture_guidelines.md.
+func compiledPolicy(t *testing.T) string {
+	t.Helper()
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatalf("Failed to compile guidelines: %v", err)
 	}
-	return facts
+	return g.Policy()
 }
 
 func TestPolicyEngine_PassingPR(t *testing.T) {
@@ -89,7 +104,7 @@ func TestPolicyEngine_PassingPR(t *testing.T) {
 	}
 	defer client.Shutdown(ctx)
 
-	if err := client.Engine().LoadPolicy(ctx, archPolicy); err != nil {
+	if err := client.Engine().LoadPolicy(ctx, compiledPolicy(t)); err != nil {
 		t.Fatalf("Failed to load policy: %v", err)
 	}
 
@@ -113,7 +128,7 @@ func TestPolicyEngine_PassingPR(t *testing.T) {
 		},
 	}
 
-	facts := buildFacts(passingPR)
+	facts := buildFacts(passingPR, defaultLayers)
 	if err := client.LoadFacts(facts); err != nil {
 		t.Fatalf("Failed to load facts: %v", err)
 	}
@@ -134,7 +149,7 @@ func TestPolicyEngine_ViolatingPR(t *testing.T) {
 	}
 	defer client.Shutdown(ctx)
 
-	if err := client.Engine().LoadPolicy(ctx, archPolicy); err != nil {
+	if err := client.Engine().LoadPolicy(ctx, compiledPolicy(t)); err != nil {
 		t.Fatalf("Failed to load policy: %v", err)
 	}
 
@@ -154,7 +169,7 @@ func TestPolicyEngine_ViolatingPR(t *testing.T) {
 		},
 	}
 
-	facts := buildFacts(violatingPR)
+	facts := buildFacts(violatingPR, defaultLayers)
 	if err := client.LoadFacts(facts); err != nil {
 		t.Fatalf("Failed to load facts: %v", err)
 	}
@@ -165,3 +180,1326 @@ func TestPolicyEngine_ViolatingPR(t *testing.T) {
 		t.Errorf("Expected violating PR to be blocked, but got: %v", err)
 	}
 }
+
+func TestAnalyzeModule(t *testing.T) {
+	files, err := AnalyzeModule(filepath.Join(exampleDir(), "testdata", "shop"))
+	if err != nil {
+		t.Fatalf("AnalyzeModule failed: %v", err)
+	}
+
+	// vendor/ and the nested tools module are skipped; stdlib and
+	// third-party imports are dropped
+	want := []PRFile{
+		{Path: "controllers/legacy.go", Imports: []string{}},
+		{Path: "controllers/order_controller.go", Imports: []string{"domain", "usecases"}, ImportLines: map[string]int{"domain": 6, "usecases": 7}},
+		{Path: "domain/order.go", Imports: []string{}},
+		{Path: "gateways/payment_gateway.go", Imports: []string{"domain"}, ImportLines: map[string]int{"domain": 3}},
+		{Path: "usecases/order_usecase.go", Imports: []string{"domain"}, ImportLines: map[string]int{"domain": 6}},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("AnalyzeModule() = %+v, want %+v", files, want)
+	}
+}
+
+func TestAnalyzeModule_RequiresGoMod(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AnalyzeModule(dir); err == nil {
+		t.Error("expected an error for a directory without go.mod")
+	}
+}
+
+func TestPolicyEngine_ModuleCheckout(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := sdk.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("Failed to initialize client: %v", err)
+	}
+	defer client.Shutdown(ctx)
+
+	if err := client.Engine().LoadPolicy(ctx, compiledPolicy(t)); err != nil {
+		t.Fatalf("Failed to load policy: %v", err)
+	}
+
+	files, err := AnalyzeModule(filepath.Join(exampleDir(), "testdata", "shop"))
+	if err != nil {
+		t.Fatalf("AnalyzeModule failed: %v", err)
+	}
+	modulePR := PullRequest{PRID: "local", Files: files}
+	if err := client.LoadFacts(buildFacts(modulePR, defaultLayers)); err != nil {
+		t.Fatalf("Failed to load facts: %v", err)
+	}
+
+	// the controller imports domain directly
+	env := core.NewEnvelope(modulePR)
+	err = client.Engine().Assess(ctx, core.ActionMetadata{Name: "review_pr"}, env)
+	if !core.IsAlignmentError(err) {
+		t.Errorf("Expected module checkout to be blocked, but got: %v", err)
+	}
+}
+
+func parseDiffFixture(t *testing.T, name string) []PRFile {
+	t.Helper()
+	f, err := os.Open(filepath.Join(exampleDir(), "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	files, err := ParseDiff(f, "example.com/shop")
+	if err != nil {
+		t.Fatalf("ParseDiff failed: %v", err)
+	}
+	return files
+}
+
+func TestParseDiff(t *testing.T) {
+	files := parseDiffFixture(t, "clean_change.diff")
+
+	// the domain import already in order_controller.go is not part of
+	// the change, so it is not reported
+	want := []PRFile{
+		{Path: "controllers/legacy.go", Imports: []string{"usecases"}, ImportLines: map[string]int{"usecases": 6}, AddedLines: 6, DeletedLines: 1, Status: StatusModified},
+		{Path: "controllers/order_controller.go", Imports: []string{}, AddedLines: 1, Status: StatusModified},
+		{Path: "gateways/payment_gateway.go", Imports: []string{}, DeletedLines: 5, Status: StatusDeleted},
+		{Path: "usecases/refund_usecase.go", Imports: []string{"domain"}, ImportLines: map[string]int{"domain": 3}, AddedLines: 7, Status: StatusAdded},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("ParseDiff() = %+v, want %+v", files, want)
+	}
+}
+
+func TestParseDiff_MovedImportIsNotNew(t *testing.T) {
+	diff := `diff --git a/usecases/order_usecase.go b/usecases/order_usecase.go
+index 1111111..2222222 100644
+--- a/usecases/order_usecase.go
++++ b/usecases/order_usecase.go
+@@ -1,5 +1,5 @@
+ package usecases
+ 
+-import "example.com/shop/domain"
++import dom "example.com/shop/domain"
+ 
+-var names = []string{"a"}
++var names = []string{"example.com/shop/gateways"}
+`
+	files, err := ParseDiff(strings.NewReader(diff), "example.com/shop")
+	if err != nil {
+		t.Fatalf("ParseDiff failed: %v", err)
+	}
+	if len(files) != 1 || len(files[0].Imports) != 0 {
+		t.Errorf("expected no new imports, got %+v", files)
+	}
+}
+
+func TestParseDiff_RejectsTruncatedHunk(t *testing.T) {
+	diff := "diff --git a/domain/user.go b/domain/user.go\n--- a/domain/user.go\n+++ b/domain/user.go\n@@ -1,3 +1,4 @@\n package domain\n+\n"
+	if _, err := ParseDiff(strings.NewReader(diff), ""); err == nil {
+		t.Error("expected an error for a truncated hunk")
+	}
+}
+
+func TestBuildFacts_DiffStatus(t *testing.T) {
+	facts := buildFacts(PullRequest{Files: parseDiffFixture(t, "clean_change.diff")}, defaultLayers)
+
+	for _, want := range []string{
+		`file_preexisting("controllers/legacy.go")`,
+		`file_imports("controllers/legacy.go", "usecases/")`,
+		`file_path("usecases/refund_usecase.go", "usecases/")`,
+	} {
+		if !slices.Contains(facts, want) {
+			t.Errorf("missing fact %s in %v", want, facts)
+		}
+	}
+	for _, unwanted := range []string{
+		`file_path("gateways/payment_gateway.go", "gateways/")`,
+		`file_preexisting("usecases/refund_usecase.go")`,
+		`file_imports("controllers/order_controller.go", "domain/")`,
+	} {
+		if slices.Contains(facts, unwanted) {
+			t.Errorf("unexpected fact %s", unwanted)
+		}
+	}
+}
+
+func TestGitDiff(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(path, content string) {
+		t.Helper()
+		full := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q")
+	write("go.mod", "module example.com/shop\n")
+	write("domain/order.go", "package domain\n")
+	git("add", "-A")
+	git("commit", "-q", "-m", "base")
+	write("controllers/order_controller.go", "package controllers\n\nimport \"example.com/shop/domain\"\n\nvar _ domain.Order\n")
+	git("add", "-A")
+	git("commit", "-q", "-m", "head")
+
+	files, err := GitDiff(dir, "HEAD~1", "HEAD", "example.com/shop")
+	if err != nil {
+		t.Fatalf("GitDiff failed: %v", err)
+	}
+	want := []PRFile{
+		{Path: "controllers/order_controller.go", Imports: []string{"domain"}, ImportLines: map[string]int{"domain": 3}, AddedLines: 5, Status: StatusAdded},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("GitDiff() = %+v, want %+v", files, want)
+	}
+
+	tree, err := AnalyzeRevision(dir, "HEAD~1", "example.com/shop")
+	if err != nil {
+		t.Fatalf("AnalyzeRevision failed: %v", err)
+	}
+	if wantTree := []PRFile{{Path: "domain/order.go", Imports: []string{}}}; !reflect.DeepEqual(tree, wantTree) {
+		t.Errorf("AnalyzeRevision(HEAD~1) = %+v, want %+v", tree, wantTree)
+	}
+}
+
+// cycleClosingPR adds billing → order to a tree where order already
+// imports billing.
+func cycleClosingPR() PullRequest {
+	return PullRequest{
+		PRID:  "diff",
+		Files: []PRFile{{Path: "usecases/billing/billing_usecase.go", Imports: []string{"usecases/order"}, Status: StatusModified}},
+		Tree: []PRFile{
+			{Path: "usecases/billing/billing_usecase.go", Imports: []string{"usecases/order"}},
+			{Path: "usecases/order/order_usecase.go", Imports: []string{"usecases/billing"}},
+		},
+	}
+}
+
+func TestBuildFacts_PackageGraphFromTree(t *testing.T) {
+	facts := buildFacts(cycleClosingPR(), defaultLayers)
+	for _, want := range []string{
+		`package_imports("usecases/billing", "usecases/order")`,
+		`package_imports("usecases/order", "usecases/billing")`,
+		`file_import("usecases/billing/billing_usecase.go", "usecases/order", "usecases/")`,
+	} {
+		if !slices.Contains(facts, want) {
+			t.Errorf("missing fact %s in %v", want, facts)
+		}
+	}
+	// only the diff's imports are attributed to files
+	if slices.Contains(facts, `file_import("usecases/order/order_usecase.go", "usecases/billing", "usecases/")`) {
+		t.Error("the tree's imports must not become file_import facts")
+	}
+}
+
+func TestPolicyEngine_DiffClosesPackageCycle(t *testing.T) {
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations, err := NewLinter(g).Review(context.Background(), cycleClosingPR())
+	if err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+	want := []Violation{{
+		Rule:    "no-package-cycles",
+		Message: "Clean Architecture violation: circular dependency between packages",
+		File:    "usecases/billing/billing_usecase.go",
+		Import:  "usecases/order",
+		Cycle:   []string{"usecases/billing", "usecases/order", "usecases/billing"},
+	}}
+	if !reflect.DeepEqual(violations, want) {
+		t.Errorf("Review() = %+v, want %+v", violations, want)
+	}
+}
+
+func TestPolicyEngine_DiffReportsOnlyNewViolations(t *testing.T) {
+	tests := []struct {
+		diff    string
+		blocked bool
+	}{
+		// touches a controller that already imports domain and a legacy
+		// file that already breaks the naming rule
+		{"clean_change.diff", false},
+		// adds a gateways import to a controller and a misnamed usecase
+		{"violating_change.diff", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.diff, func(t *testing.T) {
+			ctx := context.Background()
+
+			client, err := sdk.NewClient(ctx)
+			if err != nil {
+				t.Fatalf("Failed to initialize client: %v", err)
+			}
+			defer client.Shutdown(ctx)
+
+			if err := client.Engine().LoadPolicy(ctx, compiledPolicy(t)); err != nil {
+				t.Fatalf("Failed to load policy: %v", err)
+			}
+
+			diffPR := PullRequest{PRID: "diff", Files: parseDiffFixture(t, tt.diff)}
+			if err := client.LoadFacts(buildFacts(diffPR, defaultLayers)); err != nil {
+				t.Fatalf("Failed to load facts: %v", err)
+			}
+
+			err = client.Engine().Assess(ctx, core.ActionMetadata{Name: "review_pr"}, core.NewEnvelope(diffPR))
+			if got := core.IsAlignmentError(err); got != tt.blocked {
+				t.Errorf("blocked = %v, want %v (err: %v)", got, tt.blocked, err)
+			}
+		})
+	}
+}
+
+func TestCompileGuidelines_MatchesGolden(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join(exampleDir(), "testdata", "guidelines.dl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := compiledPolicy(t); got != string(want) {
+		t.Errorf("compiled policy differs from testdata/guidelines.dl:\n%s", got)
+	}
+}
+
+func TestCompileGuidelines_FailsOnUnreadableSentences(t *testing.T) {
+	const layers = "## Layer Structure\n1. **controllers** — handlers\n2. **domain** — entities\n\n"
+	tests := []struct {
+		name     string
+		markdown string
+	}{
+		{"prose rule", layers + "## Dependency Rules\n- controllers should mostly avoid domain\n"},
+		{"unknown layer", layers + "## Dependency Rules\n- controllers must NOT import gateways\n"},
+		{"naming without layer", layers + "## File Naming Conventions\n### Controllers\n- Must end with `_controller.go`\n"},
+		{"unsupported naming rule", layers + "## File Naming Conventions\n### Domain\n- Must be located in `domain/` directory\n- Must be reviewed by the domain team\n"},
+		{"location outside the layer", layers + "## File Naming Conventions\n### Domain\n- Must be located in `domain/` directory\n- Repository interfaces must be in `controllers/repositories/`\n"},
+		{"no layers", "## Dependency Rules\n- controllers must NOT import domain\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CompileGuidelines(tt.markdown); err == nil {
+				t.Error("expected a compile error")
+			}
+		})
+	}
+}
+
+func TestCompileGuidelines_DomainNamingRules(t *testing.T) {
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []Rule{
+		{Kind: RuleSingularNames, Layer: "domain/"},
+		{Kind: RuleLocation, Layer: "domain/", Suffix: "_repository.go", Dir: "domain/repositories/"},
+	} {
+		if !slices.Contains(g.Rules, want) {
+			t.Errorf("missing rule %+v in %+v", want, g.Rules)
+		}
+	}
+	if id := (Rule{Kind: RuleLocation, Layer: "domain/", Suffix: "_repository.go"}).ID(); id != "domain-repository-location" {
+		t.Errorf("location rule ID = %q", id)
+	}
+}
+
+func TestCompileGuidelines_OnlyForbidsTheRest(t *testing.T) {
+	g, err := CompileGuidelines(`## Layer Structure
+1. **controllers** — handlers
+2. **usecases** — logic
+3. **domain** — entities
+
+## Dependency Rules
+- controllers → usecases (only)
+- usecases → domain
+- controllers must NOT import domain directly
+
+` + "```go\n- domain → (nothing)\n```\n")
+	if err != nil {
+		t.Fatalf("CompileGuidelines failed: %v", err)
+	}
+	want := []Rule{{Kind: RuleForbiddenImport, Layer: "controllers/", Import: "domain/"}}
+	if !reflect.DeepEqual(g.Rules, want) {
+		t.Errorf("Rules = %+v, want %+v", g.Rules, want)
+	}
+	if !reflect.DeepEqual(g.Allowed["usecases/"], []string{"domain/"}) {
+		t.Errorf("Allowed = %v", g.Allowed)
+	}
+}
+
+// scriptedLLM answers every prompt with the same reply.
+type scriptedLLM struct {
+	MockExtractorLLM
+	reply string
+}
+
+func (s *scriptedLLM) Complete(ctx context.Context, prompt string) (string, error) {
+	return s.reply, nil
+}
+
+func TestLLMExtractor_AgreesWithCompiler(t *testing.T) {
+	ctx := context.Background()
+	compiled, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	extracted, err := LLMExtractor{LLM: &MockExtractorLLM{}}.Extract(ctx, "")
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if extracted.Policy() != compiled.Policy() {
+		t.Errorf("extracted policy differs from compiled policy:\n%s", extracted.Policy())
+	}
+}
+
+func TestLLMExtractor_RejectsMalformedReplies(t *testing.T) {
+	for _, reply := range []string{
+		"Controllers may not import domain.",
+		`{"layers": ["controllers/"], "rules": []}`,
+		`{"layers": ["controllers/"], "rules": [{"kind": "forbidden_import", "layer": "controllers/", "import": "domain/"}]}`,
+		`{"layers": ["controllers/"], "rules": [{"kind": "naming", "layer": "controllers/", "suffix": "controller"}]}`,
+		`{"layers": ["controllers/"], "rules": [{"kind": "must_be_pure", "layer": "controllers/"}]}`,
+	} {
+		_, err := LLMExtractor{LLM: &scriptedLLM{reply: reply}}.Extract(context.Background(), "")
+		if err == nil {
+			t.Errorf("expected %q to be rejected", reply)
+		}
+	}
+}
+
+func TestValidatePolicy_CatchesMissingRules(t *testing.T) {
+	ctx := context.Background()
+	examples, err := LoadPolicyExamples()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidatePolicy(ctx, compiled, examples); err != nil {
+		t.Errorf("compiled policy failed validation: %v", err)
+	}
+
+	// a model that missed every dependency rule approves violatingPR
+	naming := &Guidelines{
+		Layers: []string{"controllers/", "usecases/"},
+		Rules:  []Rule{{Kind: RuleNaming, Layer: "controllers/", Suffix: "_controller.go"}},
+	}
+	if err := ValidatePolicy(ctx, naming, examples); err == nil {
+		t.Error("expected validation to fail for a policy without dependency rules")
+	}
+}
+
+func TestRuleIDs(t *testing.T) {
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, r := range g.Rules {
+		if seen[r.ID()] {
+			t.Errorf("duplicate rule ID %q", r.ID())
+		}
+		seen[r.ID()] = true
+	}
+	for _, id := range []string{"controllers-must-not-import-domain", "usecases-naming", "no-layer-cycles", "no-package-cycles"} {
+		if !seen[id] {
+			t.Errorf("missing rule %q", id)
+		}
+	}
+}
+
+func TestPrintViolations_GroupsByFile(t *testing.T) {
+	violations := []Violation{
+		{Rule: "usecases-naming", Message: "Naming convention violation: usecases files must end with _usecase.go", File: "usecases/refund.go"},
+		{Rule: "controllers-must-not-import-domain", Message: "Clean Architecture violation: controllers must not import domain", File: "controllers/order_controller.go", Import: "domain", Line: 6},
+		{Rule: "controllers-must-not-import-gateways", Message: "Clean Architecture violation: controllers must not import gateways", File: "controllers/order_controller.go", Import: "gateways/payment"},
+	}
+	sortViolations(violations)
+
+	var out bytes.Buffer
+	PrintViolations(&out, violations)
+	want := `❌ PR REJECTED - 3 architecture violation(s) in 2 file(s):
+   📄 controllers/order_controller.go
+      imports "gateways/payment": Clean Architecture violation: controllers must not import gateways [controllers-must-not-import-gateways]
+     line 6: imports "domain": Clean Architecture violation: controllers must not import domain [controllers-must-not-import-domain]
+   📄 usecases/refund.go
+      Naming convention violation: usecases files must end with _usecase.go [usecases-naming]
+`
+	if out.String() != want {
+		t.Errorf("PrintViolations() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestCollectViolations_ReportsEveryViolation(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := sdk.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("Failed to initialize client: %v", err)
+	}
+	defer client.Shutdown(ctx)
+
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Engine().LoadPolicy(ctx, g.Policy()); err != nil {
+		t.Fatalf("Failed to load policy: %v", err)
+	}
+
+	files, err := AnalyzeModule(filepath.Join(exampleDir(), "testdata", "shop"))
+	if err != nil {
+		t.Fatalf("AnalyzeModule failed: %v", err)
+	}
+	modulePR := PullRequest{PRID: "local", Files: files}
+	if err := client.LoadFacts(buildFacts(modulePR, defaultLayers)); err != nil {
+		t.Fatalf("Failed to load facts: %v", err)
+	}
+
+	violations, err := CollectViolations(ctx, client, g, modulePR)
+	if err != nil {
+		t.Fatalf("CollectViolations failed: %v", err)
+	}
+	want := []Violation{
+		{Rule: "controllers-naming", Message: "Naming convention violation: controllers files must end with _controller.go", File: "controllers/legacy.go"},
+		{Rule: "controllers-must-not-import-domain", Message: "Clean Architecture violation: controllers must not import domain", File: "controllers/order_controller.go", Import: "domain", Line: 6},
+	}
+	if !reflect.DeepEqual(violations, want) {
+		t.Errorf("CollectViolations() = %+v, want %+v", violations, want)
+	}
+}
+
+// reportViolations is the violation set behind the report golden files.
+func reportViolations() []Violation {
+	return []Violation{
+		{Rule: "controllers-must-not-import-domain", Message: "Clean Architecture violation: controllers must not import domain", File: "controllers/order_controller.go", Import: "domain", Line: 6},
+		{Rule: "controllers-naming", Message: "Naming convention violation: controllers files must end with _controller.go", File: "controllers/legacy.go"},
+		{Rule: "no-package-cycles", Message: "Clean Architecture violation: circular dependency between packages", File: "usecases/order/order_usecase.go", Import: "usecases/billing", Line: 5, Cycle: []string{"usecases/order", "usecases/billing", "usecases/order"}},
+		{Rule: "usecases-naming", Message: "Naming convention violation: usecases files must end with _usecase.go", File: "usecases/a,b:100%.go"},
+		{Rule: "halt", Message: "policy violation:\nno rule explains this"},
+	}
+}
+
+func TestWriteReport_MatchesGolden(t *testing.T) {
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ format, golden string }{
+		{FormatSARIF, "report.sarif"},
+		{FormatGitHub, "report.github"},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := WriteReport(&out, tt.format, g, reportViolations()); err != nil {
+				t.Fatalf("WriteReport failed: %v", err)
+			}
+			want, err := os.ReadFile(filepath.Join(exampleDir(), "testdata", tt.golden))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != string(want) {
+				t.Errorf("report differs from testdata/%s:\n%s", tt.golden, out.String())
+			}
+		})
+	}
+}
+
+func TestWriteSARIF_IsValidLog(t *testing.T) {
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := WriteSARIF(&out, g, reportViolations()); err != nil {
+		t.Fatalf("WriteSARIF failed: %v", err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("SARIF is not valid JSON: %v", err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("unexpected SARIF header: version %q, %d runs", log.Version, len(log.Runs))
+	}
+	run := log.Runs[0]
+	for _, res := range run.Results {
+		if res.RuleIndex >= len(run.Tool.Driver.Rules) || run.Tool.Driver.Rules[res.RuleIndex].ID != res.RuleID {
+			t.Errorf("result %s has ruleIndex %d pointing at the wrong descriptor", res.RuleID, res.RuleIndex)
+		}
+	}
+	if len(run.Tool.Driver.Rules) != len(g.Rules)+1 {
+		t.Errorf("expected one descriptor per compiled rule plus the unexplained halt, got %d", len(run.Tool.Driver.Rules))
+	}
+}
+
+func TestWriteReport_EmptyAndUnknown(t *testing.T) {
+	g := &Guidelines{Layers: []string{"domain/"}, Rules: []Rule{{Kind: RuleNoCycles}}}
+
+	var out bytes.Buffer
+	if err := WriteReport(&out, FormatGitHub, g, nil); err != nil || out.Len() != 0 {
+		t.Errorf("expected no annotations for a clean review, got %q (err %v)", out.String(), err)
+	}
+	out.Reset()
+	if err := WriteReport(&out, FormatSARIF, g, nil); err != nil || !strings.Contains(out.String(), `"results": []`) {
+		t.Errorf("expected an empty results array, got %s (err %v)", out.String(), err)
+	}
+	if err := WriteReport(&out, "xml", g, nil); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestCyclePath(t *testing.T) {
+	files := []PRFile{
+		{Path: "usecases/order/order_usecase.go", Imports: []string{"usecases/billing", "domain"}},
+		{Path: "usecases/billing/billing_usecase.go", Imports: []string{"usecases/ledger"}},
+		{Path: "usecases/ledger/ledger_usecase.go", Imports: []string{"usecases/order", "domain"}},
+		{Path: "usecases/billing/refund_usecase.go", Imports: []string{"usecases/order"}},
+		{Path: "domain/order.go", Imports: []string{}},
+	}
+	graph := packageGraph(files)
+
+	// the shortest way back wins
+	want := []string{"usecases/order", "usecases/billing", "usecases/order"}
+	if got := graph.cyclePath("usecases/order", "usecases/billing"); !reflect.DeepEqual(got, want) {
+		t.Errorf("cyclePath(order, billing) = %v, want %v", got, want)
+	}
+	want = []string{"usecases/ledger", "usecases/order", "usecases/billing", "usecases/ledger"}
+	if got := graph.cyclePath("usecases/ledger", "usecases/order"); !reflect.DeepEqual(got, want) {
+		t.Errorf("cyclePath(ledger, order) = %v, want %v", got, want)
+	}
+	if got := graph.cyclePath("usecases/order", "domain"); got != nil {
+		t.Errorf("cyclePath(order, domain) = %v, want nil", got)
+	}
+
+	// within one layer the layer graph has no cycle at all
+	if got := layerGraph(files, defaultLayers).cyclePath("usecases/", "domain/"); got != nil {
+		t.Errorf("layer cyclePath = %v, want nil", got)
+	}
+}
+
+func TestBuildFacts_FileNames(t *testing.T) {
+	facts := buildFacts(PullRequest{Files: []PRFile{
+		{Path: "domain/repositories/user_repository.go"},
+		{Path: "domain/orders.go"},
+		{Path: "domain/address.go"},
+	}}, defaultLayers)
+	for _, want := range []string{
+		`file_suffix("domain/repositories/user_repository.go", "_repository.go")`,
+		`file_dir("domain/repositories/user_repository.go", "domain/repositories/")`,
+		`file_dir("domain/repositories/user_repository.go", "domain/")`,
+		`file_plural_name("domain/orders.go")`,
+	} {
+		if !slices.Contains(facts, want) {
+			t.Errorf("missing fact %s in %v", want, facts)
+		}
+	}
+	if slices.Contains(facts, `file_plural_name("domain/address.go")`) {
+		t.Error("address.go is not plural")
+	}
+}
+
+func TestPluralName(t *testing.T) {
+	for file, want := range map[string]bool{
+		"domain/user.go":         false,
+		"domain/users.go":        true,
+		"domain/order_items.go":  true,
+		"domain/status.go":       false,
+		"domain/address.go":      false,
+		"domain/analysis.go":     false,
+		"domain/users_test.go":   false,
+		"domain/user_events.txt": false,
+	} {
+		if got := pluralName(file); got != want {
+			t.Errorf("pluralName(%q) = %v, want %v", file, got, want)
+		}
+	}
+}
+
+func TestBuildFacts_PackageImports(t *testing.T) {
+	facts := buildFacts(PullRequest{Files: []PRFile{
+		{Path: "usecases/order/order_usecase.go", Imports: []string{"usecases/billing", "usecases/order"}},
+	}}, defaultLayers)
+	for _, want := range []string{
+		`file_package("usecases/order/order_usecase.go", "usecases/order")`,
+		`package_imports("usecases/order", "usecases/billing")`,
+		`file_import("usecases/order/order_usecase.go", "usecases/billing", "usecases/")`,
+	} {
+		if !slices.Contains(facts, want) {
+			t.Errorf("missing fact %s in %v", want, facts)
+		}
+	}
+	if slices.Contains(facts, `package_imports("usecases/order", "usecases/order")`) {
+		t.Error("a package must not depend on itself")
+	}
+}
+
+func TestCollectViolations_PrintsPackageCycle(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := sdk.NewClient(ctx)
+	if err != nil {
+		t.Fatalf("Failed to initialize client: %v", err)
+	}
+	defer client.Shutdown(ctx)
+
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Engine().LoadPolicy(ctx, g.Policy()); err != nil {
+		t.Fatalf("Failed to load policy: %v", err)
+	}
+
+	// both packages are usecases, so only the package rule can see it
+	cyclicPR := PullRequest{
+		PRID: "PR-4242",
+		Files: []PRFile{
+			{Path: "usecases/order/order_usecase.go", Imports: []string{"usecases/billing"}, ImportLines: map[string]int{"usecases/billing": 4}},
+			{Path: "usecases/billing/billing_usecase.go", Imports: []string{"usecases/order"}, ImportLines: map[string]int{"usecases/order": 4}},
+		},
+	}
+	if err := client.LoadFacts(buildFacts(cyclicPR, defaultLayers)); err != nil {
+		t.Fatalf("Failed to load facts: %v", err)
+	}
+	err = client.Engine().Assess(ctx, core.ActionMetadata{Name: "review_pr"}, core.NewEnvelope(cyclicPR))
+	if !core.IsAlignmentError(err) {
+		t.Fatalf("Expected cyclic PR to be blocked, but got: %v", err)
+	}
+
+	violations, err := CollectViolations(ctx, client, g, cyclicPR)
+	if err != nil {
+		t.Fatalf("CollectViolations failed: %v", err)
+	}
+	want := []Violation{
+		{Rule: "no-package-cycles", Message: "Clean Architecture violation: circular dependency between packages", File: "usecases/billing/billing_usecase.go", Import: "usecases/order", Line: 4, Cycle: []string{"usecases/billing", "usecases/order", "usecases/billing"}},
+		{Rule: "no-package-cycles", Message: "Clean Architecture violation: circular dependency between packages", File: "usecases/order/order_usecase.go", Import: "usecases/billing", Line: 4, Cycle: []string{"usecases/order", "usecases/billing", "usecases/order"}},
+	}
+	if !reflect.DeepEqual(violations, want) {
+		t.Errorf("CollectViolations() = %+v, want %+v", violations, want)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"controllers/**", "controllers/auth_controller.go", true},
+		{"controllers/**", "controllers", true},
+		{"controllers/**", "usecases/controllers/x.go", false},
+		{"internal/**/adapters/**", "internal/adapters/db_adapter.go", true},
+		{"internal/**/adapters/**", "internal/billing/adapters/db_adapter.go", true},
+		{"pkg/*/adapters/*.go", "pkg/a/b/adapters/x.go", false},
+		{"pkg/*/adapters/*.go", "pkg/a/adapters/x.go", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+// sortedRules returns the rules of g ordered by ID.
+func sortedRules(g *Guidelines) []Rule {
+	rules := slices.Clone(g.Rules)
+	slices.SortFunc(rules, func(a, b Rule) int { return strings.Compare(a.ID(), b.ID()) })
+	return rules
+}
+
+func TestLoadLayerConfig_MatchesGuidelines(t *testing.T) {
+	compiled, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configured, err := LoadLayerConfig(filepath.Join(exampleDir(), "layers.json"))
+	if err != nil {
+		t.Fatalf("LoadLayerConfig failed: %v", err)
+	}
+	if !reflect.DeepEqual(sortedRules(configured), sortedRules(compiled)) {
+		t.Errorf("layers.json rules = %+v, want %+v", sortedRules(configured), sortedRules(compiled))
+	}
+	if !reflect.DeepEqual(configured.Allowed, compiled.Allowed) {
+		t.Errorf("layers.json Allowed = %v, want %v", configured.Allowed, compiled.Allowed)
+	}
+	if !reflect.DeepEqual(layerMapOf(configured), layerMapOf(compiled)) {
+		t.Errorf("layers.json map = %+v, want %+v", layerMapOf(configured), layerMapOf(compiled))
+	}
+}
+
+func TestLoadLayerConfig_Hexagonal(t *testing.T) {
+	g, err := LoadLayerConfig(filepath.Join(exampleDir(), "testdata", "hexagonal.json"))
+	if err != nil {
+		t.Fatalf("LoadLayerConfig failed: %v", err)
+	}
+	m := layerMapOf(g)
+	for path, want := range map[string]string{
+		"internal/core/order/order.go":           "core/",
+		"internal/ports/order_port.go":           "ports/",
+		"pkg/billing/adapters/stripe_adapter.go": "adapters/",
+		"cmd/server/main.go":                     "cmd/",
+		"internal/platform/log.go":               "unknown",
+	} {
+		if got := m.Layer(path); got != want {
+			t.Errorf("Layer(%q) = %q, want %q", path, got, want)
+		}
+	}
+
+	facts := buildFacts(PullRequest{Files: []PRFile{
+		{Path: "internal/core/order/order.go", Imports: []string{"pkg/billing/adapters"}},
+		{Path: "internal/ports/orders.go"},
+	}}, m)
+	for _, want := range []string{
+		`file_imports("internal/core/order/order.go", "adapters/")`,
+		`file_name_matches("internal/core/order/order.go", "")`,
+	} {
+		if !slices.Contains(facts, want) {
+			t.Errorf("missing fact %s in %v", want, facts)
+		}
+	}
+	if slices.ContainsFunc(facts, func(f string) bool { return strings.HasPrefix(f, `file_name_matches("internal/ports/orders.go"`) }) {
+		t.Errorf("orders.go lacks the _port.go suffix: %v", facts)
+	}
+
+	var ids []string
+	for _, r := range g.Rules {
+		ids = append(ids, r.ID())
+	}
+	for _, want := range []string{"core-must-not-import-adapters", "ports-must-not-import-cmd", "adapters-naming"} {
+		if !slices.Contains(ids, want) {
+			t.Errorf("missing rule %s in %v", want, ids)
+		}
+	}
+	if slices.Contains(ids, "adapters-must-not-import-core") {
+		t.Error("adapters may import core")
+	}
+}
+
+func TestLoadLayerConfig_RejectsBadConfigs(t *testing.T) {
+	const layers = `"layers": [{"name": "core", "paths": ["core/**"]}, {"name": "adapters", "paths": ["adapters/**"]}]`
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"no layers", `{"allowed": {}}`},
+		{"missing allowed entry", `{` + layers + `, "allowed": {"core": []}}`},
+		{"unknown layer", `{` + layers + `, "allowed": {"core": [], "adapters": ["db"]}}`},
+		{"unknown field", `{` + layers + `, "allowed": {"core": [], "adapters": []}, "strict": true}`},
+		{"bad suffix", `{"layers": [{"name": "core", "paths": ["core/**"], "suffix": "core"}], "allowed": {"core": []}}`},
+		{"no paths", `{"layers": [{"name": "core"}], "allowed": {"core": []}}`},
+		{"bad pattern", `{"layers": [{"name": "core", "paths": ["core/[**"]}], "allowed": {"core": []}}`},
+		{"duplicate layer", `{"layers": [{"name": "core", "paths": ["a/**"]}, {"name": "core", "paths": ["b/**"]}], "allowed": {"core": []}}`},
+		{"unknown singular layer", `{` + layers + `, "allowed": {"core": [], "adapters": []}, "singular_names": ["db"]}`},
+		{"bad location", `{` + layers + `, "allowed": {"core": [], "adapters": []}, "locations": [{"layer": "core", "suffix": "_repository.go", "dir": "core/repositories"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "layers.json")
+			if err := os.WriteFile(file, []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadLayerConfig(file); err == nil {
+				t.Error("expected LoadLayerConfig to fail")
+			}
+		})
+	}
+}
+
+func TestPolicyEngine_LayerConfig(t *testing.T) {
+	ctx := context.Background()
+
+	g, err := LoadLayerConfig(filepath.Join(exampleDir(), "testdata", "hexagonal.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corePR := PullRequest{
+		PRID: "PR-5150",
+		Files: []PRFile{
+			{Path: "internal/core/order/order.go", Imports: []string{"pkg/billing/adapters"}, ImportLines: map[string]int{"pkg/billing/adapters": 5}},
+			{Path: "pkg/billing/adapters/stripe_adapter.go", Imports: []string{"internal/ports"}},
+		},
+	}
+	violations, err := NewLinter(g).Review(ctx, corePR)
+	if err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+	want := []Violation{
+		{Rule: "core-must-not-import-adapters", Message: "Clean Architecture violation: core must not import adapters", File: "internal/core/order/order.go", Import: "pkg/billing/adapters", Line: 5},
+	}
+	if !reflect.DeepEqual(violations, want) {
+		t.Errorf("Review() = %+v, want %+v", violations, want)
+	}
+}
+
+// cleanPR only has dependencies the guidelines allow.
+func cleanPR() PullRequest {
+	return PullRequest{
+		PRID:  "PR-1001",
+		Title: "Add auth feature (clean)",
+		Files: []PRFile{
+			{Path: "controllers/auth_controller.go", Imports: []string{"usecases/auth_usecase"}},
+			{Path: "usecases/auth_usecase.go", Imports: []string{"domain/user"}},
+			{Path: "domain/user.go", Imports: []string{}},
+		},
+	}
+}
+
+func TestLoadDiff_NeedsModulePath(t *testing.T) {
+	diff := filepath.Join(exampleDir(), "testdata", "violating_change.diff")
+	if _, _, err := loadDiff("", "", diff, "", "HEAD"); err == nil {
+		t.Error("expected -diff without -module or -module-path to fail")
+	}
+	files, tree, err := loadDiff("", "example.com/shop", diff, "", "HEAD")
+	if err != nil || tree != nil {
+		t.Fatalf("loadDiff failed: %v", err)
+	}
+	if len(files) == 0 || files[0].Path != "controllers/order_controller.go" || !slices.Contains(files[0].Imports, "gateways") {
+		t.Errorf("loadDiff() = %+v, want controllers/order_controller.go importing gateways", files)
+	}
+}
+
+func TestPolicyEngine_DiffWithoutModule(t *testing.T) {
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := loadDiff("", "example.com/shop", filepath.Join(exampleDir(), "testdata", "violating_change.diff"), "", "HEAD")
+	if err != nil {
+		t.Fatalf("loadDiff failed: %v", err)
+	}
+	violations, err := NewLinter(g).Review(context.Background(), PullRequest{PRID: "diff", Files: files})
+	if err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+	want := Violation{Rule: "controllers-must-not-import-gateways", Message: "Clean Architecture violation: controllers must not import gateways", File: "controllers/order_controller.go", Import: "gateways", Line: 7}
+	if !slices.ContainsFunc(violations, func(v Violation) bool { return reflect.DeepEqual(v, want) }) {
+		t.Errorf("Review() = %+v, want it to contain %+v", violations, want)
+	}
+}
+
+func TestLinter_ReviewsDoNotShareFacts(t *testing.T) {
+	ctx := context.Background()
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	linter := NewLinter(g)
+
+	first, err := linter.Review(ctx, violatingPR())
+	if err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+	if len(first) == 0 {
+		t.Fatal("expected violatingPR to be rejected")
+	}
+
+	// the violating PR's imports must not leak into the next review
+	clean, err := linter.Review(ctx, cleanPR())
+	if err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+	if len(clean) != 0 {
+		t.Errorf("clean PR reviewed after violatingPR got %+v, want none", clean)
+	}
+
+	again, err := linter.Review(ctx, violatingPR())
+	if err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+	if !reflect.DeepEqual(again, first) {
+		t.Errorf("second review of violatingPR = %+v, want %+v", again, first)
+	}
+}
+
+func TestLinter_ConcurrentReviews(t *testing.T) {
+	ctx := context.Background()
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	linter := NewLinter(g)
+
+	want, err := linter.Review(ctx, violatingPR())
+	if err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+
+	const reviews = 16
+	results := make([][]Violation, reviews)
+	errs := make([]error, reviews)
+	var wg sync.WaitGroup
+	for i := range reviews {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pr := cleanPR()
+			if i%2 == 1 {
+				pr = violatingPR()
+			}
+			results[i], errs[i] = linter.Review(ctx, pr)
+		}()
+	}
+	wg.Wait()
+
+	for i := range reviews {
+		if errs[i] != nil {
+			t.Fatalf("review %d failed: %v", i, errs[i])
+		}
+		if i%2 == 0 && len(results[i]) != 0 {
+			t.Errorf("clean review %d got %+v, want none", i, results[i])
+		}
+		if i%2 == 1 && !reflect.DeepEqual(results[i], want) {
+			t.Errorf("violating review %d got %+v, want %+v", i, results[i], want)
+		}
+	}
+}
+
+func TestParseSuppression(t *testing.T) {
+	tests := []struct {
+		comment string
+		want    Suppression
+		ok      bool
+		err     bool
+	}{
+		{"//arch:ignore controllers-must-not-import-domain read-only view", Suppression{Rule: "controllers-must-not-import-domain", Reason: "read-only view", Line: 7}, true, false},
+		{"//arch:ignore\tusecases-naming  legacy file ", Suppression{Rule: "usecases-naming", Reason: "legacy file", Line: 7}, true, false},
+		{"// arch:ignore usecases-naming legacy", Suppression{}, false, false},
+		{"//arch:ignored usecases-naming legacy", Suppression{}, false, false},
+		{"//arch:ignore usecases-naming", Suppression{}, false, true},
+		{"//arch:ignore", Suppression{}, false, true},
+	}
+	for _, tt := range tests {
+		got, ok, err := parseSuppression(tt.comment, 7)
+		if (err != nil) != tt.err || ok != tt.ok || got != tt.want {
+			t.Errorf("parseSuppression(%q) = %+v, %v, %v", tt.comment, got, ok, err)
+		}
+	}
+}
+
+func TestAnalyzeModule_Suppressions(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/shop\n")
+	write("controllers/report.go", `// Package controllers serves reports.
+//arch:ignore controllers-naming kept for the public URL
+package controllers
+
+import (
+	"net/http"
+
+	//arch:ignore controllers-must-not-import-domain read-only view of orders
+	"example.com/shop/domain"
+	"example.com/shop/gateways" //arch:ignore controllers-must-not-import-gateways until the report usecase exists
+)
+`)
+
+	files, err := AnalyzeModule(dir)
+	if err != nil {
+		t.Fatalf("AnalyzeModule failed: %v", err)
+	}
+	want := []Suppression{
+		{Rule: "controllers-naming", Reason: "kept for the public URL", Line: 2},
+		{Rule: "controllers-must-not-import-domain", Import: "domain", Reason: "read-only view of orders", Line: 8},
+		{Rule: "controllers-must-not-import-gateways", Import: "gateways", Reason: "until the report usecase exists", Line: 10},
+	}
+	if len(files) != 1 || !reflect.DeepEqual(files[0].Suppressions, want) {
+		t.Errorf("AnalyzeModule() = %+v, want suppressions %+v", files, want)
+	}
+
+	write("controllers/bad.go", "package controllers\n\n//arch:ignore controllers-naming\n")
+	if _, err := AnalyzeModule(dir); err == nil {
+		t.Error("expected a suppression without a reason to be rejected")
+	}
+}
+
+func TestParseDiff_Suppressions(t *testing.T) {
+	const diff = `diff --git a/controllers/report_controller.go b/controllers/report_controller.go
+new file mode 100644
+--- /dev/null
++++ b/controllers/report_controller.go
+@@ -0,0 +1,8 @@
++package controllers
++
++import (
++	"net/http"
++
++	//arch:ignore controllers-must-not-import-domain read-only view of orders
++	"example.com/shop/domain"
++)
+`
+	files, err := ParseDiff(strings.NewReader(diff), "example.com/shop")
+	if err != nil {
+		t.Fatalf("ParseDiff failed: %v", err)
+	}
+	want := []Suppression{{Rule: "controllers-must-not-import-domain", Import: "domain", Reason: "read-only view of orders", Line: 6}}
+	if len(files) != 1 || !reflect.DeepEqual(files[0].Suppressions, want) {
+		t.Errorf("ParseDiff() = %+v, want suppressions %+v", files, want)
+	}
+}
+
+func TestParseDiff_IgnoresDeclarationBodies(t *testing.T) {
+	const diff = `diff --git a/controllers/report_controller.go b/controllers/report_controller.go
+--- a/controllers/report_controller.go
++++ b/controllers/report_controller.go
+@@ -3,3 +3,8 @@ package controllers
+ import (
+ 	"net/http"
+ )
++
++//arch:ignore controllers-naming kept for the public URL
++func Report(w http.ResponseWriter, r *http.Request) {
++	//arch:ignore TODO
++	paths := []string{
++		"example.com/shop/domain",
+@@ -20,2 +25,4 @@ func Export(w http.ResponseWriter, r *http.Request) {
+ 	w.WriteHeader(http.StatusOK)
++	//arch:ignore controllers-must-not-import-domain not an import
++	_ = "example.com/shop/gateways"
+ }
+`
+	files, err := ParseDiff(strings.NewReader(diff), "example.com/shop")
+	if err != nil {
+		t.Fatalf("ParseDiff failed: %v", err)
+	}
+	want := []Suppression{{Rule: "controllers-naming", Reason: "kept for the public URL", Line: 7}}
+	if len(files) != 1 || len(files[0].Imports) != 0 || !reflect.DeepEqual(files[0].Suppressions, want) {
+		t.Errorf("ParseDiff() = %+v, want no imports and suppressions %+v", files, want)
+	}
+}
+
+func TestBuildFacts_Suppressions(t *testing.T) {
+	facts := buildFacts(PullRequest{Files: []PRFile{{
+		Path:    "controllers/report.go",
+		Imports: []string{"domain"},
+		Suppressions: []Suppression{
+			{Rule: "controllers-naming", Reason: "public URL"},
+			{Rule: "controllers-must-not-import-domain", Import: "domain", Reason: "read-only"},
+		},
+	}}}, defaultLayers)
+	for _, want := range []string{
+		`suppressed_file("controllers/report.go", "controllers-naming")`,
+		`suppressed("controllers/report.go", "controllers-must-not-import-domain", "domain")`,
+	} {
+		if !slices.Contains(facts, want) {
+			t.Errorf("missing fact %s in %v", want, facts)
+		}
+	}
+}
+
+func TestBaseline_RoundTrip(t *testing.T) {
+	b := NewBaseline([]Violation{
+		{Rule: "usecases-naming", File: "usecases/refund.go"},
+		{Rule: "controllers-must-not-import-domain", File: "controllers/order_controller.go", Import: "domain", Line: 6},
+		{Rule: "controllers-must-not-import-domain", File: "controllers/order_controller.go", Import: "domain", Line: 9},
+		{Rule: haltRule, Message: "unexplained"},
+	})
+	want := []BaselineEntry{
+		{Rule: "controllers-must-not-import-domain", File: "controllers/order_controller.go", Import: "domain"},
+		{Rule: "usecases-naming", File: "usecases/refund.go"},
+	}
+	if !reflect.DeepEqual(b.Violations, want) {
+		t.Fatalf("NewBaseline() = %+v, want %+v", b.Violations, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := b.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatalf("LoadBaseline failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, b) {
+		t.Errorf("LoadBaseline() = %+v, want %+v", loaded, b)
+	}
+	if got := loaded.facts(); !slices.Contains(got, `baselined("usecases-naming", "usecases/refund.go", "")`) {
+		t.Errorf("facts() = %v", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"violations": [{"rule": "usecases-naming"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBaseline(path); err == nil {
+		t.Error("expected an entry without a file to be rejected")
+	}
+}
+
+func TestPrintExisting(t *testing.T) {
+	var out bytes.Buffer
+	PrintExisting(&out, []Violation{
+		{Rule: "controllers-must-not-import-domain", Message: "Clean Architecture violation: controllers must not import domain", File: "controllers/order_controller.go", Import: "domain", Line: 6, Existing: true},
+	})
+	want := `ℹ️  1 existing violation(s) in 1 file(s) recorded in the baseline:
+   📄 controllers/order_controller.go
+     line 6: imports "domain": Clean Architecture violation: controllers must not import domain [controllers-must-not-import-domain]
+`
+	if out.String() != want {
+		t.Errorf("PrintExisting() =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestLinter_BaselineAndSuppressions(t *testing.T) {
+	ctx := context.Background()
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	domainImport := Violation{Rule: "controllers-must-not-import-domain", Message: "Clean Architecture violation: controllers must not import domain", File: "controllers/order_controller.go", Import: "domain/order"}
+
+	// a baselined violation is still reported, but does not fail
+	linter := NewLinter(g).WithBaseline(NewBaseline([]Violation{domainImport}))
+	violations, err := linter.Review(ctx, violatingPR())
+	if err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+	existing := domainImport
+	existing.Existing = true
+	if !reflect.DeepEqual(violations, []Violation{existing}) {
+		t.Errorf("Review() with baseline = %+v, want %+v", violations, []Violation{existing})
+	}
+
+	// a suppressed violation is not reported at all
+	pr := violatingPR()
+	pr.Files[0].Suppressions = []Suppression{{Rule: domainImport.Rule, Import: domainImport.Import, Reason: "read-only view"}}
+	violations, err = NewLinter(g).Review(ctx, pr)
+	if err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+	if len(violations) != 0 {
+		t.Errorf("Review() with suppression = %+v, want none", violations)
+	}
+
+	// neither exempts other rules
+	pr.Files[0].Suppressions[0].Rule = "controllers-naming"
+	violations, err = linter.WithBaseline(&Baseline{}).Review(ctx, pr)
+	if err != nil {
+		t.Fatalf("Review failed: %v", err)
+	}
+	if !reflect.DeepEqual(violations, []Violation{domainImport}) {
+		t.Errorf("Review() = %+v, want %+v", violations, []Violation{domainImport})
+	}
+}
+
+// graphReview is the review behind the layer graph golden files.
+func graphReview() ([]ImportFact, []Violation) {
+	// the facts a review loads for a PR that also deletes
+	// gateways/legacy_gateway.go, whose imports are gone
+	imports := []ImportFact{
+		{File: "controllers/order_controller.go", Layer: "controllers/", Import: "domain", ImportLayer: "domain/"},
+		{File: "controllers/order_controller.go", Layer: "controllers/", Import: "usecases", ImportLayer: "usecases/"},
+		{File: "controllers/refund_controller.go", Layer: "controllers/", Import: "domain", ImportLayer: "domain/"},
+		{File: "controllers/refund_controller.go", Layer: "controllers/", Import: "usecases", ImportLayer: "usecases/"},
+		{File: "controllers/report_controller.go", Layer: "controllers/", Import: "gateways/payment", ImportLayer: "gateways/"},
+		{File: "gateways/payment/payment_gateway.go", Layer: "gateways/", Import: "domain", ImportLayer: "domain/"},
+		{File: "usecases/order_usecase.go", Layer: "usecases/", Import: "domain", ImportLayer: "domain/"},
+		{File: "usecases/order_usecase.go", Layer: "usecases/", Import: "gateways/payment", ImportLayer: "gateways/"},
+	}
+	violations := []Violation{
+		{Rule: "controllers-must-not-import-domain", File: "controllers/order_controller.go", Import: "domain"},
+		{Rule: "controllers-must-not-import-domain", File: "controllers/refund_controller.go", Import: "domain", Existing: true},
+		{Rule: "controllers-must-not-import-gateways", File: "controllers/report_controller.go", Import: "gateways/payment", Existing: true},
+		{Rule: "controllers-naming", File: "controllers/legacy.go"},
+	}
+	return imports, violations
+}
+
+func TestBuildLayerGraph(t *testing.T) {
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports, violations := graphReview()
+	want := []LayerEdge{
+		{From: "controllers/", To: "domain/", Files: 2, Kind: EdgeViolating},
+		{From: "controllers/", To: "gateways/", Files: 1, Kind: EdgeExisting},
+		{From: "controllers/", To: "usecases/", Files: 2, Kind: EdgeAllowed},
+		{From: "gateways/", To: "domain/", Files: 1, Kind: EdgeAllowed},
+		{From: "usecases/", To: "domain/", Files: 1, Kind: EdgeAllowed},
+		{From: "usecases/", To: "gateways/", Files: 1, Kind: EdgeAllowed},
+	}
+	if got := BuildLayerGraph(g, imports, violations); !reflect.DeepEqual(got.Edges, want) {
+		t.Errorf("BuildLayerGraph() edges = %+v, want %+v", got.Edges, want)
+	}
+
+	// a layer without allowed dependencies leaves its imports unchecked
+	partial := &Guidelines{Layers: []string{"controllers/", "domain/"}}
+	got := BuildLayerGraph(partial, imports[:1], nil)
+	if len(got.Edges) != 1 || got.Edges[0].Kind != EdgeUnchecked {
+		t.Errorf("BuildLayerGraph() edges = %+v, want one unchecked edge", got.Edges)
+	}
+}
+
+func TestPolicyEngine_ReviewGraphFromFacts(t *testing.T) {
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations, lg, err := NewLinter(g).ReviewGraph(context.Background(), violatingPR())
+	if err != nil {
+		t.Fatalf("ReviewGraph failed: %v", err)
+	}
+	if len(violations) == 0 {
+		t.Fatal("ReviewGraph() found no violations in the violating PR")
+	}
+	want := []LayerEdge{
+		{From: "controllers/", To: "domain/", Files: 1, Kind: EdgeViolating},
+		{From: "controllers/", To: "usecases/", Files: 1, Kind: EdgeAllowed},
+		{From: "usecases/", To: "domain/", Files: 1, Kind: EdgeAllowed},
+	}
+	if !reflect.DeepEqual(lg.Edges, want) {
+		t.Errorf("ReviewGraph() edges = %+v, want %+v", lg.Edges, want)
+	}
+}
+
+func TestWriteGraph_MatchesGolden(t *testing.T) {
+	g, err := LoadGuidelines(filepath.Join(exampleDir(), "architecture_guidelines.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports, violations := graphReview()
+	lg := BuildLayerGraph(g, imports, violations)
+	for _, tt := range []struct{ format, golden string }{
+		{GraphDOT, "graph.dot"},
+		{GraphMermaid, "graph.mmd"},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := WriteGraph(&out, tt.format, lg); err != nil {
+				t.Fatalf("WriteGraph failed: %v", err)
+			}
+			want, err := os.ReadFile(filepath.Join(exampleDir(), "testdata", tt.golden))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != string(want) {
+				t.Errorf("graph differs from testdata/%s:\n%s", tt.golden, out.String())
+			}
+		})
+	}
+	if err := WriteGraph(&bytes.Buffer{}, "svg", lg); err == nil {
+		t.Error("expected an error for an unknown graph format")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// --- Machine-readable reports ---
+//
+// With -format sarif the linter writes a SARIF 2.1.0 log for code
+// scanning: every compiled rule is a reportingDescriptor and every
+// violation a result pointing at its file and, when known, the import's
+// line. With -format github it writes workflow commands, which GitHub
+// Actions shows as annotations on the PR. Either way the report is the
+// only thing on stdout; progress output moves to stderr. Existing
+// violations from the baseline are notes rather than errors.
+
+// Report formats accepted by -format.
+const (
+	FormatText   = "text"
+	FormatSARIF  = "sarif"
+	FormatGitHub = "github"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	toolName     = "arch-linter"
+)
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string                     `json:"name"`
+	Rules []sarifReportingDescriptor `json:"rules"`
+}
+
+type sarifReportingDescriptor struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID        string          `json:"ruleId"`
+	RuleIndex     int             `json:"ruleIndex"`
+	Level         string          `json:"level"`
+	Message       sarifMessage    `json:"message"`
+	Locations     []sarifLocation `json:"locations,omitempty"`
+	BaselineState string          `json:"baselineState,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// violationText is the one-line description of a violation used by the
+// machine-readable formats.
+func violationText(v Violation) string {
+	text := v.Message
+	if v.Import != "" {
+		text += fmt.Sprintf(" (imports %q)", v.Import)
+	}
+	if len(v.Cycle) > 0 {
+		text += "; cycle: " + strings.Join(v.Cycle, " → ")
+	}
+	return text
+}
+
+// WriteSARIF writes violations as a SARIF 2.1.0 log. File paths are
+// relative to the checkout root (%SRCROOT%).
+func WriteSARIF(w io.Writer, g *Guidelines, violations []Violation) error {
+	driver := sarifDriver{Name: toolName, Rules: []sarifReportingDescriptor{}}
+	index := make(map[string]int)
+	addRule := func(id, text string) {
+		if _, ok := index[id]; ok {
+			return
+		}
+		index[id] = len(driver.Rules)
+		driver.Rules = append(driver.Rules, sarifReportingDescriptor{
+			ID:                   id,
+			ShortDescription:     sarifMessage{Text: text},
+			DefaultConfiguration: sarifConfiguration{Level: "error"},
+		})
+	}
+	for _, r := range g.Rules {
+		addRule(r.ID(), r.Message())
+	}
+
+	results := []sarifResult{}
+	for _, v := range violations {
+		// a halt no compiled rule explains still gets a descriptor
+		addRule(v.Rule, v.Message)
+		result := sarifResult{
+			RuleID:    v.Rule,
+			RuleIndex: index[v.Rule],
+			Level:     "error",
+			Message:   sarifMessage{Text: violationText(v)},
+		}
+		if v.Existing {
+			result.Level = "note"
+			result.BaselineState = "unchanged"
+		}
+		if v.File != "" {
+			loc := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: (&url.URL{Path: v.File}).EscapedPath(), URIBaseID: "%SRCROOT%"}}
+			if v.Line > 0 {
+				loc.Region = &sarifRegion{StartLine: v.Line}
+			}
+			result.Locations = []sarifLocation{{PhysicalLocation: loc}}
+		}
+		results = append(results, result)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	})
+}
+
+// WriteGitHubAnnotations writes one ::error workflow command per violation,
+// or ::notice for an existing one.
+func WriteGitHubAnnotations(w io.Writer, violations []Violation) error {
+	for _, v := range violations {
+		command := "error"
+		if v.Existing {
+			command = "notice"
+		}
+		var props []string
+		if v.File != "" {
+			props = append(props, "file="+escapeProperty(v.File))
+		}
+		if v.Line > 0 {
+			props = append(props, fmt.Sprintf("line=%d", v.Line))
+		}
+		props = append(props, "title="+escapeProperty(v.Rule))
+		if _, err := fmt.Fprintf(w, "::%s %s::%s\n", command, strings.Join(props, ","), escapeData(violationText(v))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeData escapes a workflow command message.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a workflow command property value.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// WriteReport writes violations in a machine-readable format.
+func WriteReport(w io.Writer, format string, g *Guidelines, violations []Violation) error {
+	switch format {
+	case FormatSARIF:
+		return WriteSARIF(w, g, violations)
+	case FormatGitHub:
+		return WriteGitHubAnnotations(w, violations)
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/duynguyendang/manglekit/core"
+	"github.com/duynguyendang/manglekit/sdk"
+)
+
+// --- Isolated reviews ---
+//
+// Facts loaded into a client stay there. Reviewing a second PR on the same
+// client evaluates the rules over the files of both PRs, so a clean PR
+// reviewed after a violating one is rejected for the other PR's imports,
+// and the verdict depends on review order. A Linter therefore never reuses
+// a client: every Review starts a fresh one with only the rules and that
+// PR's facts and shuts it down afterwards, so a long-running linter can
+// review PRs one after another or concurrently without cross-talk.
+
+// Linter reviews PRs against compiled guidelines. It holds no engine
+// state and is safe for concurrent use.
+type Linter struct {
+	guidelines *Guidelines
+	policy     string
+	layers     *LayerMap
+	baseline   []string
+}
+
+// NewLinter returns a Linter for the rules of g. g must not be changed
+// afterwards.
+func NewLinter(g *Guidelines) *Linter {
+	return &Linter{guidelines: g, policy: g.Policy(), layers: layerMapOf(g)}
+}
+
+// WithBaseline returns a Linter that reports the violations in b as
+// existing instead of failing on them.
+func (l *Linter) WithBaseline(b *Baseline) *Linter {
+	with := *l
+	with.baseline = b.facts()
+	return &with
+}
+
+// Review assesses pr on a client of its own and returns its violations,
+// including the existing ones. A halt that no compiled rule explains is
+// returned as a violation of rule "halt".
+func (l *Linter) Review(ctx context.Context, pr PullRequest) ([]Violation, error) {
+	violations, _, err := l.review(ctx, pr, false)
+	return violations, err
+}
+
+// ReviewGraph is Review that also returns the layer graph of the import
+// facts the review loaded.
+func (l *Linter) ReviewGraph(ctx context.Context, pr PullRequest) ([]Violation, *LayerGraph, error) {
+	violations, imports, err := l.review(ctx, pr, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return violations, BuildLayerGraph(l.guidelines, imports, violations), nil
+}
+
+// review assesses pr on a client of its own. With graph set it also
+// queries the client's import facts before shutting it down.
+func (l *Linter) review(ctx context.Context, pr PullRequest, graph bool) ([]Violation, []ImportFact, error) {
+	client, err := sdk.NewClient(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize client: %w", err)
+	}
+	defer client.Shutdown(ctx)
+
+	if err := client.Engine().LoadPolicy(ctx, l.policy); err != nil {
+		return nil, nil, fmt.Errorf("failed to load architecture policy: %w", err)
+	}
+	if err := client.LoadFacts(append(buildFacts(pr, l.layers), l.baseline...)); err != nil {
+		return nil, nil, fmt.Errorf("failed to load facts for %s: %w", pr.PRID, err)
+	}
+
+	err = client.Engine().Assess(ctx, core.ActionMetadata{Name: "review_pr"}, core.NewEnvelope(pr))
+	blocked := core.IsAlignmentError(err)
+	if err != nil && !blocked {
+		return nil, nil, fmt.Errorf("failed to assess %s: %w", pr.PRID, err)
+	}
+
+	// existing violations are reported even when the PR passes
+	violations, qerr := CollectViolations(ctx, client, l.guidelines, pr)
+	if qerr != nil {
+		return nil, nil, qerr
+	}
+	if introduced, _ := splitExisting(violations); blocked && len(introduced) == 0 {
+		violations = append(violations, Violation{Rule: haltRule, Message: err.Error()})
+	}
+	if !graph {
+		return violations, nil, nil
+	}
+	imports, err := QueryImportFacts(ctx, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	return violations, imports, nil
+}
+
+// haltRule is the rule of a violation the engine reported but no compiled
+// rule explains.
+const haltRule = "halt"
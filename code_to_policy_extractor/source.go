@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- Analyzing a real Go checkout ---
+//
+// Instead of a hand-written PR description, AnalyzeModule walks a Go
+// module, parses the import block of every .go file with go/parser and
+// turns it into PRFile entries. Imports are recorded relative to the
+// module root ("example.com/shop/domain/order" becomes "domain/order"),
+// so LayerMap.Layer maps them the same way it maps file paths. Standard
+// library and third-party imports belong to no layer and are left out.
+// Comments are parsed too, for the //arch:ignore suppressions in
+// exemptions.go.
+
+// readModulePath returns the module path declared in dir/go.mod.
+func readModulePath(dir string) (string, error) {
+	f, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("failed to open go.mod: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if rest, ok := strings.CutPrefix(line, "module"); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			path := strings.TrimSpace(rest)
+			if unquoted, err := strconv.Unquote(path); err == nil {
+				path = unquoted
+			}
+			if path != "" {
+				return path, nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	return "", fmt.Errorf("no module directive in %s", filepath.Join(dir, "go.mod"))
+}
+
+// skipDir reports whether the walk should not descend into a directory:
+// hidden and underscore directories, vendor and testdata, as the go tool
+// does, and nested modules, which have their own layering.
+func skipDir(root, path string, d fs.DirEntry) bool {
+	if path == root {
+		return false
+	}
+	name := d.Name()
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "vendor" || name == "testdata" {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(path, "go.mod"))
+	return err == nil
+}
+
+// AnalyzeModule parses every Go file of the module rooted at dir and
+// returns them as PR files, sorted by path.
+func AnalyzeModule(dir string) ([]PRFile, error) {
+	modulePath, err := readModulePath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	var files []PRFile
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if skipDir(dir, path, d) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file, err := parseGoFile(fset, path, nil, filepath.ToSlash(rel), modulePath)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze module %s: %w", dir, err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// AnalyzeRevision parses every Go file of the module at dir as of git
+// revision rev, like AnalyzeModule does for the working tree. Paths are
+// relative to dir.
+func AnalyzeRevision(dir, rev, modulePath string) ([]PRFile, error) {
+	out, err := git(dir, "ls-tree", "-r", "-z", "--name-only", rev)
+	if err != nil {
+		return nil, err
+	}
+	names := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	nested := make(map[string]bool)
+	for _, name := range names {
+		if pkg := path.Dir(name); path.Base(name) == "go.mod" && pkg != "." {
+			nested[pkg] = true
+		}
+	}
+
+	fset := token.NewFileSet()
+	var files []PRFile
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".go") || skipPath(name, nested) {
+			continue
+		}
+		src, err := git(dir, "show", rev+":./"+name)
+		if err != nil {
+			return nil, err
+		}
+		file, err := parseGoFile(fset, name, src, name, modulePath)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// skipPath reports whether a module-relative file lies in a directory
+// skipDir would not descend into; nested holds the nested modules.
+func skipPath(file string, nested map[string]bool) bool {
+	for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+		name := path.Base(dir)
+		if nested[dir] || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "vendor" || name == "testdata" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGoFile parses the import block and the //arch:ignore comments of
+// a Go file into a PRFile at rel. src is read from filename when nil.
+func parseGoFile(fset *token.FileSet, filename string, src any, rel, modulePath string) (PRFile, error) {
+	parsed, err := parser.ParseFile(fset, filename, src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return PRFile{}, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	file := PRFile{Path: rel, Imports: []string{}}
+	importAt := make(map[int]string)
+	for _, spec := range parsed.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return PRFile{}, fmt.Errorf("bad import in %s: %w", filename, err)
+		}
+		line := fset.Position(spec.Path.Pos()).Line
+		importAt[line] = importPath
+		if local, ok := localImport(modulePath, importPath); ok {
+			importAt[line] = local
+			file.Imports = append(file.Imports, local)
+			if file.ImportLines == nil {
+				file.ImportLines = make(map[string]int)
+			}
+			file.ImportLines[local] = line
+		}
+	}
+	for _, group := range parsed.Comments {
+		for _, c := range group.List {
+			s, ok, err := parseSuppression(c.Text, fset.Position(c.Slash).Line)
+			if err != nil {
+				return PRFile{}, fmt.Errorf("%s: %w", filename, err)
+			}
+			if ok {
+				file.Suppressions = append(file.Suppressions, s)
+			}
+		}
+	}
+	file.Suppressions = attachSuppressions(file.Suppressions, importAt)
+	return file, nil
+}
+
+// localImport returns importPath relative to modulePath, or false if it
+// is not a package of the module.
+func localImport(modulePath, importPath string) (string, bool) {
+	return strings.CutPrefix(importPath, modulePath+"/")
+}
@@ -0,0 +1,7 @@
+package controllers
+
+import "net/http"
+
+func Health(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
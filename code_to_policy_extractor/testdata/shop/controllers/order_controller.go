@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	"net/http"
+
+	"example.com/shop/domain"
+	"example.com/shop/usecases"
+)
+
+type OrderController struct {
+	usecase *usecases.OrderUsecase
+}
+
+func (c *OrderController) Get(w http.ResponseWriter, r *http.Request) {
+	var _ domain.Order
+}
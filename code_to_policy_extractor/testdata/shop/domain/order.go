@@ -0,0 +1,5 @@
+package domain
+
+type Order struct {
+	ID string
+}
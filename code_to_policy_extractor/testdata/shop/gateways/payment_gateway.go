@@ -0,0 +1,5 @@
+package gateways
+
+import "example.com/shop/domain"
+
+func Charge(o domain.Order) error { return nil }
@@ -0,0 +1,5 @@
+package tools
+
+import "example.com/shop/controllers"
+
+var _ controllers.OrderController
@@ -0,0 +1,13 @@
+package usecases
+
+import (
+	"github.com/acme/log"
+
+	"example.com/shop/domain"
+)
+
+type OrderUsecase struct {
+	orders []domain.Order
+}
+
+func (u *OrderUsecase) Log() { log.Print("orders") }
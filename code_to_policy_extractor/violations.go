@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/duynguyendang/manglekit/sdk"
+)
+
+// --- Structured violation reports ---
+//
+// Assess only says whether a PR is aligned, and its error names a single
+// halt rule. Every compiled rule therefore also derives
+// violation(RuleID, File, Import), and after a rejected assessment the
+// linter queries all of them, so the report lists each violation with the
+// file and import that caused it. Line numbers are not Datalog facts; they
+// are looked up in the PR's files afterwards. Ignored violations are left
+// out and baselined ones marked existing, see exemptions.go.
+
+// Process exit codes of the linter when it reviews a checkout or a diff.
+const (
+	exitViolations  = 1
+	exitLinterError = 2
+)
+
+// Violation is one rule broken by one file.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	File    string `json:"file"`
+	// Import is the offending import; empty for naming rules.
+	Import string `json:"import,omitempty"`
+	// Line is the line of the import, or 0 when it is not known.
+	Line int `json:"line,omitempty"`
+	// Cycle is the dependency loop a cycle violation closes, starting and
+	// ending at the file's package or layer.
+	Cycle []string `json:"cycle,omitempty"`
+	// Existing is set for violations recorded in the baseline, which do
+	// not fail the review.
+	Existing bool `json:"existing,omitempty"`
+}
+
+// CollectViolations queries every violation derived from the facts loaded
+// for pr, sorted by file, line and rule.
+func CollectViolations(ctx context.Context, client *sdk.Client, g *Guidelines, pr PullRequest) ([]Violation, error) {
+	solutions, err := client.Engine().Query(ctx, nil, `violation(R, F, I)`)
+	if err != nil {
+		return nil, fmt.Errorf("query violation failed: %w", err)
+	}
+	ignored, err := queryViolations(ctx, client, "ignored")
+	if err != nil {
+		return nil, err
+	}
+	existing, err := queryViolations(ctx, client, "existing")
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(map[string]string)
+	for _, r := range g.Rules {
+		messages[r.ID()] = r.Message()
+	}
+	lines := make(map[[2]string]int)
+	for _, file := range pr.Files {
+		for imp, line := range file.ImportLines {
+			lines[[2]string{file.Path, imp}] = line
+		}
+	}
+
+	var packages, layers depGraph
+	seen := make(map[[3]string]bool)
+	var violations []Violation
+	for _, sol := range solutions {
+		v := Violation{Rule: unquote(sol["R"]), File: unquote(sol["F"]), Import: unquote(sol["I"])}
+		key := [3]string{v.Rule, v.File, v.Import}
+		if seen[key] || ignored[key] {
+			continue
+		}
+		seen[key] = true
+		v.Existing = existing[key]
+
+		v.Message = messages[v.Rule]
+		if v.Message == "" {
+			v.Message = v.Rule
+		}
+		v.Line = lines[[2]string{v.File, v.Import}]
+		switch v.Rule {
+		case (Rule{Kind: RuleNoPackageCycles}).ID():
+			if packages == nil {
+				packages = packageGraph(pr.packageFiles())
+			}
+			v.Cycle = packages.cyclePath(packageOf(v.File), v.Import)
+		case (Rule{Kind: RuleNoCycles}).ID():
+			m := layerMapOf(g)
+			if layers == nil {
+				layers = layerGraph(pr.Files, m)
+			}
+			v.Cycle = layers.cyclePath(m.Layer(v.File), m.Layer(v.Import))
+		}
+		violations = append(violations, v)
+	}
+	sortViolations(violations)
+	return violations, nil
+}
+
+// queryViolations returns the (rule, file, import) triples of pred.
+func queryViolations(ctx context.Context, client *sdk.Client, pred string) (map[[3]string]bool, error) {
+	solutions, err := client.Engine().Query(ctx, nil, pred+`(R, F, I)`)
+	if err != nil {
+		return nil, fmt.Errorf("query %s failed: %w", pred, err)
+	}
+	set := make(map[[3]string]bool)
+	for _, sol := range solutions {
+		set[[3]string{unquote(sol["R"]), unquote(sol["F"]), unquote(sol["I"])}] = true
+	}
+	return set, nil
+}
+
+// splitExisting separates the violations a review introduces from the
+// baselined ones, keeping their order.
+func splitExisting(vs []Violation) (introduced, existing []Violation) {
+	for _, v := range vs {
+		if v.Existing {
+			existing = append(existing, v)
+		} else {
+			introduced = append(introduced, v)
+		}
+	}
+	return introduced, existing
+}
+
+// unquote strips the quotes the engine may leave on a string constant.
+func unquote(s string) string {
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return s
+}
+
+func sortViolations(vs []Violation) {
+	sort.Slice(vs, func(i, j int) bool {
+		a, b := vs[i], vs[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		return a.Import < b.Import
+	})
+}
+
+// PrintViolations writes violations grouped by file. They must be sorted
+// by file, as CollectViolations returns them.
+func PrintViolations(w io.Writer, vs []Violation) {
+	fmt.Fprintf(w, "❌ PR REJECTED - %d architecture violation(s) in %d file(s):\n", len(vs), countFiles(vs))
+	printByFile(w, vs)
+}
+
+// PrintExisting writes baselined violations grouped by file.
+func PrintExisting(w io.Writer, vs []Violation) {
+	fmt.Fprintf(w, "ℹ️  %d existing violation(s) in %d file(s) recorded in the baseline:\n", len(vs), countFiles(vs))
+	printByFile(w, vs)
+}
+
+func countFiles(vs []Violation) int {
+	files := 0
+	for i, v := range vs {
+		if i == 0 || vs[i-1].File != v.File {
+			files++
+		}
+	}
+	return files
+}
+
+func printByFile(w io.Writer, vs []Violation) {
+	for i, v := range vs {
+		if i == 0 || vs[i-1].File != v.File {
+			fmt.Fprintf(w, "   📄 %s\n", v.File)
+		}
+		location := "     "
+		if v.Line > 0 {
+			location = fmt.Sprintf("     line %d:", v.Line)
+		}
+		if v.Import != "" {
+			fmt.Fprintf(w, "%s imports %q: %s [%s]\n", location, v.Import, v.Message, v.Rule)
+		} else {
+			fmt.Fprintf(w, "%s %s [%s]\n", location, v.Message, v.Rule)
+		}
+		if len(v.Cycle) > 0 {
+			fmt.Fprintf(w, "        cycle: %s\n", strings.Join(v.Cycle, " → "))
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/duynguyendang/manglekit/core"
+	"github.com/duynguyendang/manglekit/sdk"
+	"github.com/duynguyendang/manglekit/sdk/ooda"
+)
+
+// --- Running a generation loop, alone or as one of a concurrent batch ---
+//
+// Every document gets its own DocumentGenerator, OODA components and
+// frames; only the sdk.Client, the read-only PolicyLibrary, the state
+// provider and the loadedPolicies record are shared. Frames never share a
+// RawContext map: each new round's frame gets a copy of the previous
+// frame's steering context.
+
+// printf writes phase output to the generator's writer (stdout by default).
+func (g *DocumentGenerator) printf(format string, args ...any) {
+	fmt.Fprintf(g.writer(), format, args...)
+}
+
+func (g *DocumentGenerator) println(args ...any) {
+	fmt.Fprintln(g.writer(), args...)
+}
+
+func (g *DocumentGenerator) writer() io.Writer {
+	if g == nil || g.out == nil {
+		return os.Stdout
+	}
+	return g.out
+}
+
+// cloneContext copies a frame's RawContext so a retry frame can be
+// steered without aliasing the frame it came from.
+func cloneContext(raw map[string]any) map[string]any {
+	if raw == nil {
+		return nil
+	}
+	clone := make(map[string]any, len(raw))
+	for k, v := range raw {
+		clone[k] = v
+	}
+	return clone
+}
+
+// newFrame creates the frame for the next round, seeded with a copy of
+// the steering context carried over from the previous one.
+func (g *DocumentGenerator) newFrame(rawContext map[string]any) *ooda.CognitiveFrame {
+	frame := ooda.NewCognitiveFrame(g.input, g.sessionID, ooda.TaskTypeGeneration)
+	frame.MaxRetries = 5
+	if len(rawContext) > 0 {
+		frame.RawContext = cloneContext(rawContext)
+	}
+	return frame
+}
+
+// loadedPolicies records which doc-type policies a shared engine already
+// has, so loops sharing one sdk.Client load each policy exactly once.
+type loadedPolicies struct {
+	mu    sync.Mutex
+	types map[string]bool
+}
+
+func (l *loadedPolicies) has(docType string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.types[docType]
+}
+
+// ensure loads the given policies that are not loaded yet as a single
+// source, holding the lock so concurrent callers never load twice.
+func (l *loadedPolicies) ensure(ctx context.Context, client *sdk.Client, policies ...*DocPolicy) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var sources []string
+	var types []string
+	for _, p := range policies {
+		if l.types[p.DocType] {
+			continue
+		}
+		sources = append(sources, p.Source)
+		types = append(types, p.DocType)
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	if err := client.Engine().LoadPolicy(ctx, strings.Join(sources, "\n")); err != nil {
+		return fmt.Errorf("failed to load policy: %w", err)
+	}
+	if l.types == nil {
+		l.types = make(map[string]bool)
+	}
+	for _, t := range types {
+		l.types[t] = true
+	}
+	return nil
+}
+
+// newLoop wires a generator's OODA components against a shared client.
+func (g *DocumentGenerator) newLoop(client *sdk.Client, library *PolicyLibrary, loaded *loadedPolicies, outDir string) *ooda.Loop {
+	g.client = client
+	return ooda.NewLoop(
+		&MultiTurnObserver{gen: g},
+		&MultiTurnOrienter{client: client, gen: g, library: library, loaded: loaded},
+		&MultiTurnDecider{gen: g},
+		&MultiTurnVerifier{client: client, gen: g},
+		&MultiTurnActor{gen: g, outDir: outDir},
+	)
+}
+
+// errSimulatedCrash is returned by run when -crash-after stops the loop;
+// main exits on it as a crashed process would.
+var errSimulatedCrash = errors.New("simulated crash")
+
+// run drives the multi-turn generation loop from the generator's current
+// round until the document passes every gate or maxRounds is reached.
+func (g *DocumentGenerator) run(ctx context.Context, loop *ooda.Loop, rawContext map[string]any) (*ooda.CognitiveFrame, error) {
+	frame := g.newFrame(rawContext)
+	var resultFrame *ooda.CognitiveFrame
+
+	for round := g.round + 1; round <= g.maxRounds && !g.completed; round++ {
+		g.printf("\n%s\n", strings.Repeat("=", 60))
+		g.printf("=== GENERATION ROUND %d/%d ===\n", round, g.maxRounds)
+		g.printf("%s\n", strings.Repeat("=", 60))
+
+		var err error
+		resultFrame, err = loop.Run(ctx, g.input, frame)
+		if err != nil {
+			return resultFrame, fmt.Errorf("round %d terminated: %w", round, err)
+		}
+
+		// Check if steering triggered retry
+		if resultFrame.Decision != nil && resultFrame.Decision.Outcome == core.DecisionRetry {
+			g.printf("\n🔄 Round %d: Steering triggered retry → proceeding to round %d\n", round, round+1)
+
+			if g.crashAfter > 0 && g.verifiedRound >= g.crashAfter {
+				g.printf("\n💥 CRASH SIMULATED after round %d — rerun with -session %s to resume\n", g.verifiedRound, g.sessionID)
+				return resultFrame, errSimulatedCrash
+			}
+
+			// Preserve feedback for next iteration
+			frame = g.newFrame(resultFrame.RawContext)
+			continue
+		}
+
+		// Success
+		g.printf("\n✅ Round %d: Document passed all policy gates!\n", round)
+		break
+	}
+	return resultFrame, nil
+}
+
+// BatchRequest is one document to generate in a batch.
+type BatchRequest struct {
+	SessionID string
+	Input     string
+}
+
+// BatchResult is the per-document outcome of a batch.
+type BatchResult struct {
+	SessionID string
+	Input     string
+	DocType   string
+	Rounds    int
+	Passed    bool
+	Title     string
+	Document  string // published Markdown path, if any
+	Log       string // the document's phase output
+	Err       error
+}
+
+// readBatchFile reads one document request per line; blank lines and
+// lines starting with # are skipped. Session IDs are derived from prefix.
+func readBatchFile(path, prefix string) ([]BatchRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reqs []BatchRequest
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		reqs = append(reqs, BatchRequest{
+			SessionID: fmt.Sprintf("%s-%03d", prefix, len(reqs)+1),
+			Input:     line,
+		})
+	}
+	return reqs, scanner.Err()
+}
+
+// RunBatch generates every request with its own OODA loop, running at most
+// parallelism loops at once against the one shared client. Results are
+// returned in request order.
+func RunBatch(ctx context.Context, client *sdk.Client, library *PolicyLibrary, provider core.StateProvider, outDir string, reqs []BatchRequest, parallelism int) []BatchResult {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	results := make([]BatchResult, len(reqs))
+
+	// Load every policy the batch needs up front, so no LoadPolicy runs
+	// while other loops are evaluating against the shared engine.
+	loaded := &loadedPolicies{}
+	needed := make(map[string]*DocPolicy)
+	for i, req := range reqs {
+		results[i] = BatchResult{SessionID: req.SessionID, Input: req.Input}
+		profile, ok := classifyDocType(req.Input)
+		if !ok {
+			results[i].Err = fmt.Errorf("cannot classify document request %q", req.Input)
+			continue
+		}
+		results[i].DocType = profile.DocType
+		policy, err := library.Policy(profile.DocType)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		needed[profile.DocType] = policy
+	}
+	docTypes := make([]string, 0, len(needed))
+	for t := range needed {
+		docTypes = append(docTypes, t)
+	}
+	sort.Strings(docTypes)
+	policies := make([]*DocPolicy, 0, len(docTypes))
+	for _, t := range docTypes {
+		policies = append(policies, needed[t])
+	}
+	if err := loaded.ensure(ctx, client, policies...); err != nil {
+		for i := range results {
+			if results[i].Err == nil {
+				results[i].Err = err
+			}
+		}
+		return results
+	}
+
+	sem := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := range reqs {
+		if results[i].Err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = runBatchItem(ctx, client, library, loaded, provider, outDir, reqs[i], results[i])
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// runBatchItem runs one document's loop with its own generator state.
+func runBatchItem(ctx context.Context, client *sdk.Client, library *PolicyLibrary, loaded *loadedPolicies, provider core.StateProvider, outDir string, req BatchRequest, result BatchResult) BatchResult {
+	var out bytes.Buffer
+	gen := &DocumentGenerator{
+		input:     req.Input,
+		maxRounds: 5,
+		sessionID: req.SessionID,
+		state:     provider,
+		out:       &out,
+	}
+
+	rawContext, _, err := gen.resume(ctx)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to resume: %w", err)
+		return result
+	}
+
+	loop := gen.newLoop(client, library, loaded, outDir)
+	resultFrame, err := gen.run(ctx, loop, rawContext)
+	result.Log = out.String()
+	result.Rounds = gen.round
+	result.Title = gen.currentDraft.Title()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	// a resumed session that is already completed or out of rounds runs
+	// no round and returns no frame; a completed one still has the
+	// outcome its Act checkpoint recorded
+	if resultFrame != nil {
+		result.Passed = resultFrame.Decision != nil && resultFrame.Decision.Outcome == core.DecisionProceed
+		if path, ok := resultFrame.ActionResult.(string); ok && strings.HasSuffix(path, ".md") {
+			result.Document = path
+		}
+	} else if gen.completed {
+		result.Passed = gen.passed
+		result.Document = gen.document
+	}
+	if gen.completed && provider != nil {
+		if err := provider.Delete(ctx, gen.sessionID); err != nil {
+			result.Err = fmt.Errorf("failed to delete checkpoint: %w", err)
+		}
+	}
+	return result
+}
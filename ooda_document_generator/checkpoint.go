@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/duynguyendang/manglekit/core"
+	"github.com/duynguyendang/manglekit/sdk/ooda"
+)
+
+// --- Checkpointing: persist generation state after every OODA phase ---
+//
+// The generator's round counter, thrashing history, feedback log and
+// current draft are mapped onto a core.SessionState:
+//
+//   ActiveEnvelope.Payload         — draft as of the last Verify (sections)
+//   ActiveEnvelope.Metadata        — frame.RawContext (steering feedback)
+//   ExecutionCtx.FeedbackHistory   — thrashing-detection history
+//   ExecutionCtx.CurrentHistory    — feedback log, one message per entry
+//   LogicalFacts                   — phase_done/round_verified/tier_status facts
+//
+// Bookkeeping (phase, round, last verified round) lives under reserved
+// "ooda_" keys in the envelope metadata so it never collides with
+// steering keys in RawContext.
+
+const (
+	phaseObserve = "observe"
+	phaseOrient  = "orient"
+	phaseDecide  = "decide"
+	phaseVerify  = "verify"
+	phaseAct     = "act"
+
+	metaPhase         = "ooda_phase"
+	metaRound         = "ooda_round"
+	metaVerifiedRound = "ooda_verified_round"
+	metaCompleted     = "ooda_completed"
+	metaPassed        = "ooda_passed"
+	metaDocument      = "ooda_document"
+
+	feedbackRole = "feedback"
+)
+
+// checkpoint persists the generator state after a completed phase. It is
+// a no-op when the generator has no state provider, so the loop still
+// runs purely in memory (as the tests and the original demo do).
+func (g *DocumentGenerator) checkpoint(ctx context.Context, phase string, frame *ooda.CognitiveFrame) error {
+	if g == nil || g.state == nil {
+		return nil
+	}
+
+	switch phase {
+	case phaseVerify:
+		g.verifiedRound = g.round
+	case phaseAct:
+		g.verifiedRound = g.round
+		g.completed = frame.Decision == nil || frame.Decision.Outcome != core.DecisionRetry
+		g.passed = frame.Decision != nil && frame.Decision.Outcome == core.DecisionProceed
+		if path, ok := frame.ActionResult.(string); ok && strings.HasSuffix(path, ".md") {
+			g.document = path
+		}
+	}
+
+	metadata := make(map[string]any, len(frame.RawContext)+6)
+	for k, v := range frame.RawContext {
+		metadata[k] = v
+	}
+	metadata[metaPhase] = phase
+	metadata[metaRound] = g.round
+	metadata[metaVerifiedRound] = g.verifiedRound
+	metadata[metaCompleted] = g.completed
+	metadata[metaPassed] = g.passed
+	metadata[metaDocument] = g.document
+
+	history := make([]core.Message, 0, len(g.feedbackLog))
+	for _, entry := range g.feedbackLog {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode feedback entry: %w", err)
+		}
+		history = append(history, core.Message{Role: feedbackRole, Content: string(data)})
+	}
+
+	facts := []string{fmt.Sprintf(`phase_done(%d, %q).`, g.round, phase)}
+	for r := 1; r <= g.verifiedRound; r++ {
+		facts = append(facts, fmt.Sprintf(`round_verified(%d).`, r))
+	}
+	for _, t := range g.tiers {
+		facts = append(facts, fmt.Sprintf(`tier_status(%q, %q, %d).`, t.Tier, t.Rule, t.SatisfiedRound))
+	}
+
+	state := &core.SessionState{
+		SessionID: g.sessionID,
+		ActiveEnvelope: core.Envelope{
+			ID:          core.NewEnvelope(nil).ID,
+			Payload:     g.currentDraft,
+			ContentType: core.TypeJSON,
+			Metadata:    metadata,
+		},
+		ExecutionCtx: core.ExecutionContext{
+			RetryCount:      g.round,
+			FeedbackHistory: append([]string(nil), g.history...),
+			CurrentHistory:  history,
+		},
+		LogicalFacts: facts,
+	}
+	if err := state.Validate(); err != nil {
+		return fmt.Errorf("invalid checkpoint state: %w", err)
+	}
+	if err := g.state.Set(ctx, g.sessionID, state); err != nil {
+		return fmt.Errorf("failed to checkpoint %s phase of round %d: %w", phase, g.round, err)
+	}
+	return nil
+}
+
+// resume hydrates the generator from the last checkpoint for its session.
+// It restores the state as of the last verified round: a crash part-way
+// through round N replays round N from Decide with round N-1's feedback.
+// The restored RawContext is returned so the caller can seed the next
+// frame; ok is false when there is nothing to resume.
+func (g *DocumentGenerator) resume(ctx context.Context) (rawContext map[string]any, ok bool, err error) {
+	if g.state == nil {
+		return nil, false, nil
+	}
+
+	raw, err := g.state.Get(ctx, g.sessionID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	if raw == nil {
+		return nil, false, nil
+	}
+
+	var state core.SessionState
+	switch v := raw.(type) {
+	case []byte:
+		if err := json.Unmarshal(v, &state); err != nil {
+			return nil, false, fmt.Errorf("failed to decode checkpoint: %w", err)
+		}
+	case *core.SessionState:
+		state = *v
+	default:
+		return nil, false, fmt.Errorf("unexpected checkpoint type %T", raw)
+	}
+	if err := state.Validate(); err != nil {
+		return nil, false, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+
+	meta := state.ActiveEnvelope.Metadata
+	g.verifiedRound = metaInt(meta[metaVerifiedRound])
+	g.round = g.verifiedRound
+	g.completed, _ = meta[metaCompleted].(bool)
+	g.passed, _ = meta[metaPassed].(bool)
+	g.document, _ = meta[metaDocument].(string)
+	g.currentDraft, err = decodeDraft(state.ActiveEnvelope.Payload)
+	if err != nil {
+		return nil, false, err
+	}
+	g.verdicts = nil // re-check every tier against the restored draft
+	g.history = append([]string(nil), state.ExecutionCtx.FeedbackHistory...)
+
+	g.feedbackLog = nil
+	for _, msg := range state.ExecutionCtx.CurrentHistory {
+		if msg.Role != feedbackRole {
+			continue
+		}
+		var entry FeedbackEntry
+		if err := json.Unmarshal([]byte(msg.Content), &entry); err != nil {
+			return nil, false, fmt.Errorf("failed to decode feedback entry: %w", err)
+		}
+		g.feedbackLog = append(g.feedbackLog, entry)
+	}
+
+	g.tiers = nil
+	for _, fact := range state.LogicalFacts {
+		if m := tierStatusFact.FindStringSubmatch(fact); m != nil {
+			round, _ := strconv.Atoi(m[3])
+			g.tiers = append(g.tiers, TierStatus{Tier: m[1], Rule: m[2], SatisfiedRound: round})
+		}
+	}
+
+	rawContext = make(map[string]any)
+	for k, v := range meta {
+		if strings.HasPrefix(k, "ooda_") {
+			continue
+		}
+		rawContext[k] = v
+	}
+	return rawContext, true, nil
+}
+
+var tierStatusFact = regexp.MustCompile(`^tier_status\("([^"]*)", "([^"]*)", (\d+)\)\.$`)
+
+// metaInt reads an integer that may have been widened to float64 by a
+// JSON round-trip.
+func metaInt(v any) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	default:
+		return 0
+	}
+}
+
+// FileStateProvider implements core.StateProvider with one JSON file per
+// session, so checkpoints survive a process restart. Writes go to a temp
+// file that is fsynced and renamed into place, and the directory is
+// fsynced after the rename, so neither a crash nor a power failure leaves
+// a torn or lost checkpoint.
+type FileStateProvider struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileStateProvider(dir string) (*FileStateProvider, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %w", err)
+	}
+	return &FileStateProvider{dir: dir}, nil
+}
+
+// checkSessionID rejects session IDs that are not a plain file name, so
+// two sessions never share a checkpoint file and none escapes the state
+// directory.
+func checkSessionID(sessionID string) error {
+	if sessionID == "" || sessionID != filepath.Base(sessionID) || strings.HasPrefix(sessionID, ".") {
+		return fmt.Errorf("invalid session id %q", sessionID)
+	}
+	return nil
+}
+
+// path maps a session ID to its checkpoint file.
+func (p *FileStateProvider) path(sessionID string) (string, error) {
+	if err := checkSessionID(sessionID); err != nil {
+		return "", err
+	}
+	return filepath.Join(p.dir, sessionID+".json"), nil
+}
+
+func (p *FileStateProvider) Get(ctx context.Context, sessionID string) (any, error) {
+	path, err := p.path(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	return data, nil
+}
+
+func (p *FileStateProvider) Set(ctx context.Context, sessionID string, state any) error {
+	path, err := p.path(sessionID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tmp, err := os.CreateTemp(p.dir, sessionID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to sync state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to commit state: %w", err)
+	}
+	return p.syncDir()
+}
+
+func (p *FileStateProvider) Delete(ctx context.Context, sessionID string) error {
+	path, err := p.path(sessionID)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete state: %w", err)
+	}
+	return p.syncDir()
+}
+
+// syncDir fsyncs the state directory so a rename or remove is durable.
+// Windows cannot fsync a directory; there the rename is left to the OS.
+func (p *FileStateProvider) syncDir() error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(p.dir)
+	if err != nil {
+		return fmt.Errorf("failed to open state dir: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return fmt.Errorf("failed to sync state dir: %w", err)
+	}
+	return nil
+}
+
+func (p *FileStateProvider) Close(ctx context.Context) error {
+	return nil
+}
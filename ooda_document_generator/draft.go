@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// --- Drafts as sections, revisions as section-level patches ---
+//
+// A draft is an ordered list of named sections: the title ("content"),
+// one section per policy requirement argument, and the content_length /
+// content_quality steering sections. Each round the Decider emits a patch
+// against the committed draft instead of regenerating it; the Verifier
+// re-checks only the tiers whose requirement section the patch touched,
+// then commits the patched draft.
+
+// Section is one named part of a draft.
+type Section struct {
+	Name string `json:"name"`
+	Body string `json:"body"`
+}
+
+// Draft is an ordered set of sections.
+type Draft struct {
+	Sections []Section `json:"sections"`
+}
+
+// SectionEdit is one section-level change in a patch.
+type SectionEdit struct {
+	Op      string `json:"op"` // "add", "replace" or "delete"
+	Section string `json:"section"`
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+}
+
+func (e SectionEdit) String() string {
+	switch e.Op {
+	case "add":
+		return "+" + e.Section
+	case "delete":
+		return "-" + e.Section
+	default:
+		return "~" + e.Section
+	}
+}
+
+// formatPatch renders a patch compactly, e.g. "+has_approval ~content".
+func formatPatch(patch []SectionEdit) string {
+	if len(patch) == 0 {
+		return "(no changes)"
+	}
+	parts := make([]string, len(patch))
+	for i, e := range patch {
+		parts[i] = e.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// Get returns the body of a section.
+func (d Draft) Get(name string) (string, bool) {
+	for _, s := range d.Sections {
+		if s.Name == name {
+			return s.Body, true
+		}
+	}
+	return "", false
+}
+
+// With returns a copy of the draft with the section set to body,
+// appending it when it does not exist yet.
+func (d Draft) With(name, body string) Draft {
+	next := Draft{Sections: append([]Section(nil), d.Sections...)}
+	for i, s := range next.Sections {
+		if s.Name == name {
+			next.Sections[i].Body = body
+			return next
+		}
+	}
+	next.Sections = append(next.Sections, Section{Name: name, Body: body})
+	return next
+}
+
+// Without returns a copy of the draft with the section removed.
+func (d Draft) Without(name string) Draft {
+	next := Draft{}
+	for _, s := range d.Sections {
+		if s.Name != name {
+			next.Sections = append(next.Sections, s)
+		}
+	}
+	return next
+}
+
+// Diff returns the section-level patch that turns d into next.
+func (d Draft) Diff(next Draft) []SectionEdit {
+	var patch []SectionEdit
+	for _, s := range next.Sections {
+		before, ok := d.Get(s.Name)
+		switch {
+		case !ok:
+			patch = append(patch, SectionEdit{Op: "add", Section: s.Name, After: s.Body})
+		case before != s.Body:
+			patch = append(patch, SectionEdit{Op: "replace", Section: s.Name, Before: before, After: s.Body})
+		}
+	}
+	for _, s := range d.Sections {
+		if _, ok := next.Get(s.Name); !ok {
+			patch = append(patch, SectionEdit{Op: "delete", Section: s.Name, Before: s.Body})
+		}
+	}
+	return patch
+}
+
+// Apply returns a copy of the draft with the patch applied.
+func (d Draft) Apply(patch []SectionEdit) Draft {
+	next := Draft{Sections: append([]Section(nil), d.Sections...)}
+	for _, e := range patch {
+		if e.Op == "delete" {
+			next = next.Without(e.Section)
+			continue
+		}
+		next = next.With(e.Section, e.After)
+	}
+	return next
+}
+
+// Args materialises the draft as publish_doc action arguments.
+func (d Draft) Args() map[string]interface{} {
+	args := make(map[string]interface{}, len(d.Sections))
+	for _, s := range d.Sections {
+		args[s.Name] = s.Body
+	}
+	return args
+}
+
+// Title returns the draft's content line.
+func (d Draft) Title() string {
+	title, _ := d.Get("content")
+	return title
+}
+
+// decodeDraft restores a draft from a checkpoint payload, which a JSON
+// round-trip turns into a generic map.
+func decodeDraft(payload any) (Draft, error) {
+	switch v := payload.(type) {
+	case nil:
+		return Draft{}, nil
+	case Draft:
+		return v, nil
+	case string:
+		// Checkpoints written before drafts had sections stored the title only.
+		return Draft{}.With("content", v), nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Draft{}, err
+	}
+	var d Draft
+	if err := json.Unmarshal(data, &d); err != nil {
+		return Draft{}, fmt.Errorf("failed to decode draft: %w", err)
+	}
+	return d, nil
+}
+
+// patchFromArgs reads the patch the Decider attached to the action.
+func patchFromArgs(args map[string]interface{}) []SectionEdit {
+	patch, _ := args["patch"].([]SectionEdit)
+	return patch
+}
+
+// draftFacts builds the Datalog facts describing a draft for the policy.
+func (g *DocumentGenerator) draftFacts(args map[string]interface{}) []string {
+	facts := []string{
+		`action_operation("Req", "publish_doc").`,
+		fmt.Sprintf(`meta("doc_type", %q).`, g.profile.DocType),
+	}
+
+	// Inject satisfied requirements as Datalog facts
+	for _, req := range g.policy.Requirements {
+		if v, ok := args[req.Arg].(string); ok && v != "" {
+			facts = append(facts, fmt.Sprintf(`meta(%q, "present").`, req.Arg))
+		}
+	}
+	if v, ok := args["content_quality"].(string); ok && v != "" {
+		facts = append(facts, fmt.Sprintf(`meta("content_quality", %q).`, v))
+	}
+	if v, ok := args["content_length"].(string); ok && v != "" {
+		facts = append(facts, fmt.Sprintf(`meta("content_length", %q).`, v))
+	}
+	return facts
+}
+
+// touchedTiers returns the tiers whose requirement section the patch
+// changed. With no cached verdicts (first round, or after a resume or
+// escalation) every tier is checked.
+func (g *DocumentGenerator) touchedTiers(patch []SectionEdit) []TierStatus {
+	if g.tiers == nil && g.policy != nil {
+		g.tiers = append([]TierStatus(nil), g.policy.Tiers...)
+	}
+	if g.verdicts == nil {
+		g.verdicts = make(map[string]bool, len(g.tiers))
+		return g.tiers
+	}
+
+	changed := make(map[string]bool, len(patch))
+	for _, e := range patch {
+		changed[e.Section] = true
+	}
+	var touched []TierStatus
+	for _, t := range g.tiers {
+		if req, ok := g.policy.requirementFor(t.Tier); ok && changed[req.Arg] {
+			touched = append(touched, t)
+		}
+	}
+	return touched
+}
+
+// failingRules lists the rules of tiers whose cached verdict is a halt,
+// in tier order.
+func (g *DocumentGenerator) failingRules() []string {
+	var rules []string
+	for _, t := range g.tiers {
+		if g.verdicts[t.Tier] {
+			rules = append(rules, t.Rule)
+		}
+	}
+	return rules
+}
+
+// tierOf returns the tier of a halt reason, or "T?" if it is not one of
+// the policy's tier rules.
+func (g *DocumentGenerator) tierOf(reason string) string {
+	for _, t := range g.tiers {
+		if strings.Contains(reason, t.Rule) {
+			return t.Tier
+		}
+	}
+	return "T?"
+}
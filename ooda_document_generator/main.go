@@ -2,8 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/duynguyendang/manglekit/core"
@@ -19,132 +25,136 @@ import (
 //
 // The document goes through multiple refinement rounds:
 //   Round 1: Initial draft (likely fails T0/T1 checks)
-//   Round 2: Incorporate security feedback
-//   Round 3: Incorporate compliance feedback
-//   Round 4: Final quality check
+//   Round 2: Incorporate T0 feedback
+//   Round 3: Incorporate T1/T2 feedback
+//   Round 4: Incorporate T3 feedback and final quality check
 //
 // Each round's Verify phase produces structured RefinementContext
-// that feeds back into the next Decide phase.
-
-// --- Constants: Datalog Policy ---
-
-const documentPolicy = `
-		Decl has_approval(V).
-		Decl has_author(V).
-		Decl has_version(V).
-		Decl has_changelog(V).
-		Decl content_quality(V).
-
-		% === T0: Security Approval (Kernel Axiom) ===
-		halt("Req", "T0: missing security approval") :-
-			action_operation("Req", "publish_doc"),
-			!has_approval("true").
-
-		% === T1: Author Attribution (Governance) ===
-		halt("Req", "T1: missing author attribution") :-
-			action_operation("Req", "publish_doc"),
-			!has_author("true").
-
-		% === T2: Version Number (Playbook) ===
-		halt("Req", "T2: missing version number") :-
-			action_operation("Req", "publish_doc"),
-			!meta("has_version", "present").
-
-		% === T3: Change Log (User/Quality) ===
-		halt("Req", "T3: missing change log") :-
-			action_operation("Req", "publish_doc"),
-			!meta("has_changelog", "present").
-
-		% === Steering Rules ===
-		% If content is too short, retry with expansion feedback
-		retry("Req", "Content too short — expand with technical details") :-
-			action_operation("Req", "publish_doc"),
-			meta("content_length", "short").
-
-		% If content quality is low, retry with improvement feedback
-		retry("Req", "Content quality low — improve clarity and specificity") :-
-			action_operation("Req", "publish_doc"),
-			meta("content_quality", "low").
-
-		% Route to approval step if approval is missing
-		route("Req", "request_approval") :-
-			action_operation("Req", "publish_doc"),
-			!has_approval("true").
-
-		% Route to authoring step if author is missing
-		route("Req", "add_author") :-
-			action_operation("Req", "publish_doc"),
-			!has_author("true").
-	`
+// that feeds back into the next Decide phase. The Observer classifies
+// the request into a doc type (security_policy, runbook, adr,
+// release_notes) and the Orienter loads that type's T0–T3 policy from
+// the policies/ directory.
+//
+// Every completed phase is checkpointed into a core.StateProvider, so a
+// crashed run restarted with the same -session resumes from the last
+// verified round instead of redoing round 1. Rounds revise the draft
+// with section-level patches, and Verify re-checks only the tiers a
+// patch touches. The verified document is
+// published as Markdown with a JSON compliance certificate sidecar.
+//
+// With -batch, every request in the file gets its own generator and loop,
+// and up to -parallel loops run concurrently against one sdk.Client.
 
 // DocumentGenerator holds the state for multi-turn generation.
 type DocumentGenerator struct {
 	client       *sdk.Client
+	input        string
 	round        int
 	maxRounds    int
 	history      []string // feedback history to detect thrashing
-	currentDraft string
+	currentDraft Draft    // draft as of the last Verify; every round's patch is kept, pass or fail
 	feedbackLog  []FeedbackEntry
+	verdicts     map[string]bool // tier -> halt rule fires, cached between rounds
+
+	// Optional persistence: when state is set, every completed phase is
+	// checkpointed under sessionID so a restarted run can resume.
+	sessionID     string
+	state         core.StateProvider
+	verifiedRound int
+	completed     bool
+	passed        bool   // the completed session's last decision was Proceed
+	document      string // Markdown path the completed session published
+
+	// Set by Observe/Orient from the classified input.
+	profile DocProfile
+	policy  *DocPolicy
+
+	// Compliance record for the published certificate.
+	tiers     []TierStatus
+	lastAudit *core.AuditTrail
+
+	out        io.Writer // phase output; os.Stdout when nil
+	crashAfter int       // simulate a crash after this verified round (0 disables)
 }
 
 type FeedbackEntry struct {
-	Round    int
-	Rule     string
-	Tier     string
-	Feedback string
+	Round    int           `json:"round"`
+	Rule     string        `json:"rule"`
+	Tier     string        `json:"tier"`
+	Feedback string        `json:"feedback"`
+	Patch    []SectionEdit `json:"patch,omitempty"` // the round's diff against the previous draft
 }
 
-// --- Observer: Analyzes input and detects document type ---
+// --- Observer: Classifies the request and detects document type ---
 
 type MultiTurnObserver struct {
 	gen *DocumentGenerator
 }
 
 func (o *MultiTurnObserver) Observe(ctx context.Context, frame *ooda.CognitiveFrame) error {
-	fmt.Println("👁️  [Observe] Analyzing input...")
+	o.gen.println("👁️  [Observe] Analyzing input...")
+
+	profile, ok := classifyDocType(o.gen.input)
+	if !ok {
+		return fmt.Errorf("cannot classify document request %q", o.gen.input)
+	}
+	o.gen.profile = profile
 
 	frame.Context = append(frame.Context, ooda.Atom{
-		Predicate: "doc_type", Subject: "doc", Object: "security_policy",
+		Predicate: "doc_type", Subject: "doc", Object: profile.DocType,
 	})
 	frame.Context = append(frame.Context, ooda.Atom{
-		Predicate: "requires_review", Subject: "doc", Object: "security_team",
+		Predicate: "requires_review", Subject: "doc", Object: profile.Reviewer,
 	})
 	frame.Context = append(frame.Context, ooda.Atom{
-		Predicate: "classification", Subject: "doc", Object: "internal",
+		Predicate: "classification", Subject: "doc", Object: profile.Classification,
 	})
 
-	fmt.Println("   -> Document type: security policy")
-	fmt.Println("   -> Requires: security team review")
-	fmt.Println("   -> Classification: internal")
-	return nil
+	o.gen.printf("   -> Document type: %s\n", profile.DocType)
+	o.gen.printf("   -> Requires: %s review\n", profile.Reviewer)
+	o.gen.printf("   -> Classification: %s\n", profile.Classification)
+	return o.gen.checkpoint(ctx, phaseObserve, frame)
 }
 
-// --- Orienter: Loads tiered Datalog policies ---
+// --- Orienter: Loads the tiered Datalog policy for the doc type ---
 
 type MultiTurnOrienter struct {
-	client *sdk.Client
-	loaded bool
+	client  *sdk.Client
+	gen     *DocumentGenerator
+	library *PolicyLibrary
+	loaded  *loadedPolicies // shared by every loop on the same client
 }
 
 func (o *MultiTurnOrienter) Orient(ctx context.Context, frame *ooda.CognitiveFrame) error {
-	if o.loaded {
-		fmt.Println("🧭 [Orient] Policies already loaded (refinement round).")
-		return nil
+	docType := frameDocType(frame)
+	policy, err := o.library.Policy(docType)
+	if err != nil {
+		return err
+	}
+	o.gen.policy = policy
+	if o.gen.tiers == nil {
+		o.gen.tiers = append([]TierStatus(nil), policy.Tiers...)
 	}
 
-	fmt.Println("🧭 [Orient] Loading tiered Datalog policies...")
+	if o.loaded == nil {
+		o.loaded = &loadedPolicies{}
+	}
+	if o.loaded.has(docType) {
+		o.gen.printf("🧭 [Orient] %s policy already loaded (refinement round).\n", docType)
+		return o.gen.checkpoint(ctx, phaseOrient, frame)
+	}
 
-	if err := o.client.Engine().LoadPolicy(ctx, documentPolicy); err != nil {
-		return fmt.Errorf("failed to load policy: %w", err)
+	o.gen.printf("🧭 [Orient] Loading tiered Datalog policy %s...\n", filepath.Base(policy.Path))
+
+	if err := o.loaded.ensure(ctx, o.client, policy); err != nil {
+		return err
 	}
-	o.loaded = true
 
-	fmt.Println("   -> T0: security approval (hard block)")
-	fmt.Println("   -> T1: author attribution (hard block)")
-	fmt.Println("   -> T2: version number (soft block)")
-	fmt.Println("   -> T3: change log (soft warning)")
-	fmt.Println("   -> Steering: retry/route rules active")
-	return nil
+	for _, t := range policy.Tiers {
+		o.gen.printf("   -> %s\n", t.Rule)
+	}
+	o.gen.println("   -> Steering: retry/route rules active")
+	return o.gen.checkpoint(ctx, phaseOrient, frame)
 }
 
 // --- Decider: Formulates plan incorporating feedback ---
@@ -153,38 +163,47 @@ type MultiTurnDecider struct {
 	gen *DocumentGenerator
 }
 
+// tiersByRound is the progressive-compliance schedule: the requirement
+// for each tier is added in the round after its feedback first arrives.
+var tiersByRound = map[int][]string{
+	2: {"T0"},
+	3: {"T1", "T2"},
+	4: {"T3"},
+}
+
 func (d *MultiTurnDecider) Decide(ctx context.Context, frame *ooda.CognitiveFrame) error {
 	d.gen.round++
-	fmt.Printf("🧠 [Decide] Round %d: Formulating action plan...\n", d.gen.round)
+	d.gen.printf("🧠 [Decide] Round %d: Formulating action plan...\n", d.gen.round)
 
-	// Build content based on feedback from previous rounds
-	content := d.gen.buildContent()
-
-	// Determine which approval attributes to include based on round
-	args := map[string]interface{}{
-		"content":        content,
-		"content_length": "short", // Will trigger retry if not improved
+	// Revise the committed draft rather than regenerating it
+	draft := d.gen.currentDraft.With("content", d.gen.buildContent())
+	if _, ok := draft.Get("content_length"); !ok {
+		draft = draft.With("content_length", "short") // Will trigger retry if not improved
 	}
 
-	// Progressive compliance: add attributes based on feedback
-	if d.gen.round >= 2 {
-		args["has_approval"] = "true" // Added after round 1 feedback
-		fmt.Println("   -> ✅ Adding: security approval (from round 1 feedback)")
-	}
-	if d.gen.round >= 3 {
-		args["has_author"] = "true" // Added after round 2 feedback
-		args["has_version"] = "v1.0"
-		fmt.Println("   -> ✅ Adding: author attribution (from round 2 feedback)")
-		fmt.Println("   -> ✅ Adding: version number (from round 2 feedback)")
+	// Progressive compliance: add the requirement for each tier based on feedback
+	for round := 2; round <= d.gen.round; round++ {
+		for _, tier := range tiersByRound[round] {
+			req, ok := d.gen.policy.requirementFor(tier)
+			if !ok {
+				continue
+			}
+			if _, present := draft.Get(req.Arg); !present {
+				d.gen.printf("   -> ✅ Adding: %s [%s] (from round %d feedback)\n", req.Arg, tier, round-1)
+			}
+			draft = draft.With(req.Arg, req.Value)
+		}
 	}
 	if d.gen.round >= 4 {
-		args["has_changelog"] = "v1.0: Initial release"
-		args["content_length"] = "adequate"
-		args["content_quality"] = "high"
-		fmt.Println("   -> ✅ Adding: change log (from round 3 feedback)")
-		fmt.Println("   -> ✅ Content expanded and improved")
+		draft = draft.With("content_length", "adequate").With("content_quality", "high")
+		d.gen.println("   -> ✅ Content expanded and improved")
 	}
 
+	patch := d.gen.currentDraft.Diff(draft)
+	args := draft.Args()
+	args["patch"] = patch
+	d.gen.printf("   -> Patch: %s\n", formatPatch(patch))
+
 	frame.Decision = &core.Decision{
 		Outcome: core.DecisionProceed,
 		Action: &core.ActionEnvelope{
@@ -193,24 +212,27 @@ func (d *MultiTurnDecider) Decide(ctx context.Context, frame *ooda.CognitiveFram
 		},
 	}
 
-	fmt.Printf("   -> Plan: Publish document (round %d draft)\n", d.gen.round)
-	return nil
+	d.gen.printf("   -> Plan: Publish document (round %d draft)\n", d.gen.round)
+	return d.gen.checkpoint(ctx, phaseDecide, frame)
 }
 
 // buildContent generates content based on round history.
 func (d *DocumentGenerator) buildContent() string {
-	base := "Security Policy for Authentication Module"
+	base := d.profile.Title
+	if subject := documentSubject(d.input); subject != "" {
+		base += " for " + subject
+	}
 
 	if d.round == 1 {
 		return base + " - DRAFT"
 	}
 	if d.round == 2 {
-		return base + " - REVISED (security review incorporated)"
+		return base + " - REVISED (" + d.profile.Reviewer + " review incorporated)"
 	}
 	if d.round == 3 {
-		return base + " - v1.0 (author: Security Team, reviewed)"
+		return base + " - v1.0 (attributed, reviewed)"
 	}
-	return base + " - v1.0 (author: Security Team, reviewed, changelog included)"
+	return base + " - v1.0 (attributed, reviewed, complete)"
 }
 
 // --- Verifier: Validates against policies with structured feedback ---
@@ -221,50 +243,72 @@ type MultiTurnVerifier struct {
 }
 
 func (v *MultiTurnVerifier) Verify(ctx context.Context, frame *ooda.CognitiveFrame) error {
-	fmt.Printf("🛡️  [Verify] Round %d: Validating against policies...\n", v.gen.round)
+	if err := v.verify(ctx, frame); err != nil {
+		return err
+	}
+	return v.gen.checkpoint(ctx, phaseVerify, frame)
+}
 
-	// Build envelope from decision
-	env := core.NewEnvelope(frame.Decision.Action.Arguments)
-	env.Facts = append(env.Facts, `action_operation("Req", "publish_doc").`)
+// verify re-checks the tiers touched by the round's patch, runs the full
+// policy assessment once every tier passes, and injects steering feedback.
+// The patched draft is committed whatever the outcome, so the next round
+// revises it.
+func (v *MultiTurnVerifier) verify(ctx context.Context, frame *ooda.CognitiveFrame) error {
+	v.gen.printf("🛡️  [Verify] Round %d: Validating against policies...\n", v.gen.round)
 
-	// Inject approval attributes as Datalog facts
 	args := frame.Decision.Action.Arguments
-	if v, ok := args["has_approval"].(string); ok && v == "true" {
-		env.Facts = append(env.Facts, `has_approval("true").`)
-	}
-	if v, ok := args["has_author"].(string); ok && v == "true" {
-		env.Facts = append(env.Facts, `has_author("true").`)
-	}
-	if v, ok := args["has_version"].(string); ok && v != "" {
-		env.Facts = append(env.Facts, `meta("has_version", "present").`)
-	}
-	if v, ok := args["has_changelog"].(string); ok && v != "" {
-		env.Facts = append(env.Facts, `meta("has_changelog", "present").`)
-	}
-	if v, ok := args["content_quality"].(string); ok && v != "" {
-		env.Facts = append(env.Facts, fmt.Sprintf(`meta("content_quality", "%s").`, v))
-	}
-	if v, ok := args["content_length"].(string); ok && v != "" {
-		env.Facts = append(env.Facts, fmt.Sprintf(`meta("content_length", "%s").`, v))
+	patch := patchFromArgs(args)
+	facts := v.gen.draftFacts(args)
+
+	// Incremental check: only tiers whose requirement section changed
+	touched := v.gen.touchedTiers(patch)
+	v.gen.printf("   -> Patch %s touches %d of %d tier rule(s)\n", formatPatch(patch), len(touched), len(v.gen.tiers))
+	for _, t := range touched {
+		solutions, err := v.client.Engine().Query(ctx, facts, fmt.Sprintf(`halt("Req", %q)`, t.Rule))
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %w", t.Tier, err)
+		}
+		v.gen.verdicts[t.Tier] = len(solutions) > 0
+		status := "✅ PASS"
+		if v.gen.verdicts[t.Tier] {
+			status = "❌ FAIL"
+		}
+		v.gen.printf("      [%s] %s %s\n", t.Tier, status, t.Rule)
 	}
 
-	// Run AssessPlan — returns Decision with AuditTrail
-	decision, err := v.client.Engine().AssessPlan(ctx, env)
-	if err != nil {
-		fmt.Printf("   -> ⚠️  AssessPlan error: %v\n", err)
-	}
+	var decision *core.Decision
+	if failing := v.gen.failingRules(); len(failing) > 0 {
+		decision = &core.Decision{Outcome: core.DecisionHalt, Reasons: failing}
+	} else {
+		// Every tier passes: certify the whole draft with a full assessment
+		env := core.NewEnvelope(args)
+		env.Facts = append(env.Facts, facts...)
 
-	// Print audit trail
-	if decision.AuditTrail != nil && len(decision.AuditTrail.MatchedRules) > 0 {
-		fmt.Println("   -> 📋 Rules evaluated:")
-		for _, rule := range decision.AuditTrail.MatchedRules {
-			status := "✅ PASS"
-			if strings.Contains(strings.ToLower(rule.RuleName), "halt") {
-				status = "❌ FAIL"
+		// Run AssessPlan — returns Decision with AuditTrail
+		assessed, err := v.client.Engine().AssessPlan(ctx, env)
+		if err != nil {
+			return fmt.Errorf("failed to assess draft: %w", err)
+		}
+		if assessed == nil {
+			return fmt.Errorf("failed to assess draft: no decision")
+		}
+		decision = &core.Decision{Outcome: assessed.Outcome, Reasons: assessed.Reasons, AuditTrail: assessed.AuditTrail}
+		v.gen.lastAudit = decision.AuditTrail
+
+		// Print audit trail
+		if decision.AuditTrail != nil && len(decision.AuditTrail.MatchedRules) > 0 {
+			v.gen.println("   -> 📋 Rules evaluated:")
+			for _, rule := range decision.AuditTrail.MatchedRules {
+				status := "✅ PASS"
+				if strings.Contains(strings.ToLower(rule.RuleName), "halt") {
+					status = "❌ FAIL"
+				}
+				v.gen.printf("      [%s] %s %s\n", rule.Tier, status, rule.Predicate)
 			}
-			fmt.Printf("      [%s] %s %s\n", rule.Tier, status, rule.Predicate)
 		}
 	}
+	v.gen.recordTiers(decision.Reasons)
+	v.gen.currentDraft = v.gen.currentDraft.Apply(patch)
 
 	if decision.Outcome == core.DecisionHalt {
 		feedback := "fix policy violations"
@@ -273,7 +317,7 @@ func (v *MultiTurnVerifier) Verify(ctx context.Context, frame *ooda.CognitiveFra
 		}
 
 		// Extract tier from the first halted rule
-		tier := "T?"
+		tier := v.gen.tierOf(feedback)
 		if decision.AuditTrail != nil && len(decision.AuditTrail.MatchedRules) > 0 {
 			for _, rule := range decision.AuditTrail.MatchedRules {
 				if strings.Contains(strings.ToLower(rule.RuleName), "halt") {
@@ -283,31 +327,36 @@ func (v *MultiTurnVerifier) Verify(ctx context.Context, frame *ooda.CognitiveFra
 			}
 		}
 
-		fmt.Printf("   -> ❌ HALT [%s]: %s\n", tier, feedback)
+		v.gen.printf("   -> ❌ HALT [%s]: %s\n", tier, feedback)
 
-		// Log feedback for history
+		// Log feedback and the round's diff for history
 		v.gen.feedbackLog = append(v.gen.feedbackLog, FeedbackEntry{
 			Round:    v.gen.round,
 			Rule:     feedback,
 			Tier:     tier,
 			Feedback: feedback,
+			Patch:    patch,
 		})
 
 		// Check for thrashing (same feedback repeated)
 		for _, prev := range v.gen.history {
 			if prev == feedback {
-				fmt.Println("   -> ⚠️  Thrashing detected: same feedback as previous round")
-				fmt.Println("   -> 🔄 Escalating: adding all missing attributes to force convergence")
-				// Force all attributes to break the cycle
+				v.gen.println("   -> ⚠️  Thrashing detected: same feedback as previous round")
+				v.gen.println("   -> 🔄 Escalating: adding all missing attributes to force convergence")
+				// Force all attributes into the committed draft to break the cycle
+				escalated := v.gen.currentDraft.With("content_length", "adequate").With("content_quality", "high")
+				for _, req := range v.gen.policy.Requirements {
+					escalated = escalated.With(req.Arg, req.Value)
+				}
 				if frame.Decision.Action != nil {
-					frame.Decision.Action.Arguments["has_approval"] = "true"
-					frame.Decision.Action.Arguments["has_author"] = "true"
-					frame.Decision.Action.Arguments["has_version"] = "v1.0"
-					frame.Decision.Action.Arguments["has_changelog"] = "v1.0: Initial release"
-					frame.Decision.Action.Arguments["content_length"] = "adequate"
-					frame.Decision.Action.Arguments["content_quality"] = "high"
+					escalation := v.gen.currentDraft.Diff(escalated)
+					frame.Decision.Action.Arguments = escalated.Args()
+					frame.Decision.Action.Arguments["patch"] = escalation
+					v.gen.printf("   -> Escalation patch: %s\n", formatPatch(escalation))
 				}
-				v.gen.history = nil // Reset history after escalation
+				v.gen.currentDraft = escalated
+				v.gen.verdicts = nil // Re-check every tier against the escalated draft
+				v.gen.history = nil  // Reset history after escalation
 				frame.Decision.Outcome = core.DecisionRetry
 				return nil
 			}
@@ -325,39 +374,70 @@ func (v *MultiTurnVerifier) Verify(ctx context.Context, frame *ooda.CognitiveFra
 		frame.RawContext["round"] = v.gen.round
 
 		frame.Decision.Outcome = core.DecisionRetry
-		fmt.Printf("   -> 🔄 Steering: retry with feedback (round %d → %d)\n", v.gen.round, v.gen.round+1)
+		v.gen.printf("   -> 🔄 Steering: retry with feedback (round %d → %d)\n", v.gen.round, v.gen.round+1)
 		return nil
 	}
 
-	fmt.Println("   -> ✅ All policy gates passed!")
+	v.gen.feedbackLog = append(v.gen.feedbackLog, FeedbackEntry{
+		Round:    v.gen.round,
+		Feedback: "all policy gates passed",
+		Patch:    patch,
+	})
+	v.gen.println("   -> ✅ All policy gates passed!")
 	return nil
 }
 
 // --- Actor: Executes the action ---
 
 type MultiTurnActor struct {
-	gen *DocumentGenerator
+	gen    *DocumentGenerator
+	outDir string // when set, verified documents are published here
 }
 
 func (a *MultiTurnActor) Act(ctx context.Context, frame *ooda.CognitiveFrame) error {
-	fmt.Println("⚡ [Act] Executing...")
+	a.gen.println("⚡ [Act] Executing...")
 
 	decision := frame.Decision
 	if decision == nil || decision.Action == nil {
-		fmt.Println("   -> ⚠️  No action to execute.")
+		a.gen.println("   -> ⚠️  No action to execute.")
 		return nil
 	}
 
 	content := decision.Action.Arguments["content"].(string)
-	a.gen.currentDraft = content
 
-	fmt.Printf("   -> 📄 Document generated: %s\n", content)
+	a.gen.printf("   -> 📄 Document generated: %s\n", content)
 
 	frame.ActionResult = fmt.Sprintf("Round %d draft: %s", a.gen.round, content)
-	return nil
+
+	if a.outDir != "" && decision.Outcome == core.DecisionProceed {
+		mdPath, certPath, err := a.gen.publishDocument(a.outDir, frame)
+		if err != nil {
+			return fmt.Errorf("failed to publish document: %w", err)
+		}
+		a.gen.printf("   -> 📝 Markdown: %s\n", mdPath)
+		a.gen.printf("   -> 🔏 Certificate: %s\n", certPath)
+		frame.ActionResult = mdPath
+	}
+	return a.gen.checkpoint(ctx, phaseAct, frame)
+}
+
+func exampleDir() string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Dir(filename)
 }
 
 func main() {
+	workDir := filepath.Join(os.TempDir(), "ooda_document_generator")
+	stateDir := flag.String("state-dir", filepath.Join(workDir, "state"), "directory for generation checkpoints")
+	outDir := flag.String("out-dir", filepath.Join(workDir, "out"), "directory for the published Markdown document and certificate")
+	policyDir := flag.String("policy-dir", filepath.Join(exampleDir(), "policies"), "directory of <doc_type>.dl policy files")
+	input := flag.String("input", "Create a security policy document for the authentication module.", "document request to generate")
+	sessionID := flag.String("session", "session-multi", "session ID to checkpoint under and resume from")
+	crashAfter := flag.Int("crash-after", 0, "simulate a crash by exiting after round N is verified (0 disables)")
+	batchFile := flag.String("batch", "", "file with one document request per line to generate concurrently")
+	parallel := flag.Int("parallel", 4, "maximum number of documents generated at once in batch mode")
+	flag.Parse()
+
 	ctx := context.Background()
 
 	fmt.Println("🔁 OODA Multi-Turn Document Generator with Steering")
@@ -372,67 +452,61 @@ func main() {
 		log.Fatalf("Failed to initialize client: %v", err)
 	}
 
-	gen := &DocumentGenerator{
-		maxRounds: 5,
+	provider, err := NewFileStateProvider(*stateDir)
+	if err != nil {
+		log.Fatalf("Failed to open state dir: %v", err)
 	}
 
-	// 2. Instantiate components
-	observer := &MultiTurnObserver{gen: gen}
-	orienter := &MultiTurnOrienter{client: client}
-	decider := &MultiTurnDecider{gen: gen}
-	verifier := &MultiTurnVerifier{client: client, gen: gen}
-	actor := &MultiTurnActor{gen: gen}
-
-	// 3. Create OODA loop
-	loop := ooda.NewLoop(observer, orienter, decider, verifier, actor)
-
-	input := "Create a security policy document for the authentication module."
-	frame := ooda.NewCognitiveFrame(input, "session-multi", ooda.TaskTypeGeneration)
-	frame.MaxRetries = 5
-
-	// 4. Multi-turn generation loop
-	fmt.Println("Starting multi-turn generation...")
-	fmt.Println()
+	library, err := LoadPolicyLibrary(*policyDir)
+	if err != nil {
+		log.Fatalf("Failed to load policies: %v", err)
+	}
 
-	var resultFrame *ooda.CognitiveFrame
+	if *batchFile != "" {
+		runBatchMode(ctx, client, library, provider, *outDir, *batchFile, *sessionID, *parallel)
+		return
+	}
 
-	for round := 1; round <= gen.maxRounds; round++ {
-		fmt.Printf("\n%s\n", strings.Repeat("=", 60))
-		fmt.Printf("=== GENERATION ROUND %d/%d ===\n", round, gen.maxRounds)
-		fmt.Printf("%s\n", strings.Repeat("=", 60))
+	gen := &DocumentGenerator{
+		input:      *input,
+		maxRounds:  5,
+		sessionID:  *sessionID,
+		state:      provider,
+		crashAfter: *crashAfter,
+	}
 
-		resultFrame, err = loop.Run(ctx, input, frame)
-		if err != nil {
-			fmt.Printf("\n🛑 Round %d terminated: %v\n", round, err)
-			break
-		}
+	rawContext, resumed, err := gen.resume(ctx)
+	if err != nil {
+		log.Fatalf("Failed to resume session %s: %v", *sessionID, err)
+	}
+	if resumed {
+		fmt.Printf("♻️  Resumed session %s from checkpoint (last verified round: %d, %d feedback entries)\n",
+			*sessionID, gen.verifiedRound, len(gen.feedbackLog))
+		fmt.Println()
+	}
 
-		// Check if steering triggered retry
-		if resultFrame.Decision != nil && resultFrame.Decision.Outcome == core.DecisionRetry {
-			fmt.Printf("\n🔄 Round %d: Steering triggered retry → proceeding to round %d\n", round, round+1)
+	// 2. Instantiate components and create the OODA loop
+	loop := gen.newLoop(client, library, &loadedPolicies{}, *outDir)
 
-			// Preserve feedback for next iteration
-			newFrame := ooda.NewCognitiveFrame(input, "session-multi", ooda.TaskTypeGeneration)
-			newFrame.MaxRetries = 5
-			if resultFrame.RawContext != nil {
-				newFrame.RawContext = resultFrame.RawContext
-			}
-			frame = newFrame
-			continue
-		}
+	// 3. Multi-turn generation loop
+	fmt.Println("Starting multi-turn generation...")
+	fmt.Println()
 
-		// Success
-		fmt.Printf("\n✅ Round %d: Document passed all policy gates!\n", round)
-		break
+	resultFrame, err := gen.run(ctx, loop, rawContext)
+	if errors.Is(err, errSimulatedCrash) {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("\n🛑 %v\n", err)
 	}
 
-	// 5. Summary
+	// 4. Summary
 	fmt.Println("\n" + strings.Repeat("=", 60))
 	fmt.Println("FINAL SUMMARY")
 	fmt.Println(strings.Repeat("=", 60))
 
-	if resultFrame != nil {
-		fmt.Printf("Final Document: %s\n", gen.currentDraft)
+	if resultFrame != nil || gen.completed {
+		fmt.Printf("Final Document: %s\n", gen.currentDraft.Title())
 		fmt.Printf("Total Rounds: %d\n", gen.round)
 		fmt.Printf("Convergence: %s\n", func() string {
 			if gen.round <= 2 {
@@ -443,18 +517,92 @@ func main() {
 
 		fmt.Println("\nFeedback History:")
 		for i, entry := range gen.feedbackLog {
+			if entry.Tier == "" {
+				continue
+			}
 			fmt.Printf("  %d. Round %d [%s]: %s\n", i+1, entry.Round, entry.Tier, entry.Feedback)
 		}
 
+		fmt.Println("\nDocument Evolution:")
+		for _, entry := range gen.feedbackLog {
+			fmt.Printf("  Round %d: %s\n", entry.Round, formatPatch(entry.Patch))
+			for _, edit := range entry.Patch {
+				switch edit.Op {
+				case "add":
+					fmt.Printf("    + %s: %s\n", edit.Section, edit.After)
+				case "delete":
+					fmt.Printf("    - %s: %s\n", edit.Section, edit.Before)
+				default:
+					fmt.Printf("    ~ %s: %s → %s\n", edit.Section, edit.Before, edit.After)
+				}
+			}
+		}
+
 		fmt.Println("\nWhat steering did:")
 		fmt.Println("  1. Verify detected T0/T1/T2/T3 violations via Datalog rules")
 		fmt.Println("  2. Injected structured feedback (FailedRules, ConflictPath)")
-		fmt.Println("  3. Decide incorporated feedback into next draft")
-		fmt.Println("  4. Loop continued until all gates passed")
-		fmt.Println("  5. Thrashing detection prevented infinite retry on same issue")
+		fmt.Println("  3. Decide patched only the sections the feedback named")
+		fmt.Println("  4. Verify re-checked only the tiers those sections touch")
+		fmt.Println("  5. Loop continued until all gates passed")
+		fmt.Println("  6. Thrashing detection prevented infinite retry on same issue")
+	}
+
+	if resultFrame != nil {
+		if summary := resultFrame.GetAuditSummary(); summary != "No audit trail available" {
+			fmt.Printf("\nAudit Summary:\n%s\n", summary)
+		}
 	}
 
-	if summary := resultFrame.GetAuditSummary(); summary != "No audit trail available" {
-		fmt.Printf("\nAudit Summary:\n%s\n", summary)
+	// The session is finished; drop its checkpoint so the next run starts fresh.
+	if gen.completed {
+		if err := provider.Delete(ctx, gen.sessionID); err != nil {
+			log.Fatalf("Failed to delete checkpoint: %v", err)
+		}
+	}
+}
+
+// runBatchMode generates every request in the batch file concurrently and
+// prints one result line per document, followed by each document's log.
+func runBatchMode(ctx context.Context, client *sdk.Client, library *PolicyLibrary, provider core.StateProvider, outDir, path, prefix string, parallelism int) {
+	reqs, err := readBatchFile(path, prefix)
+	if err != nil {
+		log.Fatalf("Failed to read batch file: %v", err)
+	}
+	fmt.Printf("📦 Batch: %d document(s), up to %d at a time\n\n", len(reqs), parallelism)
+
+	results := RunBatch(ctx, client, library, provider, outDir, reqs, parallelism)
+
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil || !r.Passed {
+			failed++
+		}
+	}
+	for _, r := range results {
+		if r.Log == "" {
+			continue
+		}
+		fmt.Printf("%s\n--- %s: %s\n%s", strings.Repeat("=", 60), r.SessionID, r.Input, r.Log)
+	}
+
+	fmt.Println("\n" + strings.Repeat("=", 60))
+	fmt.Println("BATCH SUMMARY")
+	fmt.Println(strings.Repeat("=", 60))
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			fmt.Printf("❌ %s [%s]: %v\n", r.SessionID, r.DocType, r.Err)
+		case r.Passed:
+			fmt.Printf("✅ %s [%s] %d round(s): %s\n", r.SessionID, r.DocType, r.Rounds, r.Title)
+			if r.Document != "" {
+				fmt.Printf("   -> 📝 %s\n", r.Document)
+			}
+		default:
+			fmt.Printf("⚠️  %s [%s] did not converge in %d round(s)\n", r.SessionID, r.DocType, r.Rounds)
+		}
+	}
+	fmt.Printf("\n%d/%d document(s) passed all policy gates\n", len(results)-failed, len(results))
+	if failed > 0 {
+		os.Exit(1)
 	}
 }
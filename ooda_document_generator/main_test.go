@@ -2,16 +2,39 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
 
+	"github.com/duynguyendang/manglekit/core"
 	"github.com/duynguyendang/manglekit/sdk"
 	"github.com/duynguyendang/manglekit/sdk/ooda"
 )
 
+func testDir() string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Dir(filename)
+}
+
+func testPolicy(t *testing.T, docType string) *DocPolicy {
+	t.Helper()
+	library, err := LoadPolicyLibrary(filepath.Join(testDir(), "policies"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := library.Policy(docType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
 func TestOODALoopConvergesOnCompliantDoc(t *testing.T) {
 	ctx := context.Background()
 	client, err := sdk.NewClient(ctx)
@@ -20,15 +43,20 @@ func TestOODALoopConvergesOnCompliantDoc(t *testing.T) {
 	}
 	t.Cleanup(func() { client.Shutdown(ctx) })
 
-	gen := &DocumentGenerator{maxRounds: 5}
+	library, err := LoadPolicyLibrary(filepath.Join(testDir(), "policies"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := "Create a security policy document for the authentication module."
+	gen := &DocumentGenerator{input: input, maxRounds: 5}
 	observer := &MultiTurnObserver{gen: gen}
-	orienter := &MultiTurnOrienter{client: client}
+	orienter := &MultiTurnOrienter{client: client, gen: gen, library: library}
 	decider := &MultiTurnDecider{gen: gen}
 	verifier := &MultiTurnVerifier{client: client, gen: gen}
 	actor := &MultiTurnActor{gen: gen}
 	loop := ooda.NewLoop(observer, orienter, decider, verifier, actor)
 
-	input := "Create a security policy document for the authentication module."
 	frame := ooda.NewCognitiveFrame(input, "test-session", ooda.TaskTypeGeneration)
 	frame.MaxRetries = 5
 
@@ -65,3 +93,423 @@ func TestNoPythonFStringRemnantsInMain(t *testing.T) {
 		t.Errorf("main.go still contains Python f-string remnant {0:=^60}")
 	}
 }
+
+func TestCheckpointResumesFromLastVerifiedRound(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewFileStateProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen := &DocumentGenerator{maxRounds: 5, sessionID: "session-multi", state: provider}
+	frame := ooda.NewCognitiveFrame("input", gen.sessionID, ooda.TaskTypeGeneration)
+	frame.RawContext = map[string]any{"steering_feedback": "T0: missing security approval", "feedback_tier": "T0"}
+
+	// Round 2 is fully verified ...
+	gen.round = 2
+	gen.currentDraft = Draft{}.With("content", "round 2 draft").With("has_approval", "true")
+	gen.history = []string{"T0: missing security approval"}
+	gen.feedbackLog = []FeedbackEntry{{Round: 1, Rule: "T0: missing security approval", Tier: "T0", Feedback: "T0: missing security approval"}}
+	if err := gen.checkpoint(ctx, phaseVerify, frame); err != nil {
+		t.Fatalf("checkpoint verify: %v", err)
+	}
+	// ... then the process dies after Decide in round 3.
+	gen.round = 3
+	if err := gen.checkpoint(ctx, phaseDecide, frame); err != nil {
+		t.Fatalf("checkpoint decide: %v", err)
+	}
+
+	restarted := &DocumentGenerator{maxRounds: 5, sessionID: "session-multi", state: provider}
+	rawContext, ok, err := restarted.resume(ctx)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if !ok {
+		t.Fatal("expected a checkpoint to resume from")
+	}
+	if restarted.round != 2 {
+		t.Errorf("round: got %d, want 2 (last verified)", restarted.round)
+	}
+	if restarted.currentDraft.Title() != "round 2 draft" || len(restarted.currentDraft.Sections) != 2 {
+		t.Errorf("draft: got %+v", restarted.currentDraft)
+	}
+	if len(restarted.history) != 1 || len(restarted.feedbackLog) != 1 || restarted.feedbackLog[0].Tier != "T0" {
+		t.Errorf("history/feedback not restored: %v / %+v", restarted.history, restarted.feedbackLog)
+	}
+	if rawContext["steering_feedback"] != "T0: missing security approval" {
+		t.Errorf("RawContext not restored: %v", rawContext)
+	}
+	if _, leaked := rawContext[metaPhase]; leaked {
+		t.Error("bookkeeping keys must not leak into RawContext")
+	}
+}
+
+func TestResumeWithoutCheckpoint(t *testing.T) {
+	provider, err := NewFileStateProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := &DocumentGenerator{sessionID: "fresh", state: provider}
+	_, ok, err := gen.resume(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || gen.round != 0 {
+		t.Errorf("expected fresh start, got ok=%v round=%d", ok, gen.round)
+	}
+}
+
+func TestFileStateProviderRejectsNonFileSessionIDs(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewFileStateProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.Set(ctx, "x", "plain"); err != nil {
+		t.Fatalf("Set(x): %v", err)
+	}
+	// a/x and b/x must not both land in x.json
+	for _, id := range []string{"a/x", "b/x", "../x", ".x", ""} {
+		if err := provider.Set(ctx, id, "nested"); err == nil {
+			t.Errorf("Set(%q) succeeded, want an invalid session id error", id)
+		}
+		if _, err := provider.Get(ctx, id); err == nil {
+			t.Errorf("Get(%q) succeeded, want an invalid session id error", id)
+		}
+		if err := provider.Delete(ctx, id); err == nil {
+			t.Errorf("Delete(%q) succeeded, want an invalid session id error", id)
+		}
+	}
+	raw, err := provider.Get(ctx, "x")
+	if err != nil || string(raw.([]byte)) != `"plain"` {
+		t.Errorf("Get(x) = %s, %v; want the plain session's state", raw, err)
+	}
+}
+
+func TestTierRulesTrackSatisfiedRound(t *testing.T) {
+	gen := &DocumentGenerator{policy: testPolicy(t, "security_policy")}
+
+	gen.round = 1
+	gen.recordTiers([]string{"T0: missing security approval", "T1: missing author attribution"})
+	gen.round = 2
+	gen.recordTiers([]string{"T1: missing author attribution"})
+	gen.round = 3
+	gen.recordTiers(nil)
+
+	want := map[string]int{"T0": 2, "T1": 3, "T2": 1, "T3": 1}
+	if len(gen.tiers) != len(want) {
+		t.Fatalf("expected %d tiers from security_policy.dl, got %+v", len(want), gen.tiers)
+	}
+	for _, tier := range gen.tiers {
+		if tier.SatisfiedRound != want[tier.Tier] {
+			t.Errorf("%s (%s): satisfied in round %d, want %d", tier.Tier, tier.Rule, tier.SatisfiedRound, want[tier.Tier])
+		}
+	}
+}
+
+func TestPublishDocumentWritesMarkdownAndCertificate(t *testing.T) {
+	dir := t.TempDir()
+	gen := &DocumentGenerator{sessionID: "session-multi", round: 4, policy: testPolicy(t, "security_policy")}
+	gen.recordTiers(nil)
+	gen.lastAudit = &core.AuditTrail{}
+
+	frame := ooda.NewCognitiveFrame("input", gen.sessionID, ooda.TaskTypeGeneration)
+	frame.Context = append(frame.Context, ooda.Atom{Predicate: "doc_type", Subject: "doc", Object: "security_policy"})
+	frame.Decision = &core.Decision{
+		Outcome: core.DecisionProceed,
+		Action: &core.ActionEnvelope{Name: "publish_doc", Arguments: map[string]interface{}{
+			"content":       "Security Policy for Authentication Module - v1.0",
+			"has_approval":  "true",
+			"has_author":    "Security Team",
+			"has_version":   "v1.0",
+			"has_changelog": "v1.0: Initial release",
+		}},
+	}
+
+	mdPath, certPath, err := gen.publishDocument(dir, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := os.ReadFile(mdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# Security Policy", "| version | v1.0 |", "| changelog | v1.0: Initial release |", "[T0] missing security approval"} {
+		if !strings.Contains(string(md), want) {
+			t.Errorf("markdown missing %q:\n%s", want, md)
+		}
+	}
+
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cert ComplianceCertificate
+	if err := json.Unmarshal(data, &cert); err != nil {
+		t.Fatalf("certificate is not valid JSON: %v", err)
+	}
+	sum := sha256.Sum256(md)
+	if cert.ContentSHA256 != hex.EncodeToString(sum[:]) {
+		t.Error("certificate hash does not match the published markdown")
+	}
+	if cert.Rounds != 4 || cert.DocType != "security_policy" || len(cert.Tiers) != 4 {
+		t.Errorf("unexpected certificate: %+v", cert)
+	}
+}
+
+func TestClassifyDocType(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Create a security policy document for the authentication module.", "security_policy"},
+		{"Write a runbook for the payments database failover.", "runbook"},
+		{"Draft an ADR for moving the order service to event sourcing.", "adr"},
+		{"Record the architecture decision to adopt gRPC.", "adr"},
+		{"Prepare release notes for v1.0.0.", "release_notes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got, ok := classifyDocType(tt.input)
+			if !ok || got.DocType != tt.want {
+				t.Errorf("classifyDocType(%q) = %q, %v; want %q", tt.input, got.DocType, ok, tt.want)
+			}
+		})
+	}
+
+	if _, ok := classifyDocType("Update the shipping address form."); ok {
+		t.Error("expected unrelated request (containing \"address\") to stay unclassified")
+	}
+}
+
+func TestDocumentSubject(t *testing.T) {
+	tests := []struct{ input, want string }{
+		{"Create a security policy document for the authentication module.", "Authentication Module"},
+		{"Write a runbook for the équipe paiements service", "Équipe Paiements Service"},
+		{"Draft an ADR for the ölçüm pipeline.", "Ölçüm Pipeline"},
+		{"Write a document", ""},
+	}
+	for _, tt := range tests {
+		if got := documentSubject(tt.input); got != tt.want {
+			t.Errorf("documentSubject(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyLibraryHasFourTiersPerDocType(t *testing.T) {
+	for _, p := range docProfiles {
+		t.Run(p.DocType, func(t *testing.T) {
+			policy := testPolicy(t, p.DocType)
+			if len(policy.Tiers) != 4 {
+				t.Fatalf("expected T0–T3 rules, got %+v", policy.Tiers)
+			}
+			for i, tier := range policy.Tiers {
+				if want := fmt.Sprintf("T%d", i); tier.Tier != want {
+					t.Errorf("tier %d: got %s, want %s", i, tier.Tier, want)
+				}
+				if _, ok := policy.requirementFor(tier.Tier); !ok {
+					t.Errorf("%s has no requirement", tier.Tier)
+				}
+			}
+		})
+	}
+}
+
+func TestDraftPatchRoundTrip(t *testing.T) {
+	prev := Draft{}.With("content", "Runbook - DRAFT").With("content_length", "short")
+	next := prev.With("content", "Runbook - REVISED").With("has_rollback", "roll back").Without("content_length")
+
+	patch := prev.Diff(next)
+	if got := formatPatch(patch); got != "~content +has_rollback -content_length" {
+		t.Errorf("patch: got %q", got)
+	}
+	applied := prev.Apply(patch)
+	if len(applied.Diff(next)) != 0 {
+		t.Errorf("applying the patch did not reproduce the draft: %+v", applied)
+	}
+	if len(next.Diff(next)) != 0 {
+		t.Error("diff of identical drafts must be empty")
+	}
+}
+
+func TestTouchedTiersFollowChangedSections(t *testing.T) {
+	gen := &DocumentGenerator{policy: testPolicy(t, "security_policy")}
+
+	// No cached verdicts: everything is checked.
+	if got := gen.touchedTiers(nil); len(got) != 4 {
+		t.Fatalf("first round: expected all 4 tiers, got %+v", got)
+	}
+
+	patch := []SectionEdit{
+		{Op: "replace", Section: "content", Before: "a", After: "b"},
+		{Op: "add", Section: "has_author", After: "Security Team"},
+	}
+	got := gen.touchedTiers(patch)
+	if len(got) != 1 || got[0].Tier != "T1" {
+		t.Errorf("expected only T1 (has_author) to be re-checked, got %+v", got)
+	}
+
+	gen.verdicts["T0"] = true
+	gen.verdicts["T3"] = true
+	if got := gen.failingRules(); len(got) != 2 || gen.tierOf(got[1]) != "T3" {
+		t.Errorf("failing rules: got %v", got)
+	}
+}
+
+func TestRetryFramesDoNotShareRawContext(t *testing.T) {
+	gen := &DocumentGenerator{input: "Create a runbook for the payments service.", sessionID: "s"}
+	first := gen.newFrame(map[string]any{"steering_feedback": "T0: missing escalation contact"})
+	first.RawContext["round"] = 1
+
+	next := gen.newFrame(first.RawContext)
+	next.RawContext["steering_feedback"] = "T1: missing rollback"
+	next.RawContext["round"] = 2
+
+	if first.RawContext["steering_feedback"] != "T0: missing escalation contact" || first.RawContext["round"] != 1 {
+		t.Errorf("retry frame mutated the previous frame's RawContext: %v", first.RawContext)
+	}
+	if empty := gen.newFrame(nil); len(empty.RawContext) != 0 {
+		t.Errorf("fresh frame should carry no steering context, got %v", empty.RawContext)
+	}
+}
+
+func TestReadBatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batch.txt")
+	data := "# requests\nCreate a runbook for the payments service.\n\n  Write release notes for version 2.4.  \n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reqs, err := readBatchFile(path, "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BatchRequest{
+		{SessionID: "batch-001", Input: "Create a runbook for the payments service."},
+		{SessionID: "batch-002", Input: "Write release notes for version 2.4."},
+	}
+	if len(reqs) != len(want) {
+		t.Fatalf("got %d requests, want %d: %v", len(reqs), len(want), reqs)
+	}
+	for i := range want {
+		if reqs[i] != want[i] {
+			t.Errorf("request %d = %+v, want %+v", i, reqs[i], want[i])
+		}
+	}
+}
+
+// TestRunBatchConcurrentlyOnOneClient runs several loops against one
+// sdk.Client; run it with -race to check generators share no state.
+func TestRunBatchConcurrentlyOnOneClient(t *testing.T) {
+	ctx := context.Background()
+	client, err := sdk.NewClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Shutdown(ctx) })
+
+	library, err := LoadPolicyLibrary(filepath.Join(testDir(), "policies"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewFileStateProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := []string{
+		"Create a security policy document for the authentication module.",
+		"Create a runbook for the payments service.",
+		"Write an ADR for the event bus.",
+		"Write release notes for version 2.4.",
+		"Create a security policy document for the billing API.",
+		"Create a runbook for the search cluster.",
+	}
+	reqs := make([]BatchRequest, len(inputs))
+	for i, input := range inputs {
+		reqs[i] = BatchRequest{SessionID: fmt.Sprintf("batch-%03d", i+1), Input: input}
+	}
+
+	outDir := t.TempDir()
+	results := RunBatch(ctx, client, library, provider, outDir, reqs, 3)
+	if len(results) != len(reqs) {
+		t.Fatalf("got %d results, want %d", len(results), len(reqs))
+	}
+	for i, r := range results {
+		if r.SessionID != reqs[i].SessionID {
+			t.Errorf("result %d is for %s, want %s", i, r.SessionID, reqs[i].SessionID)
+		}
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.SessionID, r.Err)
+			continue
+		}
+		if !r.Passed {
+			t.Errorf("%s did not pass after %d rounds", r.SessionID, r.Rounds)
+		}
+		if want := filepath.Join(outDir, r.SessionID+".md"); r.Document != want {
+			t.Errorf("%s published %q, want %q", r.SessionID, r.Document, want)
+		}
+		if !strings.Contains(r.Log, "[Observe]") || strings.Contains(r.Log, "=== GENERATION ROUND 6/") {
+			t.Errorf("%s: unexpected log:\n%s", r.SessionID, r.Log)
+		}
+	}
+}
+
+// TestRunBatchItemResumesExhaustedSession re-runs a batch item whose
+// checkpoint is already at maxRounds: no round runs and no frame comes
+// back, which must not crash the batch.
+func TestRunBatchItemResumesExhaustedSession(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewFileStateProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen := &DocumentGenerator{maxRounds: 5, sessionID: "batch-exhausted", state: provider}
+	frame := ooda.NewCognitiveFrame("input", gen.sessionID, ooda.TaskTypeGeneration)
+	gen.round = 5
+	gen.currentDraft = Draft{}.With("content", "round 5 draft")
+	if err := gen.checkpoint(ctx, phaseVerify, frame); err != nil {
+		t.Fatalf("checkpoint verify: %v", err)
+	}
+
+	req := BatchRequest{SessionID: gen.sessionID, Input: "Create a runbook for the payments service."}
+	result := runBatchItem(ctx, nil, nil, &loadedPolicies{}, provider, t.TempDir(), req, BatchResult{SessionID: req.SessionID})
+	if result.Err != nil {
+		t.Fatalf("runBatchItem: %v", result.Err)
+	}
+	if result.Passed || result.Document != "" || result.Rounds != 5 {
+		t.Errorf("got %+v, want an unpassed result at round 5", result)
+	}
+}
+
+func TestRunBatchItemResumesCompletedSession(t *testing.T) {
+	ctx := context.Background()
+	provider, err := NewFileStateProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the session crashed after its Act checkpoint but before Delete
+	gen := &DocumentGenerator{maxRounds: 5, sessionID: "batch-completed", state: provider}
+	frame := ooda.NewCognitiveFrame("input", gen.sessionID, ooda.TaskTypeGeneration)
+	frame.Decision = &core.Decision{Outcome: core.DecisionProceed}
+	frame.ActionResult = "out/batch-completed.md"
+	gen.round = 2
+	gen.currentDraft = Draft{}.With("content", "round 2 draft")
+	if err := gen.checkpoint(ctx, phaseAct, frame); err != nil {
+		t.Fatalf("checkpoint act: %v", err)
+	}
+
+	req := BatchRequest{SessionID: gen.sessionID, Input: "Create a runbook for the payments service."}
+	result := runBatchItem(ctx, nil, nil, &loadedPolicies{}, provider, t.TempDir(), req, BatchResult{SessionID: req.SessionID})
+	if result.Err != nil {
+		t.Fatalf("runBatchItem: %v", result.Err)
+	}
+	if !result.Passed || result.Document != "out/batch-completed.md" || result.Rounds != 2 {
+		t.Errorf("got %+v, want a passed result at round 2", result)
+	}
+	if raw, err := provider.Get(ctx, gen.sessionID); err != nil || raw != nil {
+		t.Errorf("checkpoint after a completed batch item = %v, %v; want it deleted", raw, err)
+	}
+}
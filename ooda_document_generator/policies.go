@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- Document types and per-type policy files ---
+//
+// Each supported doc type has a <doc_type>.dl file in the policy
+// directory with its own T0–T3 halt rules and requirement/4 facts naming
+// the Decide argument that satisfies each tier. The Observer classifies
+// the request text into a doc type; the Orienter loads the matching file.
+
+// DocProfile describes how a document type is recognised and reviewed.
+type DocProfile struct {
+	DocType        string
+	Title          string
+	Reviewer       string
+	Classification string
+	keywords       *regexp.Regexp
+}
+
+// docProfiles is checked in order; the first keyword match wins, so more
+// specific types come before broader ones.
+var docProfiles = []DocProfile{
+	{
+		DocType: "adr", Title: "Architecture Decision Record",
+		Reviewer: "architecture_board", Classification: "internal",
+		keywords: regexp.MustCompile(`(?i)\b(adr|architecture decision|decision record)\b`),
+	},
+	{
+		DocType: "runbook", Title: "Runbook",
+		Reviewer: "sre_team", Classification: "internal",
+		keywords: regexp.MustCompile(`(?i)\b(runbook|playbook|on-call|incident response)\b`),
+	},
+	{
+		DocType: "release_notes", Title: "Release Notes",
+		Reviewer: "product_team", Classification: "public",
+		keywords: regexp.MustCompile(`(?i)\b(release notes?|changelog|what's new)\b`),
+	},
+	{
+		DocType: "security_policy", Title: "Security Policy",
+		Reviewer: "security_team", Classification: "internal",
+		keywords: regexp.MustCompile(`(?i)\bsecurity\b`),
+	},
+}
+
+// classifyDocType maps a free-text request to a document profile.
+func classifyDocType(input string) (DocProfile, bool) {
+	for _, p := range docProfiles {
+		if p.keywords.MatchString(input) {
+			return p, true
+		}
+	}
+	return DocProfile{}, false
+}
+
+var subjectPattern = regexp.MustCompile(`(?i)\bfor (?:the )?(.+?)\.?$`)
+
+// documentSubject extracts "authentication module" from
+// "... document for the authentication module." for use in the title.
+func documentSubject(input string) string {
+	m := subjectPattern.FindStringSubmatch(strings.TrimSpace(input))
+	if m == nil {
+		return ""
+	}
+	words := strings.Fields(m[1])
+	for i, w := range words {
+		r, size := utf8.DecodeRuneInString(w)
+		words[i] = string(unicode.ToUpper(r)) + w[size:]
+	}
+	return strings.Join(words, " ")
+}
+
+var tierHaltPattern = regexp.MustCompile(`halt\("Req",\s*"(T\d): ([^"]*)"\)`)
+
+// tierRules extracts the tiered halt rules ("T0: ..." … "T3: ...") from a
+// policy source, in tier order.
+func tierRules(policy string) []TierStatus {
+	var tiers []TierStatus
+	for _, m := range tierHaltPattern.FindAllStringSubmatch(policy, -1) {
+		tiers = append(tiers, TierStatus{Tier: m[1], Rule: m[1] + ": " + m[2]})
+	}
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].Tier < tiers[j].Tier })
+	return tiers
+}
+
+// Requirement is one requirement(DocType, Tier, Arg, Value) fact.
+type Requirement struct {
+	Tier  string
+	Arg   string
+	Value string
+}
+
+// DocPolicy is a parsed per-type policy file.
+type DocPolicy struct {
+	DocType      string
+	Path         string
+	Source       string
+	Tiers        []TierStatus
+	Requirements []Requirement // in tier order
+}
+
+// requirementFor returns the requirement that satisfies tier, if any.
+func (p *DocPolicy) requirementFor(tier string) (Requirement, bool) {
+	for _, r := range p.Requirements {
+		if r.Tier == tier {
+			return r, true
+		}
+	}
+	return Requirement{}, false
+}
+
+var requirementPattern = regexp.MustCompile(`(?m)^requirement\("([^"]+)",\s*"(T\d)",\s*"([^"]+)",\s*"([^"]*)"\)\.`)
+
+// parseDocPolicy reads the tier rules and requirements out of a policy
+// source and checks that every tier has a requirement to satisfy it.
+func parseDocPolicy(docType, path, source string) (*DocPolicy, error) {
+	p := &DocPolicy{DocType: docType, Path: path, Source: source, Tiers: tierRules(source)}
+	if len(p.Tiers) == 0 {
+		return nil, fmt.Errorf("%s: no tiered halt rules found", path)
+	}
+	for _, m := range requirementPattern.FindAllStringSubmatch(source, -1) {
+		if m[1] != docType {
+			return nil, fmt.Errorf("%s: requirement for %q in %q policy", path, m[1], docType)
+		}
+		p.Requirements = append(p.Requirements, Requirement{Tier: m[2], Arg: m[3], Value: m[4]})
+	}
+	sort.SliceStable(p.Requirements, func(i, j int) bool { return p.Requirements[i].Tier < p.Requirements[j].Tier })
+	for _, t := range p.Tiers {
+		if _, ok := p.requirementFor(t.Tier); !ok {
+			return nil, fmt.Errorf("%s: %s rule %q has no requirement fact", path, t.Tier, t.Rule)
+		}
+	}
+	return p, nil
+}
+
+// PolicyLibrary holds the per-type policies loaded from a directory.
+type PolicyLibrary struct {
+	dir      string
+	policies map[string]*DocPolicy
+}
+
+// LoadPolicyLibrary reads every <doc_type>.dl file in dir.
+func LoadPolicyLibrary(dir string) (*PolicyLibrary, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.dl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no policy files in %s", dir)
+	}
+
+	lib := &PolicyLibrary{dir: dir, policies: make(map[string]*DocPolicy)}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		docType := strings.TrimSuffix(filepath.Base(path), ".dl")
+		p, err := parseDocPolicy(docType, path, string(data))
+		if err != nil {
+			return nil, err
+		}
+		lib.policies[docType] = p
+	}
+	return lib, nil
+}
+
+// Policy returns the policy for a doc type.
+func (l *PolicyLibrary) Policy(docType string) (*DocPolicy, error) {
+	p, ok := l.policies[docType]
+	if !ok {
+		return nil, fmt.Errorf("no policy for doc type %q in %s", docType, l.dir)
+	}
+	return p, nil
+}
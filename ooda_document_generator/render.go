@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/duynguyendang/manglekit/core"
+	"github.com/duynguyendang/manglekit/sdk/ooda"
+)
+
+// --- Publishing: Markdown document + JSON compliance certificate ---
+//
+// When the Act phase runs on a verified decision, the final document is
+// written as <session>.md next to a <session>.cert.json sidecar. The
+// sidecar records how many rounds the loop took, which round satisfied
+// each tier's rule, and the final AuditTrail, so downstream tooling can
+// check that the published document went through the policy gates.
+
+// TierStatus tracks when a tiered halt rule stopped firing.
+type TierStatus struct {
+	Tier           string `json:"tier"`
+	Rule           string `json:"rule"`
+	SatisfiedRound int    `json:"satisfied_round"` // 0 while the rule still fires
+}
+
+// CertifiedRule is a stable JSON projection of one AuditTrail entry.
+type CertifiedRule struct {
+	RuleName  string            `json:"rule_name"`
+	Tier      string            `json:"tier"`
+	Predicate string            `json:"predicate"`
+	Bindings  map[string]string `json:"bindings,omitempty"`
+}
+
+// ComplianceCertificate is the JSON sidecar published with a document.
+type ComplianceCertificate struct {
+	SessionID     string          `json:"session_id"`
+	DocType       string          `json:"doc_type"`
+	Document      string          `json:"document"`
+	ContentSHA256 string          `json:"content_sha256"`
+	Rounds        int             `json:"rounds"`
+	Outcome       string          `json:"outcome"`
+	Tiers         []TierStatus    `json:"tiers"`
+	Feedback      []FeedbackEntry `json:"feedback"`
+	AuditTrail    []CertifiedRule `json:"audit_trail"`
+	IssuedAt      time.Time       `json:"issued_at"`
+}
+
+// recordTiers updates each tier's satisfied round from the halt reasons of
+// the current round's assessment. A rule that fires again after being
+// satisfied is reset.
+func (g *DocumentGenerator) recordTiers(reasons []string) {
+	if g.tiers == nil && g.policy != nil {
+		g.tiers = append([]TierStatus(nil), g.policy.Tiers...)
+	}
+	for i := range g.tiers {
+		fired := false
+		for _, r := range reasons {
+			if strings.Contains(r, g.tiers[i].Rule) {
+				fired = true
+				break
+			}
+		}
+		switch {
+		case fired:
+			g.tiers[i].SatisfiedRound = 0
+		case g.tiers[i].SatisfiedRound == 0:
+			g.tiers[i].SatisfiedRound = g.round
+		}
+	}
+}
+
+// certifiedRules converts an AuditTrail into its certificate form.
+func certifiedRules(trail *core.AuditTrail) []CertifiedRule {
+	if trail == nil {
+		return nil
+	}
+	rules := make([]CertifiedRule, 0, len(trail.MatchedRules))
+	for _, r := range trail.MatchedRules {
+		rules = append(rules, CertifiedRule{
+			RuleName:  r.RuleName,
+			Tier:      string(r.Tier),
+			Predicate: r.Predicate,
+			Bindings:  r.Bindings,
+		})
+	}
+	return rules
+}
+
+// frameDocType returns the doc_type atom observed for the frame.
+func frameDocType(frame *ooda.CognitiveFrame) string {
+	for _, atom := range frame.Context {
+		if atom.Predicate == "doc_type" {
+			return atom.Object
+		}
+	}
+	return "unknown"
+}
+
+// renderMarkdown renders the published document from the decision
+// arguments, with one metadata row per policy requirement.
+func renderMarkdown(args map[string]interface{}, reqs []Requirement, cert *ComplianceCertificate) string {
+	content, _ := args["content"].(string)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", content)
+
+	b.WriteString("| Field | Value |\n|---|---|\n")
+	fmt.Fprintf(&b, "| doc_type | %s |\n", cert.DocType)
+	for _, req := range reqs {
+		if v, ok := args[req.Arg].(string); ok && v != "" {
+			fmt.Fprintf(&b, "| %s | %s |\n", strings.TrimPrefix(req.Arg, "has_"), v)
+		}
+	}
+	b.WriteString("\n")
+
+	b.WriteString("## Content\n\n")
+	b.WriteString(content + "\n\n")
+
+	b.WriteString("## Compliance\n\n")
+	fmt.Fprintf(&b, "Published after %d round(s) under policy. See `%s.cert.json`.\n\n", cert.Rounds, cert.SessionID)
+	for _, t := range cert.Tiers {
+		fmt.Fprintf(&b, "- [%s] %s — satisfied in round %d\n", t.Tier, strings.TrimPrefix(t.Rule, t.Tier+": "), t.SatisfiedRound)
+	}
+	return b.String()
+}
+
+// publishDocument writes the Markdown document and its certificate sidecar
+// into dir and returns both paths.
+func (g *DocumentGenerator) publishDocument(dir string, frame *ooda.CognitiveFrame) (mdPath, certPath string, err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", fmt.Errorf("failed to create output dir: %w", err)
+	}
+
+	cert := &ComplianceCertificate{
+		SessionID:  g.sessionID,
+		DocType:    frameDocType(frame),
+		Document:   g.sessionID + ".md",
+		Rounds:     g.round,
+		Outcome:    string(frame.Decision.Outcome),
+		Tiers:      append([]TierStatus(nil), g.tiers...),
+		Feedback:   append([]FeedbackEntry(nil), g.feedbackLog...),
+		AuditTrail: certifiedRules(g.lastAudit),
+		IssuedAt:   time.Now().UTC(),
+	}
+
+	var reqs []Requirement
+	if g.policy != nil {
+		reqs = g.policy.Requirements
+	}
+	md := renderMarkdown(frame.Decision.Action.Arguments, reqs, cert)
+	sum := sha256.Sum256([]byte(md))
+	cert.ContentSHA256 = hex.EncodeToString(sum[:])
+
+	certJSON, err := json.MarshalIndent(cert, "", "  ")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode certificate: %w", err)
+	}
+
+	mdPath = filepath.Join(dir, cert.Document)
+	certPath = filepath.Join(dir, g.sessionID+".cert.json")
+	if err := os.WriteFile(mdPath, []byte(md), 0o644); err != nil {
+		return "", "", fmt.Errorf("failed to write document: %w", err)
+	}
+	if err := os.WriteFile(certPath, append(certJSON, '\n'), 0o644); err != nil {
+		return "", "", fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return mdPath, certPath, nil
+}
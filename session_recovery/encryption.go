@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/duynguyendang/manglekit/core"
+)
+
+// --- Encryption at rest for session state ---
+//
+// EncryptedStateProvider wraps another provider and seals every session
+// with AES-GCM before it reaches it. The wrapped provider only ever sees a
+// carrier SessionState: the session ID plus an envelope whose payload holds
+// the key ID, nonce and ciphertext. Facts, history and the real payload
+// live only inside the ciphertext. The session ID and key ID are bound in
+// as additional data, so a sealed state copied to another session, or
+// relabelled with another key, fails to open.
+//
+// Keys come from a Keyring. Rotating it changes the key new writes are
+// sealed with; sessions sealed under an older key still open, and are
+// re-sealed under the current key the next time they are written.
+
+// ErrStateTampered is returned when a stored session fails authentication
+// or is not sealed at all. Reads fail closed: nothing from such a session
+// is returned.
+var ErrStateTampered = errors.New("sealed session state failed authentication")
+
+// ErrUnknownKey is returned when a session is sealed under a key the
+// keyring does not hold.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// Keyring supplies the AES keys sessions are sealed with.
+type Keyring interface {
+	// Current returns the key new writes are sealed with.
+	Current() (keyID string, key []byte, err error)
+	// Key returns the key with the given ID, for opening older sessions.
+	Key(keyID string) ([]byte, error)
+}
+
+// StaticKeyring is an in-memory Keyring. Production deployments would
+// back Keyring with a KMS or secret store instead.
+type StaticKeyring struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+// NewStaticKeyring returns a keyring whose current key is keyID.
+func NewStaticKeyring(keyID string, key []byte) (*StaticKeyring, error) {
+	k := &StaticKeyring{keys: make(map[string][]byte)}
+	if err := k.Rotate(keyID, key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParseKeyring builds a keyring from "id=base64key,..." entries. The first
+// entry is the current key; the rest only open older sessions.
+func ParseKeyring(spec string) (*StaticKeyring, error) {
+	k := &StaticKeyring{keys: make(map[string][]byte)}
+	for i, entry := range strings.Split(spec, ",") {
+		keyID, encoded, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || keyID == "" {
+			return nil, fmt.Errorf("keyring entry %d: want id=base64key", i+1)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("keyring entry %q: %w", keyID, err)
+		}
+		if err := k.Add(keyID, key); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.current = keyID
+		}
+	}
+	return k, nil
+}
+
+// Add makes a key available for opening sessions without sealing with it.
+func (k *StaticKeyring) Add(keyID string, key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("key %q: AES keys must be 16, 24 or 32 bytes, got %d", keyID, len(key))
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[keyID] = append([]byte(nil), key...)
+	return nil
+}
+
+// Rotate adds a key and makes it the one new writes are sealed with.
+func (k *StaticKeyring) Rotate(keyID string, key []byte) error {
+	if err := k.Add(keyID, key); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.current = keyID
+	return nil
+}
+
+func (k *StaticKeyring) Current() (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.current == "" {
+		return "", nil, fmt.Errorf("%w: keyring has no current key", ErrUnknownKey)
+	}
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *StaticKeyring) Key(keyID string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	return key, nil
+}
+
+// versionedStore is a SessionStore with versioned reads and writes, which
+// is what EncryptedStateProvider wraps; every provider here qualifies.
+type versionedStore interface {
+	SessionStore
+	VersionedStateProvider
+}
+
+// EncryptedStateProvider seals session state before handing it to the
+// wrapped provider. Versions, listing and TTLs pass straight through,
+// since they never look inside the state.
+type EncryptedStateProvider struct {
+	inner versionedStore
+	keys  Keyring
+}
+
+func NewEncryptedStateProvider(inner versionedStore, keys Keyring) *EncryptedStateProvider {
+	return &EncryptedStateProvider{inner: inner, keys: keys}
+}
+
+// sealedPayload is the carrier envelope's payload.
+type sealedPayload struct {
+	KeyID      string `json:"key_id"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// additionalData binds a sealed state to its session and key.
+func additionalData(sessionID, keyID string) []byte {
+	return []byte(sessionID + "\x00" + keyID)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts state under the current key and wraps it in a carrier.
+func (p *EncryptedStateProvider) seal(sessionID string, state any) (*core.SessionState, error) {
+	plaintext, err := encodeState(state)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, key, err := p.keys.Current()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	ciphertext := gcm.Seal(nil, nonce, plaintext, additionalData(sessionID, keyID))
+
+	return &core.SessionState{
+		SessionID: sessionID,
+		ActiveEnvelope: core.Envelope{
+			ContentType: core.TypeJSON,
+			Payload: sealedPayload{
+				KeyID:      keyID,
+				Nonce:      base64.StdEncoding.EncodeToString(nonce),
+				Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+			},
+		},
+	}, nil
+}
+
+// open authenticates and decrypts a carrier read from the wrapped
+// provider, returning the session's JSON and the key it was sealed under.
+func (p *EncryptedStateProvider) open(sessionID string, raw any) ([]byte, string, error) {
+	carrier, err := decodeState(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: session %s: %v", ErrStateTampered, sessionID, err)
+	}
+	data, err := json.Marshal(carrier.ActiveEnvelope.Payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: session %s: %v", ErrStateTampered, sessionID, err)
+	}
+	var sealed sealedPayload
+	if err := json.Unmarshal(data, &sealed); err != nil || sealed.KeyID == "" {
+		return nil, "", fmt.Errorf("%w: session %s is not sealed", ErrStateTampered, sessionID)
+	}
+	if carrier.SessionID != sessionID || len(carrier.LogicalFacts) > 0 || len(carrier.ExecutionCtx.CurrentHistory) > 0 {
+		return nil, "", fmt.Errorf("%w: session %s carries unsealed fields", ErrStateTampered, sessionID)
+	}
+
+	key, err := p.keys.Key(sealed.KeyID)
+	if err != nil {
+		return nil, "", fmt.Errorf("session %s: %w", sessionID, err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil || len(nonce) != gcm.NonceSize() {
+		return nil, "", fmt.Errorf("%w: session %s has a malformed nonce", ErrStateTampered, sessionID)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: session %s has malformed ciphertext", ErrStateTampered, sessionID)
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, additionalData(sessionID, sealed.KeyID))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: session %s", ErrStateTampered, sessionID)
+	}
+	current, err := upgradeState(plaintext)
+	if err != nil {
+		return nil, "", fmt.Errorf("session %s: %w", sessionID, err)
+	}
+	return current, sealed.KeyID, nil
+}
+
+// KeyID reports which key a stored session is sealed under, or "" if the
+// session does not exist.
+func (p *EncryptedStateProvider) KeyID(ctx context.Context, sessionID string) (string, error) {
+	raw, err := p.inner.Get(ctx, sessionID)
+	if err != nil || raw == nil {
+		return "", err
+	}
+	_, keyID, err := p.open(sessionID, raw)
+	return keyID, err
+}
+
+func (p *EncryptedStateProvider) Get(ctx context.Context, sessionID string) (any, error) {
+	state, _, err := p.GetVersioned(ctx, sessionID)
+	return state, err
+}
+
+func (p *EncryptedStateProvider) GetVersioned(ctx context.Context, sessionID string) (any, uint64, error) {
+	raw, version, err := p.inner.GetVersioned(ctx, sessionID)
+	if err != nil || raw == nil {
+		return nil, 0, err
+	}
+	plaintext, _, err := p.open(sessionID, raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	return plaintext, version, nil
+}
+
+func (p *EncryptedStateProvider) Set(ctx context.Context, sessionID string, state any) error {
+	carrier, err := p.seal(sessionID, state)
+	if err != nil {
+		return err
+	}
+	return p.inner.Set(ctx, sessionID, carrier)
+}
+
+func (p *EncryptedStateProvider) CompareAndSet(ctx context.Context, sessionID string, state any, expected uint64) (uint64, error) {
+	carrier, err := p.seal(sessionID, state)
+	if err != nil {
+		return 0, err
+	}
+	return p.inner.CompareAndSet(ctx, sessionID, carrier, expected)
+}
+
+func (p *EncryptedStateProvider) Delete(ctx context.Context, sessionID string) error {
+	return p.inner.Delete(ctx, sessionID)
+}
+
+func (p *EncryptedStateProvider) Close(ctx context.Context) error {
+	return p.inner.Close(ctx)
+}
+
+func (p *EncryptedStateProvider) List(ctx context.Context, filter SessionFilter) ([]SessionInfo, error) {
+	return p.inner.List(ctx, filter)
+}
+
+func (p *EncryptedStateProvider) SetTTL(ctx context.Context, sessionID string, ttl time.Duration) error {
+	return p.inner.SetTTL(ctx, sessionID, ttl)
+}
+
+func (p *EncryptedStateProvider) EvictIfExpired(ctx context.Context, sessionID string, now time.Time) (bool, error) {
+	return p.inner.EvictIfExpired(ctx, sessionID, now)
+}
+
+var (
+	_ SessionStore           = (*EncryptedStateProvider)(nil)
+	_ VersionedStateProvider = (*EncryptedStateProvider)(nil)
+)
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/duynguyendang/manglekit/core"
+)
+
+// --- Fault injection for crash-consistency testing ---
+//
+// FaultyStateProvider wraps any core.StateProvider and injects one fault
+// at a chosen write. CrashHarness runs a workflow against it as a series
+// of processes: whenever a checkpoint fails or the process is killed, the
+// next process hydrates the session from the provider and carries on,
+// exactly as a restarted service would. After the workflow completes, the
+// harness checks that the journal records every step exactly once, in
+// order, whatever the fault.
+
+// Fault is a failure FaultyStateProvider or CrashHarness can inject.
+type Fault int
+
+const (
+	// FaultSetError fails the write without storing anything.
+	FaultSetError Fault = iota + 1
+	// FaultLostAck stores the write, then reports it as failed.
+	FaultLostAck
+	// FaultPartialWrite stores only the new LogicalFacts on top of the
+	// previously stored state, as a store that writes fields separately
+	// would if the process died mid-write, then fails.
+	FaultPartialWrite
+	// FaultDuplicateDelivery delivers the write twice, then delivers it a
+	// third time, stale, just before the following write.
+	FaultDuplicateDelivery
+	// FaultCrashAfterAction kills the process after a step's action ran
+	// but before its completion was checkpointed. Its position counts
+	// steps rather than writes.
+	FaultCrashAfterAction
+)
+
+func (f Fault) String() string {
+	switch f {
+	case FaultSetError:
+		return "set_error"
+	case FaultLostAck:
+		return "lost_ack"
+	case FaultPartialWrite:
+		return "partial_write"
+	case FaultDuplicateDelivery:
+		return "duplicate_delivery"
+	case FaultCrashAfterAction:
+		return "crash_after_action"
+	}
+	return fmt.Sprintf("Fault(%d)", int(f))
+}
+
+// ErrInjected is the error every injected write failure wraps.
+var ErrInjected = errors.New("injected fault")
+
+// Injection places one fault: at the At-th write (1-based, counted across
+// every process of a run), or at the At-th step for FaultCrashAfterAction.
+type Injection struct {
+	Fault Fault
+	At    int
+}
+
+func (i Injection) String() string {
+	return fmt.Sprintf("%s@%d", i.Fault, i.At)
+}
+
+// AllInjections lists every injection point of a workflow with the given
+// number of steps: each write fault at each of its writes (an intent and a
+// completion per step), and a kill after each step's action.
+func AllInjections(steps int) []Injection {
+	var injections []Injection
+	for _, fault := range []Fault{FaultSetError, FaultLostAck, FaultPartialWrite, FaultDuplicateDelivery} {
+		for at := 1; at <= 2*steps; at++ {
+			injections = append(injections, Injection{Fault: fault, At: at})
+		}
+	}
+	for at := 1; at <= steps; at++ {
+		injections = append(injections, Injection{Fault: FaultCrashAfterAction, At: at})
+	}
+	return injections
+}
+
+// FaultyStateProvider injects a write fault into the provider it wraps.
+// Versioned reads and compare-and-set writes pass through when the wrapped
+// provider supports them, so SessionManager keeps its conflict checks.
+type FaultyStateProvider struct {
+	inner     core.StateProvider
+	injection Injection
+
+	mu      sync.Mutex
+	writes  int
+	actions int
+	fired   bool
+	replay  func() // stale duplicate to deliver before the next write
+	history []string
+}
+
+func NewFaultyStateProvider(inner core.StateProvider, injection Injection) *FaultyStateProvider {
+	return &FaultyStateProvider{inner: inner, injection: injection}
+}
+
+// Fired reports whether the fault has been injected yet.
+func (p *FaultyStateProvider) Fired() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fired
+}
+
+// Log lists the injected events, for diagnosing a failed run.
+func (p *FaultyStateProvider) Log() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.history...)
+}
+
+func (p *FaultyStateProvider) Get(ctx context.Context, sessionID string) (any, error) {
+	return p.inner.Get(ctx, sessionID)
+}
+
+func (p *FaultyStateProvider) GetVersioned(ctx context.Context, sessionID string) (any, uint64, error) {
+	if versioned, ok := p.inner.(VersionedStateProvider); ok {
+		return versioned.GetVersioned(ctx, sessionID)
+	}
+	raw, err := p.inner.Get(ctx, sessionID)
+	return raw, 0, err
+}
+
+func (p *FaultyStateProvider) Set(ctx context.Context, sessionID string, state any) error {
+	_, err := p.write(ctx, sessionID, state, func(state any) (uint64, error) {
+		return 0, p.inner.Set(ctx, sessionID, state)
+	})
+	return err
+}
+
+// CompareAndSet falls back to a plain Set, reporting version 0, when the
+// wrapped provider is not versioned.
+func (p *FaultyStateProvider) CompareAndSet(ctx context.Context, sessionID string, state any, expected uint64) (uint64, error) {
+	return p.write(ctx, sessionID, state, func(state any) (uint64, error) {
+		if versioned, ok := p.inner.(VersionedStateProvider); ok {
+			return versioned.CompareAndSet(ctx, sessionID, state, expected)
+		}
+		return 0, p.inner.Set(ctx, sessionID, state)
+	})
+}
+
+func (p *FaultyStateProvider) Delete(ctx context.Context, sessionID string) error {
+	return p.inner.Delete(ctx, sessionID)
+}
+
+func (p *FaultyStateProvider) Close(ctx context.Context) error {
+	return p.inner.Close(ctx)
+}
+
+// write delivers one Set or CompareAndSet through deliver, injecting the
+// fault if this is the chosen write.
+func (p *FaultyStateProvider) write(ctx context.Context, sessionID string, state any, deliver func(any) (uint64, error)) (uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.replay != nil {
+		p.replay()
+		p.replay = nil
+	}
+	p.writes++
+	if p.fired || p.injection.Fault == FaultCrashAfterAction || p.writes != p.injection.At {
+		return deliver(state)
+	}
+	p.fired = true
+	p.history = append(p.history, fmt.Sprintf("write %d: %s", p.writes, p.injection.Fault))
+
+	// Freeze the state now; the caller keeps mutating its copy
+	next, err := parseState(state)
+	if err != nil {
+		return 0, err
+	}
+	data, err := json.Marshal(next)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal state: %w", err)
+	}
+	frozen := func() any { return data }
+	failed := fmt.Errorf("%w: %s at write %d", ErrInjected, p.injection.Fault, p.writes)
+
+	switch p.injection.Fault {
+	case FaultSetError:
+		return 0, failed
+
+	case FaultLostAck:
+		if _, err := deliver(frozen()); err != nil {
+			return 0, err
+		}
+		return 0, failed
+
+	case FaultPartialWrite:
+		partial, err := p.partial(ctx, sessionID, next)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := deliver(partial); err != nil {
+			return 0, err
+		}
+		return 0, failed
+
+	case FaultDuplicateDelivery:
+		version, err := deliver(frozen())
+		if err != nil {
+			return 0, err
+		}
+		// Duplicates are rejected or absorbed by the store; the sender
+		// never learns about them
+		_, dupErr := deliver(frozen())
+		p.history = append(p.history, fmt.Sprintf("duplicate: %v", dupErr))
+		p.replay = func() {
+			_, err := deliver(frozen())
+			p.history = append(p.history, fmt.Sprintf("stale redelivery: %v", err))
+		}
+		return version, nil
+	}
+	return deliver(state)
+}
+
+// partial builds the state a torn write leaves behind: the stored state
+// with only the new LogicalFacts applied.
+func (p *FaultyStateProvider) partial(ctx context.Context, sessionID string, next *core.SessionState) (*core.SessionState, error) {
+	raw, err := p.inner.Get(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return &core.SessionState{SessionID: sessionID, LogicalFacts: next.LogicalFacts}, nil
+	}
+	stored, err := decodeState(raw)
+	if err != nil {
+		return nil, err
+	}
+	stored.LogicalFacts = next.LogicalFacts
+	return stored, nil
+}
+
+// actionRan counts a completed step action and reports whether the
+// process should be killed now.
+func (p *FaultyStateProvider) actionRan(step WorkflowStep) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.actions++
+	if p.fired || p.injection.Fault != FaultCrashAfterAction || p.actions != p.injection.At {
+		return false
+	}
+	p.fired = true
+	p.history = append(p.history, fmt.Sprintf("killed after the action of %s", step.ID()))
+	return true
+}
+
+var _ VersionedStateProvider = (*FaultyStateProvider)(nil)
+
+// errCrash is the panic value of a simulated process kill.
+var errCrash = errors.New("process killed")
+
+// CrashHarness runs a workflow to completion through injected faults,
+// restarting it as a new process after every failure.
+type CrashHarness struct {
+	Steps []WorkflowStep
+	// NewState returns the state a fresh run starts from.
+	NewState func(sessionID string) *core.SessionState
+	// Run drives the workflow in one process; it defaults to reconciling
+	// the journal and running every step that is not done, in order.
+	Run func(ctx context.Context, runner *StepRunner, state *core.SessionState) error
+	// MaxRestarts bounds the processes a single run may take.
+	MaxRestarts int
+}
+
+// RunResult describes one run under an injection.
+type RunResult struct {
+	Injection Injection
+	Final     *core.SessionState
+	Restarts  int
+	Fired     bool // whether the injection point was reached
+	Log       []string
+}
+
+// RunWith runs the workflow for sessionID against provider with one fault
+// injected, and checks the journal invariant on the final state.
+func (h *CrashHarness) RunWith(ctx context.Context, provider core.StateProvider, sessionID string, injection Injection) (*RunResult, error) {
+	faulty := NewFaultyStateProvider(provider, injection)
+	result := &RunResult{Injection: injection}
+	maxRestarts := h.MaxRestarts
+	if maxRestarts == 0 {
+		maxRestarts = 3
+	}
+
+	for process := 0; ; process++ {
+		if process > maxRestarts {
+			return result, fmt.Errorf("%s: workflow did not complete after %d restarts", injection, maxRestarts)
+		}
+		result.Restarts = process
+
+		done, err := h.runProcess(ctx, faulty, sessionID)
+		if err != nil && !errors.Is(err, ErrInjected) && !errors.Is(err, errCrash) {
+			return result, fmt.Errorf("%s: %w", injection, err)
+		}
+		if done {
+			break
+		}
+	}
+	result.Fired = faulty.Fired()
+	result.Log = faulty.Log()
+
+	final, err := NewSessionManager(provider).Hydrate(ctx, sessionID)
+	if err != nil {
+		return result, fmt.Errorf("%s: final hydrate: %w", injection, err)
+	}
+	result.Final = final
+	if err := CheckJournal(final, h.Steps); err != nil {
+		return result, fmt.Errorf("%s: %w (log %v)", injection, err, result.Log)
+	}
+	return result, nil
+}
+
+// runProcess is one process lifetime: hydrate or start the session, then
+// run the workflow until it completes, fails or is killed.
+func (h *CrashHarness) runProcess(ctx context.Context, provider *FaultyStateProvider, sessionID string) (done bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if r != errCrash {
+				panic(r)
+			}
+			done, err = false, errCrash
+		}
+	}()
+
+	sm := NewSessionManager(provider)
+	state, err := sm.Hydrate(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+	if state == nil {
+		state = h.NewState(sessionID)
+	}
+
+	runner := NewStepRunner(sm)
+	runner.AfterAction = func(i int, step WorkflowStep) {
+		if provider.actionRan(step) {
+			panic(errCrash)
+		}
+	}
+
+	run := h.Run
+	if run == nil {
+		run = func(ctx context.Context, runner *StepRunner, state *core.SessionState) error {
+			return runJournal(ctx, runner, state, h.Steps)
+		}
+	}
+	if err := run(ctx, runner, state); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// runJournal reconciles interrupted steps, then runs every step that is
+// not done, in order.
+func runJournal(ctx context.Context, runner *StepRunner, state *core.SessionState, steps []WorkflowStep) error {
+	if err := runner.Reconcile(ctx, state, steps); err != nil {
+		return err
+	}
+	for i, step := range steps {
+		if err := runner.RunStep(ctx, state, i, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckJournal asserts the crash-consistency invariant: the session's
+// completed steps are exactly the workflow's steps, in order, each once,
+// and no completion lacks its intent.
+func CheckJournal(state *core.SessionState, steps []WorkflowStep) error {
+	if state == nil {
+		return errors.New("session was lost")
+	}
+	var completed, intents []string
+	for _, f := range state.LogicalFacts {
+		switch {
+		case strings.HasPrefix(f, "step("):
+			completed = append(completed, f)
+		case strings.HasPrefix(f, "intent("):
+			intents = append(intents, f)
+		}
+	}
+	want := make([]string, len(steps))
+	wantIntents := make([]string, len(steps))
+	for i, step := range steps {
+		want[i] = step.Fact
+		wantIntents[i] = step.intentFact()
+	}
+	if strings.Join(completed, " ") != strings.Join(want, " ") {
+		return fmt.Errorf("completed steps %v, want %v", completed, want)
+	}
+	if strings.Join(intents, " ") != strings.Join(wantIntents, " ") {
+		return fmt.Errorf("journaled intents %v, want %v", intents, wantIntents)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStateProvider implements VersionedStateProvider with one JSON file per
+// session in a directory, so checkpoints survive a process exit.
+//
+// Every Set writes a temp file in the same directory, fsyncs it, renames it
+// over <session>.json and fsyncs the directory, so a crash leaves either the
+// old or the new checkpoint, never a torn one. Writers in different
+// processes are serialised by an exclusive lock on the directory's .lock
+// file; writers in this process additionally share a mutex.
+type FileStateProvider struct {
+	dir   string
+	clock Clock
+	mu    sync.Mutex
+}
+
+func NewFileStateProvider(dir string) (*FileStateProvider, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %w", err)
+	}
+	return &FileStateProvider{dir: dir, clock: SystemClock}, nil
+}
+
+// SetClock replaces the clock used for session timestamps.
+func (p *FileStateProvider) SetClock(c Clock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clock = c
+}
+
+// checkSessionID rejects session IDs that are not a plain file name, so a
+// session can never escape the state directory.
+func checkSessionID(sessionID string) error {
+	if sessionID == "" || sessionID != filepath.Base(sessionID) || strings.HasPrefix(sessionID, ".") {
+		return fmt.Errorf("invalid session id %q", sessionID)
+	}
+	return nil
+}
+
+// path maps a session ID to its checkpoint file.
+func (p *FileStateProvider) path(sessionID string) (string, error) {
+	if err := checkSessionID(sessionID); err != nil {
+		return "", err
+	}
+	return filepath.Join(p.dir, sessionID+".json"), nil
+}
+
+// fileRecord is the on-disk form of a checkpoint.
+type fileRecord struct {
+	Version uint64          `json:"version"`
+	Created time.Time       `json:"created"`
+	Updated time.Time       `json:"updated"`
+	TTL     time.Duration   `json:"ttl,omitempty"`
+	State   json.RawMessage `json:"state"`
+}
+
+func (r *fileRecord) info(sessionID string) SessionInfo {
+	return SessionInfo{SessionID: sessionID, Created: r.Created, Updated: r.Updated, TTL: r.TTL, Version: r.Version}
+}
+
+func (p *FileStateProvider) Get(ctx context.Context, sessionID string) (any, error) {
+	state, _, err := p.GetVersioned(ctx, sessionID)
+	return state, err
+}
+
+func (p *FileStateProvider) GetVersioned(ctx context.Context, sessionID string) (any, uint64, error) {
+	path, err := p.path(sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Checkpoints are replaced by rename, so a plain read always sees a
+	// complete file and needs no lock.
+	rec, err := readFileRecord(path)
+	if err != nil || rec == nil {
+		return nil, 0, err
+	}
+	data, err := upgradeState(rec.State)
+	if err != nil {
+		return nil, 0, fmt.Errorf("session %s: %w", sessionID, err)
+	}
+	return data, rec.Version, nil
+}
+
+func (p *FileStateProvider) Set(ctx context.Context, sessionID string, state any) error {
+	_, err := p.write(sessionID, state, nil)
+	return err
+}
+
+func (p *FileStateProvider) CompareAndSet(ctx context.Context, sessionID string, state any, expected uint64) (uint64, error) {
+	return p.write(sessionID, state, &expected)
+}
+
+// write stores state as the next version of the session. When expected is
+// set, the write only happens if the stored version still matches it; the
+// check and the rename both happen under the cross-process lock.
+func (p *FileStateProvider) write(sessionID string, state any, expected *uint64) (uint64, error) {
+	path, err := p.path(sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := encodeState(state)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock, err := p.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	current, err := readFileRecord(path)
+	if err != nil {
+		return 0, err
+	}
+	now := p.clock.Now()
+	rec := fileRecord{Version: 1, Created: now, Updated: now, State: data}
+	if current != nil {
+		rec.Version = current.Version + 1
+		rec.Created = current.Created
+		rec.TTL = current.TTL
+	}
+	if expected != nil && *expected != rec.Version-1 {
+		return 0, &VersionConflictError{SessionID: sessionID, Expected: *expected, Actual: rec.Version - 1}
+	}
+	if err := p.commit(sessionID, path, &rec); err != nil {
+		return 0, err
+	}
+	return rec.Version, nil
+}
+
+// commit atomically replaces the session's file with rec; the lock must
+// be held.
+func (p *FileStateProvider) commit(sessionID, path string, rec *fileRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(p.dir, sessionID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to sync state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to commit state: %w", err)
+	}
+	return syncDir(p.dir)
+}
+
+// readFileRecord reads a checkpoint file, or returns nil if there is none.
+//
+// A file without a state key predates the record: it is the bare schema v1
+// SessionState the first FileStateProvider wrote. It is read as version 1
+// of the session, timestamped with the file's modification time, and its
+// state is migrated like any other v1 document.
+func readFileRecord(path string) (*fileRecord, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to decode state file: %w", err)
+	}
+	if _, ok := probe["state"]; !ok {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat state file: %w", err)
+		}
+		modified := fi.ModTime().UTC()
+		return &fileRecord{Version: 1, Created: modified, Updated: modified, State: data}, nil
+	}
+	var rec fileRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("failed to decode state file: %w", err)
+	}
+	return &rec, nil
+}
+
+func (p *FileStateProvider) Delete(ctx context.Context, sessionID string) error {
+	path, err := p.path(sessionID)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete state: %w", err)
+	}
+	return syncDir(p.dir)
+}
+
+func (p *FileStateProvider) List(ctx context.Context, filter SessionFilter) ([]SessionInfo, error) {
+	paths, err := filepath.Glob(filepath.Join(p.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var infos []SessionInfo
+	for _, path := range paths {
+		sessionID := strings.TrimSuffix(filepath.Base(path), ".json")
+		rec, err := readFileRecord(path)
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			continue // deleted since the glob
+		}
+		if info := rec.info(sessionID); filter.Match(info) {
+			infos = append(infos, info)
+		}
+	}
+	sortSessions(infos)
+	return infos, nil
+}
+
+// SetTTL rewrites the session's file with the new TTL. The TTL is not
+// part of the state, so the version does not change.
+func (p *FileStateProvider) SetTTL(ctx context.Context, sessionID string, ttl time.Duration) error {
+	path, err := p.path(sessionID)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := p.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rec, err := readFileRecord(path)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	rec.TTL = ttl
+	return p.commit(sessionID, path, rec)
+}
+
+func (p *FileStateProvider) EvictIfExpired(ctx context.Context, sessionID string, now time.Time) (bool, error) {
+	path, err := p.path(sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	unlock, err := p.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	rec, err := readFileRecord(path)
+	if err != nil || rec == nil || !rec.info(sessionID).Expired(now) {
+		return false, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to delete state: %w", err)
+	}
+	return true, syncDir(p.dir)
+}
+
+// Close is a no-op: every Set is already durable when it returns.
+func (p *FileStateProvider) Close(ctx context.Context) error {
+	return nil
+}
+
+// lock takes the in-process mutex and then the cross-process file lock.
+func (p *FileStateProvider) lock() (func(), error) {
+	p.mu.Lock()
+	f, err := os.OpenFile(filepath.Join(p.dir, ".lock"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		p.mu.Unlock()
+		return nil, fmt.Errorf("failed to lock state dir: %w", err)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+		p.mu.Unlock()
+	}, nil
+}
+
+// syncDir fsyncs a state directory so a rename, create or remove in it is
+// durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open state dir: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !isSyncUnsupported(err) {
+		return fmt.Errorf("failed to sync state dir: %w", err)
+	}
+	return nil
+}
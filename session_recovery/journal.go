@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/duynguyendang/manglekit/core"
+)
+
+// --- Step journal: exactly-once step execution across crashes ---
+//
+// Every step is journaled in LogicalFacts: intent(<id>) is checkpointed
+// before the step's action runs and step(<id>) after it completes. A step
+// with an intent but no completion was interrupted somewhere between the
+// two checkpoints, so its action may or may not have taken effect. On
+// resume such steps are reconciled through their CheckStatus or
+// Compensate hooks instead of being blindly re-run. Actions receive an
+// idempotency key that is stable across retries of the same step.
+
+// ID returns the step's identifier, "execute_txn" for "step(execute_txn)".
+func (s WorkflowStep) ID() string {
+	return strings.TrimSuffix(strings.TrimPrefix(s.Fact, "step("), ")")
+}
+
+// intentFact is the journal entry written before the step's action runs.
+func (s WorkflowStep) intentFact() string {
+	return "intent(" + s.ID() + ")"
+}
+
+// idempotencyKey identifies one step of one session; an action that is
+// retried after a crash sees the same key.
+func idempotencyKey(sessionID string, step WorkflowStep) string {
+	return sessionID + "/" + step.ID()
+}
+
+// journal reads the intent and completion entries out of a session's facts.
+func journal(state *core.SessionState) (intended, completed map[string]bool) {
+	intended = make(map[string]bool)
+	completed = make(map[string]bool)
+	for _, f := range state.LogicalFacts {
+		switch {
+		case strings.HasPrefix(f, "intent("):
+			intended[strings.TrimSuffix(strings.TrimPrefix(f, "intent("), ")")] = true
+		case strings.HasPrefix(f, "step("):
+			completed[strings.TrimSuffix(strings.TrimPrefix(f, "step("), ")")] = true
+		}
+	}
+	return intended, completed
+}
+
+// StepRunner executes workflow steps through the journal.
+type StepRunner struct {
+	sm *SessionManager
+
+	// AfterAction runs between a step's action and its completion
+	// checkpoint; the demo uses it to crash mid-step.
+	AfterAction func(i int, step WorkflowStep)
+	// AfterCheckpoint runs once a step's completion is checkpointed.
+	AfterCheckpoint func(i int, step WorkflowStep)
+	// Facts, when set, receives each journal fact once it is checkpointed,
+	// keeping the client's engine in step with the session.
+	Facts FactLoader
+}
+
+func NewStepRunner(sm *SessionManager) *StepRunner {
+	return &StepRunner{sm: sm}
+}
+
+// RunStep journals the intent, runs the step's action with its
+// idempotency key, then records and checkpoints the completion.
+func (r *StepRunner) RunStep(ctx context.Context, state *core.SessionState, i int, step WorkflowStep) error {
+	if _, completed := journal(state); completed[step.ID()] {
+		return nil
+	}
+
+	state.LogicalFacts = append(state.LogicalFacts, step.intentFact())
+	if err := r.sm.Checkpoint(ctx, state); err != nil {
+		return fmt.Errorf("failed to journal intent for step %d: %w", i+1, err)
+	}
+	if err := r.loadFacts(step.intentFact()); err != nil {
+		return err
+	}
+
+	if err := r.act(ctx, state, i, step); err != nil {
+		return err
+	}
+	return r.complete(ctx, state, i, step)
+}
+
+// Reconcile settles every step that has an intent but no completion.
+func (r *StepRunner) Reconcile(ctx context.Context, state *core.SessionState, steps []WorkflowStep) error {
+	intended, completed := journal(state)
+	for i, step := range steps {
+		if !intended[step.ID()] || completed[step.ID()] {
+			continue
+		}
+		if err := r.ReconcileStep(ctx, state, i, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReconcileStep settles one interrupted step. If its CheckStatus reports
+// the action as applied, the step is marked complete without running it
+// again; otherwise the step is compensated, if it has a Compensate hook,
+// and its action re-run with the same idempotency key.
+func (r *StepRunner) ReconcileStep(ctx context.Context, state *core.SessionState, i int, step WorkflowStep) error {
+	key := idempotencyKey(state.SessionID, step)
+	fmt.Printf("  ⚠️  Step %d (%s) was interrupted after its intent was journaled\n", i+1, step.Name)
+
+	if step.CheckStatus != nil {
+		applied, err := step.CheckStatus(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to check status of step %d: %w", i+1, err)
+		}
+		if applied {
+			fmt.Printf("  ✓ Reconciled: %s already applied (key %s), not re-running\n", step.Name, key)
+			return r.complete(ctx, state, i, step)
+		}
+	}
+	if step.Compensate != nil {
+		if err := step.Compensate(ctx, key); err != nil {
+			return fmt.Errorf("failed to compensate step %d: %w", i+1, err)
+		}
+		fmt.Printf("  ↩ Compensated partial %s (key %s)\n", step.Name, key)
+	}
+
+	fmt.Printf("  ↻ Re-running %s with idempotency key %s\n", step.Name, key)
+	if err := r.act(ctx, state, i, step); err != nil {
+		return err
+	}
+	return r.complete(ctx, state, i, step)
+}
+
+func (r *StepRunner) act(ctx context.Context, state *core.SessionState, i int, step WorkflowStep) error {
+	if step.Action != nil {
+		if err := step.Action(ctx, idempotencyKey(state.SessionID, step)); err != nil {
+			return fmt.Errorf("step %d (%s) failed: %w", i+1, step.Name, err)
+		}
+	}
+	if r.AfterAction != nil {
+		r.AfterAction(i, step)
+	}
+	return nil
+}
+
+func (r *StepRunner) complete(ctx context.Context, state *core.SessionState, i int, step WorkflowStep) error {
+	executeStep(state, step)
+	if err := r.sm.Checkpoint(ctx, state); err != nil {
+		return fmt.Errorf("failed to checkpoint at step %d: %w", i+1, err)
+	}
+	fmt.Printf("  ✓ Checkpointed after step %d\n", i+1)
+	if err := r.loadFacts(step.Fact); err != nil {
+		return err
+	}
+	if r.AfterCheckpoint != nil {
+		r.AfterCheckpoint(i, step)
+	}
+	return nil
+}
+
+func (r *StepRunner) loadFacts(facts ...string) error {
+	if r.Facts == nil {
+		return nil
+	}
+	if err := r.Facts.LoadFacts(engineFacts(facts)); err != nil {
+		return fmt.Errorf("failed to load journal facts: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Ledger stands in for the external system the "Execute Transaction"
+// step charges. It lives in its own file, outside the session checkpoint,
+// so a charge survives a crash that loses the step's completion.
+type Ledger struct {
+	path string
+	mu   sync.Mutex
+}
+
+// LedgerEntry is one applied transaction.
+type LedgerEntry struct {
+	Key   string `json:"key"`
+	TxnID string `json:"txn_id"`
+}
+
+func NewLedger(path string) *Ledger {
+	return &Ledger{path: path}
+}
+
+// Charge applies the transaction once per idempotency key; a repeated
+// charge with the same key is a no-op.
+func (l *Ledger) Charge(ctx context.Context, key, txnID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries, err := l.read()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Key == key {
+			return nil
+		}
+	}
+	entries = append(entries, LedgerEntry{Key: key, TxnID: txnID})
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ledger: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to sync ledger: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to commit ledger: %w", err)
+	}
+	return nil
+}
+
+// Status reports whether a charge with this idempotency key was applied.
+func (l *Ledger) Status(ctx context.Context, key string) (bool, error) {
+	entries, err := l.Entries()
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if e.Key == key {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Entries returns every applied transaction.
+func (l *Ledger) Entries() ([]LedgerEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.read()
+}
+
+// Reset removes the ledger file.
+func (l *Ledger) Reset() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to reset ledger: %w", err)
+	}
+	return nil
+}
+
+func (l *Ledger) read() ([]LedgerEntry, error) {
+	data, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+	var entries []LedgerEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode ledger: %w", err)
+	}
+	return entries, nil
+}
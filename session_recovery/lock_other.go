@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// lockFile has no cross-process lock outside Unix; writers in one process
+// are still serialised by FileStateProvider's mutex.
+func lockFile(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
+
+// isSyncUnsupported reports directory fsync failures to ignore: Windows
+// cannot fsync a directory handle.
+func isSyncUnsupported(err error) bool { return true }
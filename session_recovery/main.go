@@ -3,8 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,65 +19,96 @@ import (
 )
 
 // InMemoryStateProvider implements core.StateProvider using a thread-safe map.
-// For production, replace with Redis, Badger, or Postgres-backed implementation.
+// State lives only as long as the process; FileStateProvider and
+// WALStateProvider persist it to disk. For production, replace with Redis,
+// Badger, or Postgres-backed implementation.
 type InMemoryStateProvider struct {
 	store map[string]*core.SessionState
+	info  map[string]SessionInfo
+	clock Clock
 	mu    sync.RWMutex
 }
 
 func NewInMemoryStateProvider() *InMemoryStateProvider {
 	return &InMemoryStateProvider{
 		store: make(map[string]*core.SessionState),
+		info:  make(map[string]SessionInfo),
+		clock: SystemClock,
 	}
 }
 
+// SetClock replaces the clock used for session timestamps.
+func (p *InMemoryStateProvider) SetClock(c Clock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clock = c
+}
+
 func (p *InMemoryStateProvider) Get(ctx context.Context, sessionID string) (any, error) {
+	data, _, err := p.GetVersioned(ctx, sessionID)
+	return data, err
+}
+
+func (p *InMemoryStateProvider) GetVersioned(ctx context.Context, sessionID string) (any, uint64, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
 	state, ok := p.store[sessionID]
 	if !ok {
-		return nil, nil
+		return nil, 0, nil
 	}
 	data, err := json.Marshal(state)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal state: %w", err)
+		return nil, 0, fmt.Errorf("failed to marshal state: %w", err)
 	}
-	return data, nil
+	return data, p.info[sessionID].Version, nil
 }
 
 func (p *InMemoryStateProvider) Set(ctx context.Context, sessionID string, state any) error {
+	parsed, err := parseState(state)
+	if err != nil {
+		return err
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.put(sessionID, parsed)
+	return nil
+}
 
-	var parsed core.SessionState
-	switch v := state.(type) {
-	case []byte:
-		if err := json.Unmarshal(v, &parsed); err != nil {
-			return fmt.Errorf("failed to unmarshal state: %w", err)
-		}
-	case *core.SessionState:
-		parsed = *v
-	case core.SessionState:
-		parsed = v
-	default:
-		data, err := json.Marshal(v)
-		if err != nil {
-			return fmt.Errorf("failed to marshal state: %w", err)
-		}
-		if err := json.Unmarshal(data, &parsed); err != nil {
-			return fmt.Errorf("failed to unmarshal state: %w", err)
-		}
+func (p *InMemoryStateProvider) CompareAndSet(ctx context.Context, sessionID string, state any, expected uint64) (uint64, error) {
+	parsed, err := parseState(state)
+	if err != nil {
+		return 0, err
 	}
 
-	p.store[sessionID] = &parsed
-	return nil
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if actual := p.info[sessionID].Version; actual != expected {
+		return 0, &VersionConflictError{SessionID: sessionID, Expected: expected, Actual: actual}
+	}
+	return p.put(sessionID, parsed), nil
+}
+
+// put stores state as the session's next version; p.mu must be held.
+func (p *InMemoryStateProvider) put(sessionID string, state *core.SessionState) uint64 {
+	now := p.clock.Now()
+	info, ok := p.info[sessionID]
+	if !ok {
+		info = SessionInfo{SessionID: sessionID, Created: now}
+	}
+	info.Updated = now
+	info.Version++
+	p.store[sessionID] = state
+	p.info[sessionID] = info
+	return info.Version
 }
 
 func (p *InMemoryStateProvider) Delete(ctx context.Context, sessionID string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	delete(p.store, sessionID)
+	delete(p.info, sessionID)
 	return nil
 }
 
@@ -79,35 +116,208 @@ func (p *InMemoryStateProvider) Close(ctx context.Context) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.store = make(map[string]*core.SessionState)
+	p.info = make(map[string]SessionInfo)
 	return nil
 }
 
+func (p *InMemoryStateProvider) List(ctx context.Context, filter SessionFilter) ([]SessionInfo, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var infos []SessionInfo
+	for _, info := range p.info {
+		if filter.Match(info) {
+			infos = append(infos, info)
+		}
+	}
+	sortSessions(infos)
+	return infos, nil
+}
+
+func (p *InMemoryStateProvider) SetTTL(ctx context.Context, sessionID string, ttl time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, ok := p.info[sessionID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	info.TTL = ttl
+	p.info[sessionID] = info
+	return nil
+}
+
+func (p *InMemoryStateProvider) EvictIfExpired(ctx context.Context, sessionID string, now time.Time) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, ok := p.info[sessionID]
+	if !ok || !info.Expired(now) {
+		return false, nil
+	}
+	delete(p.store, sessionID)
+	delete(p.info, sessionID)
+	return true, nil
+}
+
+// parseState copies any accepted state representation into a new
+// SessionState, so the store never aliases the caller's value.
+func parseState(state any) (*core.SessionState, error) {
+	var parsed core.SessionState
+	switch v := state.(type) {
+	case []byte:
+		if err := json.Unmarshal(v, &parsed); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal state: %w", err)
+		}
+	case *core.SessionState:
+		parsed = *v
+	case core.SessionState:
+		parsed = v
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal state: %w", err)
+		}
+		if err := json.Unmarshal(data, &parsed); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal state: %w", err)
+		}
+	}
+	return &parsed, nil
+}
+
 // SessionManager wraps a StateProvider with checkpoint/hydrate logic.
 // This mirrors what the SDK's internal statemanager does.
+//
+// With a VersionedStateProvider, the manager remembers which stored
+// version each session it hydrated or checkpointed is based on, and
+// Checkpoint writes with CompareAndSet so two clients working on the same
+// session cannot silently overwrite each other. A session the manager does
+// not know can only be created, never overwritten: hydrate it first.
 type SessionManager struct {
 	provider core.StateProvider
+
+	// OnConflict is applied when a checkpoint's base version is stale.
+	OnConflict ConflictStrategy
+	// MaxMergeRetries bounds ConflictMergeFacts re-reads per Checkpoint.
+	MaxMergeRetries int
+
+	mu       sync.Mutex
+	versions map[string]uint64 // by session ID
 }
 
 func NewSessionManager(provider core.StateProvider) *SessionManager {
-	return &SessionManager{provider: provider}
+	return &SessionManager{
+		provider:        provider,
+		MaxMergeRetries: 10,
+		versions:        make(map[string]uint64),
+	}
 }
 
 func (m *SessionManager) Checkpoint(ctx context.Context, state *core.SessionState) error {
 	if err := state.Validate(); err != nil {
 		return fmt.Errorf("invalid state: %w", err)
 	}
-	return m.provider.Set(ctx, state.SessionID, state)
+	versioned, ok := m.provider.(VersionedStateProvider)
+	if !ok {
+		return m.provider.Set(ctx, state.SessionID, state)
+	}
+
+	m.mu.Lock()
+	expected, known := m.versions[state.SessionID]
+	m.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		version, err := versioned.CompareAndSet(ctx, state.SessionID, state, expected)
+		if err == nil {
+			m.track(state.SessionID, version)
+			return nil
+		}
+		var conflict *VersionConflictError
+		if !errors.As(err, &conflict) {
+			return err
+		}
+		if !known {
+			return fmt.Errorf("session %s was not hydrated by this manager: %w", state.SessionID, err)
+		}
+		if m.OnConflict != ConflictMergeFacts || attempt >= m.MaxMergeRetries {
+			return err
+		}
+
+		// Another writer moved the session on: fold its facts into ours
+		raw, actual, err := versioned.GetVersioned(ctx, state.SessionID)
+		if err != nil {
+			return fmt.Errorf("provider get failed: %w", err)
+		}
+		if raw != nil {
+			stored, err := decodeState(raw)
+			if err != nil {
+				return err
+			}
+			state.LogicalFacts = mergeFacts(stored.LogicalFacts, state.LogicalFacts)
+		}
+		expected = actual
+	}
 }
 
 func (m *SessionManager) Hydrate(ctx context.Context, sessionID string) (*core.SessionState, error) {
-	raw, err := m.provider.Get(ctx, sessionID)
+	var (
+		raw     any
+		version uint64
+		err     error
+	)
+	if versioned, ok := m.provider.(VersionedStateProvider); ok {
+		raw, version, err = versioned.GetVersioned(ctx, sessionID)
+	} else {
+		raw, err = m.provider.Get(ctx, sessionID)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("provider get failed: %w", err)
 	}
 	if raw == nil {
+		m.Forget(sessionID)
 		return nil, nil
 	}
 
+	state, err := decodeState(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := state.Validate(); err != nil {
+		return nil, err
+	}
+	m.track(sessionID, version)
+	return state, nil
+}
+
+// Delete removes the session from the provider and forgets its version.
+func (m *SessionManager) Delete(ctx context.Context, sessionID string) error {
+	if err := m.provider.Delete(ctx, sessionID); err != nil {
+		return err
+	}
+	m.Forget(sessionID)
+	return nil
+}
+
+// Forget drops the version the manager holds for a session, e.g. after a
+// Reaper evicted it. The session can then only be created again.
+func (m *SessionManager) Forget(sessionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.versions, sessionID)
+}
+
+// track records the stored version a session is now based on.
+func (m *SessionManager) track(sessionID string, version uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.versions == nil {
+		m.versions = make(map[string]uint64)
+	}
+	m.versions[sessionID] = version
+}
+
+// decodeState converts a provider's Get result into a SessionState.
+func decodeState(raw any) (*core.SessionState, error) {
 	var state core.SessionState
 	switch v := raw.(type) {
 	case []byte:
@@ -125,121 +335,229 @@ func (m *SessionManager) Hydrate(ctx context.Context, sessionID string) (*core.S
 			return nil, err
 		}
 	}
-
-	if err := state.Validate(); err != nil {
-		return nil, err
-	}
 	return &state, nil
 }
 
+func exampleDir() string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Dir(filename)
+}
+
 // WorkflowStep represents a single step in a multi-step workflow.
 type WorkflowStep struct {
 	Name     string
 	Fact     string
 	Metadata map[string]any
+
+	// Action performs the step's side effect, if any. key is the step's
+	// idempotency key and is the same on every retry.
+	Action func(ctx context.Context, key string) error
+	// CheckStatus reports whether an interrupted Action already took effect.
+	CheckStatus func(ctx context.Context, key string) (bool, error)
+	// Compensate undoes a partially applied Action before it is re-run.
+	Compensate func(ctx context.Context, key string) error
 }
 
 func main() {
+	stateDir := flag.String("state-dir", filepath.Join(os.TempDir(), "session_recovery"), "directory for session checkpoints")
+	sessionID := flag.String("session", "workflow-session-001", "session ID to checkpoint under")
+	crashAfter := flag.Int("crash-after", 3, "exit the process after checkpointing step N (0 runs to completion)")
+	crashMidStep := flag.Int("crash-mid-step", 0, "exit the process after step N's action ran but before its completion is checkpointed")
+	resume := flag.Bool("resume", false, "recover the session from its checkpoint and finish the workflow")
+	providerKind := flag.String("provider", "file", "state provider: file (full rewrite per checkpoint) or wal (append-only log with snapshots)")
+	snapshotEvery := flag.Int("snapshot-every", 2, "wal provider: compact the log into a snapshot every N checkpoints")
+	retain := flag.Duration("retain", time.Second, "TTL given to the finished session before the reaper evicts it")
+	reapInterval := flag.Duration("reap-interval", 250*time.Millisecond, "how often the reaper scans for expired sessions")
+	encrypt := flag.Bool("encrypt", false, "seal checkpoints with AES-GCM using keys from $SESSION_RECOVERY_KEYS (id=base64key,...; first is current)")
+	flag.Parse()
+
 	ctx := context.Background()
 
 	fmt.Println("=== Session Recovery Example ===")
 	fmt.Println("Demonstrates durable state persistence with crash simulation")
 	fmt.Println()
 
+	// The transaction step charges an external ledger, which must happen
+	// exactly once however often the workflow crashes and resumes. Its
+	// extension keeps it out of the file provider's session listing.
+	ledger := NewLedger(filepath.Join(*stateDir, *sessionID+".ledger"))
+
+	// Created once the provider is open; step actions use it to consult policy
+	var client *sdk.Client
+
 	// Define a 5-step workflow
 	steps := []WorkflowStep{
 		{Name: "Initialize Environment", Fact: "step(initialize)", Metadata: map[string]any{"env": "production"}},
 		{Name: "Load Configuration", Fact: "step(load_config)", Metadata: map[string]any{"config_version": "2.1"}},
 		{Name: "Validate Inputs", Fact: "step(validate)", Metadata: map[string]any{"validated": true}},
-		{Name: "Execute Transaction", Fact: "step(execute_txn)", Metadata: map[string]any{"txn_id": "TXN-001"}},
-		{Name: "Finalize and Report", Fact: "step(finalize)", Metadata: map[string]any{"report": "complete"}},
+		{
+			Name: "Execute Transaction", Fact: "step(execute_txn)", Metadata: map[string]any{"txn_id": "TXN-001"},
+			Action: func(ctx context.Context, key string) error {
+				return ledger.Charge(ctx, key, "TXN-001")
+			},
+			CheckStatus: ledger.Status,
+		},
+		{
+			Name: "Finalize and Report", Fact: "step(finalize)", Metadata: map[string]any{"report": "complete"},
+			// Gated by session_policy.dl, which sees the facts loaded into
+			// the client's engine, recovered ones included
+			Action: func(ctx context.Context, key string) error {
+				allowed, err := MayRun(ctx, client, "finalize")
+				if err != nil {
+					return err
+				}
+				if !allowed {
+					return fmt.Errorf("session policy does not allow finalize: validate and execute_txn must be done")
+				}
+				return nil
+			},
+		},
 	}
 
-	sessionID := "workflow-session-001"
-	provider := NewInMemoryStateProvider()
+	var (
+		provider     versionedStore
+		providerName string
+		err          error
+	)
+	switch *providerKind {
+	case "file":
+		provider, err = NewFileStateProvider(*stateDir)
+		providerName = "FileStateProvider"
+	case "wal":
+		provider, err = NewWALStateProvider(*stateDir, *snapshotEvery)
+		providerName = "WALStateProvider"
+	default:
+		log.Fatalf("Unknown provider %q (want file or wal)", *providerKind)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open state dir: %v", err)
+	}
+
+	// Checkpoints hold transaction IDs and customer data, so they can be
+	// sealed before they touch the disk
+	if *encrypt {
+		spec := os.Getenv("SESSION_RECOVERY_KEYS")
+		if spec == "" {
+			log.Fatalf("-encrypt needs SESSION_RECOVERY_KEYS, e.g. SESSION_RECOVERY_KEYS=k1=$(head -c32 /dev/urandom | base64)")
+		}
+		keys, err := ParseKeyring(spec)
+		if err != nil {
+			log.Fatalf("Failed to parse SESSION_RECOVERY_KEYS: %v", err)
+		}
+		keyID, _, _ := keys.Current()
+		provider = NewEncryptedStateProvider(provider, keys)
+		providerName = fmt.Sprintf("%s, AES-GCM sealed under key %s", providerName, keyID)
+	}
 
 	// Create SDK client with the state provider to demonstrate sdk.WithStateProvider()
-	client, err := sdk.NewClient(ctx, sdk.WithStateProvider(provider))
+	client, err = sdk.NewClient(ctx, sdk.WithStateProvider(provider))
 	if err != nil {
 		log.Fatalf("Failed to create SDK client: %v", err)
 	}
-	fmt.Println("✓ SDK client created with InMemoryStateProvider")
+	if err := LoadSessionPolicy(ctx, client); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("✓ SDK client created with %s (%s)\n", providerName, *stateDir)
 	fmt.Println()
 
 	// Use our SessionManager for direct checkpoint/hydrate operations
 	sm := NewSessionManager(provider)
-
-	// --- Phase 1: Execute steps 1-3, then simulate crash ---
-	fmt.Println("--- Phase 1: Execute steps 1-3 and checkpoint ---")
-
-	state := &core.SessionState{
-		SessionID: sessionID,
-		ActiveEnvelope: core.Envelope{
-			ID:          core.NewEnvelope(nil).ID,
-			Payload:     map[string]any{"workflow": "order-processing"},
-			ContentType: core.TypeJSON,
-			Metadata:    make(map[string]any),
-		},
-		ExecutionCtx: core.ExecutionContext{},
+	runner := NewStepRunner(sm)
+	runner.Facts = client
+	runner.AfterAction = func(i int, step WorkflowStep) {
+		if !*resume && i+1 == *crashMidStep {
+			fmt.Println()
+			fmt.Printf("💥 CRASH SIMULATED — %s ran but its completion was never checkpointed\n", step.Name)
+			fmt.Printf("   Rerun with -resume -provider %s -state-dir %s to recover in a new process\n", *providerKind, *stateDir)
+			os.Exit(1)
+		}
 	}
-
-	for i := 0; i < 3; i++ {
-		executeStep(state, steps[i])
-
-		if err := sm.Checkpoint(ctx, state); err != nil {
-			log.Fatalf("Failed to checkpoint at step %d: %v", i+1, err)
+	runner.AfterCheckpoint = func(i int, step WorkflowStep) {
+		if !*resume && i+1 == *crashAfter && i+1 < len(steps) {
+			fmt.Println()
+			fmt.Println("💥 CRASH SIMULATED — exiting without shutdown")
+			fmt.Printf("   Rerun with -resume -provider %s -state-dir %s to recover in a new process\n", *providerKind, *stateDir)
+			os.Exit(1)
 		}
-		fmt.Printf("  ✓ Checkpointed after step %d\n", i+1)
 	}
 
-	fmt.Println()
-	fmt.Println("💥 CRASH SIMULATED — discarding client and state variable")
-	fmt.Println("   Creating fresh client with the same StateProvider...")
-	fmt.Println()
-
-	// Shutdown old client
-	_ = client.Shutdown(ctx)
-
-	// --- Phase 2: Recover and resume from step 4 ---
-	fmt.Println("--- Phase 2: Recover state and resume from step 4 ---")
-
-	// New client reuses the same provider — state persists
-	client2, err := sdk.NewClient(ctx, sdk.WithStateProvider(provider))
+	// The workflow runner asks Datalog where to resume instead of counting steps
+	workflow, err := NewWorkflowRunner(ctx, client, runner, steps)
 	if err != nil {
-		log.Fatalf("Failed to create new client: %v", err)
+		log.Fatalf("Failed to create workflow runner: %v", err)
 	}
-	defer client2.Shutdown(ctx)
 
-	sm2 := NewSessionManager(provider)
+	var state *core.SessionState
 
-	recovered, err := sm2.Hydrate(ctx, sessionID)
-	if err != nil {
-		log.Fatalf("Failed to hydrate session: %v", err)
-	}
-	if recovered == nil {
-		log.Fatal("No recovered state found — checkpoint may have failed")
-	}
+	if !*resume {
+		// --- Phase 1: Execute steps until the simulated crash ---
+		fmt.Println("--- Phase 1: Execute steps and checkpoint ---")
 
-	fmt.Printf("  ✓ Recovered session: %s\n", recovered.SessionID)
-	fmt.Printf("  ✓ Facts preserved: %v\n", recovered.LogicalFacts)
-	fmt.Printf("  ✓ History length: %d messages\n", len(recovered.ExecutionCtx.CurrentHistory))
-	fmt.Println()
+		// A fresh run replaces any checkpoint left by an earlier one
+		if err := sm.Delete(ctx, *sessionID); err != nil {
+			log.Fatalf("Failed to clear old checkpoint: %v", err)
+		}
+		if err := ledger.Reset(); err != nil {
+			log.Fatalf("Failed to clear old ledger: %v", err)
+		}
 
-	// Resume from step 4
-	for i := 3; i < len(steps); i++ {
-		executeStep(recovered, steps[i])
+		state = &core.SessionState{
+			SessionID: *sessionID,
+			ActiveEnvelope: core.Envelope{
+				ID:          core.NewEnvelope(nil).ID,
+				Payload:     map[string]any{"workflow": "order-processing"},
+				ContentType: core.TypeJSON,
+				Metadata:    make(map[string]any),
+			},
+			ExecutionCtx: core.ExecutionContext{},
+		}
+	} else {
+		// --- Phase 2: Recover in a new process and resume ---
+		// The recovered facts go into this client's engine and the recovered
+		// history becomes its LLM's context
+		resumed, err := ResumeSession(ctx, client, sm, *sessionID, &progressLLM{})
+		if err != nil {
+			log.Fatalf("Failed to resume session: %v", err)
+		}
+		if resumed == nil {
+			log.Fatalf("No checkpoint for session %s in %s — run without -resume first", *sessionID, *stateDir)
+		}
+		recovered := resumed.State
 
-		if err := sm2.Checkpoint(ctx, recovered); err != nil {
-			log.Fatalf("Failed to checkpoint at step %d: %v", i+1, err)
+		fmt.Println("--- Phase 2: Recover state and resume ---")
+		fmt.Printf("  ✓ Recovered session: %s\n", recovered.SessionID)
+		fmt.Printf("  ✓ Facts preserved: %v\n", recovered.LogicalFacts)
+		fmt.Printf("  ✓ History length: %d messages\n", len(recovered.ExecutionCtx.CurrentHistory))
+		fmt.Printf("  ✓ Loaded %d recovered facts into the policy engine\n", len(recovered.LogicalFacts))
+
+		reply, err := resumed.LLM.Complete(ctx, "Which steps have already run?")
+		if err != nil {
+			log.Fatalf("LLM call failed: %v", err)
 		}
-		fmt.Printf("  ✓ Checkpointed after step %d\n", i+1)
+		fmt.Printf("  🤖 LLM with restored context: %s\n", reply)
+		recovered.ExecutionCtx.CurrentHistory = resumed.LLM.Messages()
+
+		state = recovered
+		next, err := workflow.ResumePoint(ctx, state)
+		if err != nil {
+			log.Fatalf("Failed to derive resume point: %v", err)
+		}
+		if next < len(steps) {
+			fmt.Printf("  → Datalog next_step: %s (step %d)\n", steps[next].ID(), next+1)
+		}
+		fmt.Println()
+	}
+
+	// Interrupted steps are reconciled, then every pending step runs in order
+	if err := workflow.Run(ctx, state); err != nil {
+		log.Fatal(err)
 	}
 
 	// --- Final verification ---
 	fmt.Println()
 	fmt.Println("--- Final Verification ---")
 
-	final, err := sm2.Hydrate(ctx, sessionID)
+	final, err := sm.Hydrate(ctx, *sessionID)
 	if err != nil {
 		log.Fatalf("Failed final hydration: %v", err)
 	}
@@ -250,17 +568,87 @@ func main() {
 		fmt.Printf("    [%d] %s\n", i+1, f)
 	}
 	fmt.Printf("  History:     %d messages\n", len(final.ExecutionCtx.CurrentHistory))
+
+	entries, err := ledger.Entries()
+	if err != nil {
+		log.Fatalf("Failed to read ledger: %v", err)
+	}
+	fmt.Printf("  Ledger:      %d transaction(s)\n", len(entries))
+	for _, e := range entries {
+		fmt.Printf("    %s (key %s)\n", e.TxnID, e.Key)
+	}
 	fmt.Println()
 
-	// Clean up
-	if err := provider.Delete(ctx, sessionID); err != nil {
-		log.Fatalf("Failed to delete session: %v", err)
+	// --- Clean up through the reaper ---
+	fmt.Println("--- Session Garbage Collection ---")
+
+	sessions, err := provider.List(ctx, SessionFilter{Prefix: *sessionID})
+	if err != nil {
+		log.Fatalf("Failed to list sessions: %v", err)
+	}
+	for _, info := range sessions {
+		fmt.Printf("  📋 %s  version %d, updated %s\n", info.SessionID, info.Version, info.Updated.Format(time.RFC3339))
+	}
+
+	// The finished session is kept for -retain, then the reaper evicts it
+	if err := provider.SetTTL(ctx, *sessionID, *retain); err != nil {
+		log.Fatalf("Failed to set session TTL: %v", err)
 	}
-	fmt.Printf("  ✓ Session %s cleaned up\n", sessionID)
+	fmt.Printf("  ⏳ Session %s expires in %s\n", *sessionID, *retain)
+
+	reaped := make(chan []SessionInfo, 1)
+	reaper := &Reaper{
+		Store:    provider,
+		Interval: *reapInterval,
+		OnReap: func(removed []SessionInfo) {
+			for _, info := range removed {
+				sm.Forget(info.SessionID)
+			}
+			select {
+			case reaped <- removed:
+			default:
+			}
+		},
+		OnError: func(err error) { log.Printf("Reaper: %v", err) },
+	}
+	stopReaper := reaper.Start(ctx)
+	select {
+	case removed := <-reaped:
+		for _, info := range removed {
+			fmt.Printf("  🧹 Reaper evicted %s (idle since %s)\n", info.SessionID, info.Updated.Format(time.RFC3339))
+		}
+	case <-time.After(*retain + 10*(*reapInterval)):
+		log.Fatalf("Reaper did not evict session %s", *sessionID)
+	}
+	stopReaper()
+
+	if err := ledger.Reset(); err != nil {
+		log.Fatalf("Failed to delete ledger: %v", err)
+	}
+	_ = client.Shutdown(ctx)
 	fmt.Println()
 	fmt.Println("=== Session recovery example completed successfully ===")
 }
 
+// progressLLM is a mock LLM that answers from the conversation in its
+// prompt, so the demo shows what context survived the crash.
+type progressLLM struct{}
+
+func (m *progressLLM) Complete(ctx context.Context, prompt string) (string, error) {
+	return fmt.Sprintf("I can see %d executed step(s) in our conversation", strings.Count(prompt, "Executed: ")), nil
+}
+
+func (m *progressLLM) Generate(ctx context.Context, prompt string, opts ...core.GenerateOption) (*core.LLMResponse, error) {
+	text, _ := m.Complete(ctx, prompt)
+	return &core.LLMResponse{Text: text, Usage: map[string]int{"prompt": len(prompt), "completion": len(text)}}, nil
+}
+
+func (m *progressLLM) Stream(ctx context.Context, prompt string) (<-chan core.StreamChunk, error) {
+	ch := make(chan core.StreamChunk)
+	close(ch)
+	return ch, nil
+}
+
 // executeStep simulates executing a workflow step by appending facts and history.
 func executeStep(state *core.SessionState, step WorkflowStep) {
 	state.LogicalFacts = append(state.LogicalFacts, step.Fact)
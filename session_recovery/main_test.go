@@ -1,11 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/duynguyendang/manglekit/core"
+	"github.com/duynguyendang/manglekit/sdk"
 	"github.com/google/uuid"
 )
 
@@ -292,3 +303,1476 @@ func TestSessionCheckpointAndRecover(t *testing.T) {
 		}
 	})
 }
+
+func TestFileStateProvider_CRUD(t *testing.T) {
+	dir := t.TempDir()
+	provider, err := NewFileStateProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := NewSessionManager(provider)
+	ctx := context.Background()
+
+	if raw, err := provider.Get(ctx, "missing"); err != nil || raw != nil {
+		t.Fatalf("Get missing: got %v, %v; want nil, nil", raw, err)
+	}
+
+	state := &core.SessionState{
+		SessionID:      "file-test",
+		ActiveEnvelope: core.Envelope{ID: uuid.New(), ContentType: core.TypeJSON, Metadata: map[string]any{"env": "test"}},
+		ExecutionCtx: core.ExecutionContext{
+			CurrentHistory: []core.Message{{Role: "system", Content: "Executed: Initialize"}},
+		},
+		LogicalFacts: []string{"step(initialize)"},
+	}
+	if err := sm.Checkpoint(ctx, state); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+
+	// A second provider on the same directory sees the checkpoint
+	reopened, err := NewFileStateProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := NewSessionManager(reopened).Hydrate(ctx, "file-test")
+	if err != nil {
+		t.Fatalf("Hydrate failed: %v", err)
+	}
+	if recovered == nil || len(recovered.LogicalFacts) != 1 || len(recovered.ExecutionCtx.CurrentHistory) != 1 {
+		t.Fatalf("unexpected recovered state: %+v", recovered)
+	}
+
+	// Only the committed checkpoint (and the lock file) is left behind
+	tmps, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if len(tmps) != 0 {
+		t.Errorf("temp files left behind: %v", tmps)
+	}
+
+	if err := provider.Delete(ctx, "file-test"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := provider.Delete(ctx, "file-test"); err != nil {
+		t.Errorf("Delete of missing session should succeed, got %v", err)
+	}
+	if raw, _ := provider.Get(ctx, "file-test"); raw != nil {
+		t.Error("expected nil after delete")
+	}
+
+	if err := provider.Set(ctx, "../escape", state); err == nil {
+		t.Error("expected session ID with a path separator to be rejected")
+	}
+}
+
+func TestFileStateProvider_ConcurrentWriters(t *testing.T) {
+	provider, err := NewFileStateProvider(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			s := &core.SessionState{SessionID: "shared", LogicalFacts: []string{fmt.Sprintf("writer(%d)", idx)}}
+			if err := provider.Set(ctx, "shared", s); err != nil {
+				t.Errorf("Set failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	recovered, err := NewSessionManager(provider).Hydrate(ctx, "shared")
+	if err != nil {
+		t.Fatalf("Hydrate after concurrent writes failed: %v", err)
+	}
+	if recovered == nil || len(recovered.LogicalFacts) != 1 {
+		t.Fatalf("expected one writer's complete state, got %+v", recovered)
+	}
+}
+
+// TestFileStateProvider_RecoversInNewProcess checkpoints three steps in a
+// child process that exits without any cleanup, then hydrates them here.
+func TestFileStateProvider_RecoversInNewProcess(t *testing.T) {
+	if dir := os.Getenv("SESSION_RECOVERY_CRASH_DIR"); dir != "" {
+		provider, err := NewFileStateProvider(dir)
+		if err != nil {
+			os.Exit(2)
+		}
+		sm := NewSessionManager(provider)
+		state := &core.SessionState{SessionID: "crash-process", ActiveEnvelope: core.Envelope{ID: uuid.New(), ContentType: core.TypeJSON}}
+		for _, fact := range []string{"step(initialize)", "step(load_config)", "step(validate)"} {
+			state.LogicalFacts = append(state.LogicalFacts, fact)
+			if err := sm.Checkpoint(context.Background(), state); err != nil {
+				os.Exit(2)
+			}
+		}
+		os.Exit(1) // crash
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFileStateProvider_RecoversInNewProcess$")
+	cmd.Env = append(os.Environ(), "SESSION_RECOVERY_CRASH_DIR="+dir)
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("child process should crash with exit code 1, got %v", err)
+	}
+
+	provider, err := NewFileStateProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := NewSessionManager(provider).Hydrate(context.Background(), "crash-process")
+	if err != nil {
+		t.Fatalf("Hydrate failed: %v", err)
+	}
+	if recovered == nil || len(recovered.LogicalFacts) != 3 {
+		t.Fatalf("expected 3 facts recovered from the crashed process, got %+v", recovered)
+	}
+}
+
+func walStep(state *core.SessionState, i int) {
+	state.LogicalFacts = append(state.LogicalFacts, fmt.Sprintf("step(%d)", i))
+	state.ExecutionCtx.CurrentHistory = append(state.ExecutionCtx.CurrentHistory, core.Message{
+		Role:    "system",
+		Content: fmt.Sprintf("Executed: step %d", i),
+	})
+	state.ActiveEnvelope.Metadata["last_step"] = i
+}
+
+func newWALState(sessionID string) *core.SessionState {
+	return &core.SessionState{
+		SessionID: sessionID,
+		ActiveEnvelope: core.Envelope{
+			ID:          uuid.New(),
+			Payload:     map[string]any{"workflow": "order"},
+			ContentType: core.TypeJSON,
+			Metadata:    map[string]any{"env": "test"},
+		},
+	}
+}
+
+func TestWALStateProvider_ReplaysSnapshotAndTail(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	provider, err := NewWALStateProvider(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := NewSessionManager(provider)
+
+	state := newWALState("wal-test")
+	for i := 1; i <= 10; i++ {
+		walStep(state, i)
+		if i == 7 {
+			delete(state.ActiveEnvelope.Metadata, "env")
+		}
+		if err := sm.Checkpoint(ctx, state); err != nil {
+			t.Fatalf("Checkpoint %d failed: %v", i, err)
+		}
+	}
+
+	// 10 records with a snapshot every 4: the snapshot holds 8, the log 2
+	if _, err := os.Stat(filepath.Join(dir, "wal-test.snap")); err != nil {
+		t.Fatalf("expected a snapshot: %v", err)
+	}
+	walData, err := os.ReadFile(filepath.Join(dir, "wal-test.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records, valid := decodeRecords(walData); len(records) != 2 || valid != int64(len(walData)) {
+		t.Errorf("log tail: got %d records (%d of %d bytes valid), want 2", len(records), valid, len(walData))
+	}
+
+	// A fresh provider (new process) replays snapshot plus tail
+	reopened, err := NewWALStateProvider(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := NewSessionManager(reopened).Hydrate(ctx, "wal-test")
+	if err != nil {
+		t.Fatalf("Hydrate failed: %v", err)
+	}
+	if recovered == nil || len(recovered.LogicalFacts) != 10 || len(recovered.ExecutionCtx.CurrentHistory) != 10 {
+		t.Fatalf("unexpected recovered state: %+v", recovered)
+	}
+	if recovered.LogicalFacts[9] != "step(10)" {
+		t.Errorf("last fact: got %q, want step(10)", recovered.LogicalFacts[9])
+	}
+	if _, ok := recovered.ActiveEnvelope.Metadata["env"]; ok {
+		t.Error("deleted metadata key survived replay")
+	}
+	if got := recovered.ActiveEnvelope.Metadata["last_step"]; got != float64(10) {
+		t.Errorf("last_step: got %v, want 10", got)
+	}
+}
+
+func TestWALStateProvider_LogsDeltasNotFullState(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	provider, err := NewWALStateProvider(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := newWALState("delta-test")
+	for i := 1; i <= 50; i++ {
+		walStep(state, i)
+		if err := provider.Set(ctx, "delta-test", state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	walData, err := os.ReadFile(filepath.Join(dir, "delta-test.wal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, _ := decodeRecords(walData)
+	if len(records) != 50 {
+		t.Fatalf("got %d records, want 50", len(records))
+	}
+	if records[0].Full == nil {
+		t.Error("first record should carry the full state")
+	}
+	for _, rec := range records[1:] {
+		if rec.Full != nil || len(rec.Facts) != 1 || len(rec.Messages) != 1 {
+			t.Fatalf("record %d should be a one-step delta, got %+v", rec.Seq, rec)
+		}
+	}
+
+	// A changed payload cannot be expressed as growth: log the full state
+	state.ActiveEnvelope.Payload = map[string]any{"workflow": "refund"}
+	if err := provider.Set(ctx, "delta-test", state); err != nil {
+		t.Fatal(err)
+	}
+	walData, _ = os.ReadFile(filepath.Join(dir, "delta-test.wal"))
+	records, _ = decodeRecords(walData)
+	if last := records[len(records)-1]; last.Full == nil {
+		t.Error("payload change should be logged as a full-state record")
+	}
+}
+
+func TestWALStateProvider_FailedFirstAppendIsNotCached(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	provider, err := NewWALStateProvider(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a log symlinked into a missing directory reads as absent, but the
+	// first append cannot create it
+	walPath := filepath.Join(dir, "fail-test.wal")
+	if err := os.Symlink(filepath.Join(dir, "missing", "fail-test.wal"), walPath); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	state := newWALState("fail-test")
+	walStep(state, 1)
+	if err := provider.Set(ctx, "fail-test", state); err == nil {
+		t.Fatal("expected the append to fail")
+	}
+
+	if err := os.Remove(walPath); err != nil {
+		t.Fatal(err)
+	}
+	raw, version, err := provider.GetVersioned(ctx, "fail-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw != nil || version != 0 {
+		t.Errorf("got state %s at version %d after a failed first append, want nil", raw, version)
+	}
+}
+
+func TestWALStateProvider_DropsTruncatedTail(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	provider, err := NewWALStateProvider(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := newWALState("torn-test")
+	for i := 1; i <= 3; i++ {
+		walStep(state, i)
+		if err := provider.Set(ctx, "torn-test", state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Simulate a crash in the middle of appending record 4
+	walPath := filepath.Join(dir, "torn-test.wal")
+	walData, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, whole := decodeRecords(walData)
+	torn := append(walData, 0, 0, 1, 0, 0xde, 0xad, 0xbe, 0xef, '{', '"', 's')
+	if err := os.WriteFile(walPath, torn, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewWALStateProvider(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := NewSessionManager(reopened).Hydrate(ctx, "torn-test")
+	if err != nil {
+		t.Fatalf("Hydrate with torn tail failed: %v", err)
+	}
+	if len(recovered.LogicalFacts) != 3 {
+		t.Fatalf("facts after torn tail: got %d, want 3", len(recovered.LogicalFacts))
+	}
+
+	// The next append overwrites the torn bytes
+	walStep(recovered, 4)
+	if err := reopened.Set(ctx, "torn-test", recovered); err != nil {
+		t.Fatal(err)
+	}
+	walData, _ = os.ReadFile(walPath)
+	records, valid := decodeRecords(walData)
+	if len(records) != 4 || valid != int64(len(walData)) || valid <= whole {
+		t.Errorf("after repair: %d records, %d of %d bytes valid", len(records), valid, len(walData))
+	}
+
+	if err := reopened.Delete(ctx, "torn-test"); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := reopened.Get(ctx, "torn-test"); raw != nil {
+		t.Error("expected nil after delete")
+	}
+}
+
+func TestStepRunner_ReconcilesInterruptedSteps(t *testing.T) {
+	ctx := context.Background()
+	sm := NewSessionManager(NewInMemoryStateProvider())
+	ledger := NewLedger(filepath.Join(t.TempDir(), "ledger.json"))
+
+	var charges, compensations []string
+	steps := []WorkflowStep{
+		{Name: "Validate Inputs", Fact: "step(validate)"},
+		{
+			Name: "Execute Transaction", Fact: "step(execute_txn)",
+			Action: func(ctx context.Context, key string) error {
+				charges = append(charges, key)
+				return ledger.Charge(ctx, key, "TXN-001")
+			},
+			CheckStatus: ledger.Status,
+		},
+		{
+			Name: "Reserve Stock", Fact: "step(reserve)",
+			Action: func(ctx context.Context, key string) error {
+				charges = append(charges, key)
+				return nil
+			},
+			Compensate: func(ctx context.Context, key string) error {
+				compensations = append(compensations, key)
+				return nil
+			},
+		},
+	}
+
+	t.Run("Applied action is marked complete without re-running", func(t *testing.T) {
+		charges = nil
+		state := &core.SessionState{SessionID: "txn-applied"}
+		runner := NewStepRunner(sm)
+		if err := runner.RunStep(ctx, state, 0, steps[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		// Crash after the charge, before the completion checkpoint
+		crashed := &StepRunner{sm: sm, AfterAction: func(int, WorkflowStep) { panic("crash") }}
+		func() {
+			defer func() { recover() }()
+			_ = crashed.RunStep(ctx, state, 1, steps[1])
+		}()
+
+		recovered, err := sm.Hydrate(ctx, "txn-applied")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if intended, completed := journal(recovered); !intended["execute_txn"] || completed["execute_txn"] {
+			t.Fatalf("execute_txn should be journaled as interrupted, got %v", recovered.LogicalFacts)
+		}
+		if err := NewStepRunner(sm).Reconcile(ctx, recovered, steps); err != nil {
+			t.Fatal(err)
+		}
+		if len(charges) != 1 {
+			t.Errorf("transaction charged %d times, want exactly once", len(charges))
+		}
+		if _, completed := journal(recovered); !completed["execute_txn"] {
+			t.Errorf("execute_txn should be complete after reconcile, got %v", recovered.LogicalFacts)
+		}
+		entries, _ := ledger.Entries()
+		if len(entries) != 1 || entries[0].Key != "txn-applied/execute_txn" {
+			t.Errorf("ledger: got %+v", entries)
+		}
+	})
+
+	t.Run("Unapplied action is compensated and re-run with the same key", func(t *testing.T) {
+		charges, compensations = nil, nil
+		state := &core.SessionState{SessionID: "reserve-lost", LogicalFacts: []string{"intent(reserve)"}}
+		if err := sm.Checkpoint(ctx, state); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := NewStepRunner(sm).Reconcile(ctx, state, steps); err != nil {
+			t.Fatal(err)
+		}
+		if len(compensations) != 1 || len(charges) != 1 || compensations[0] != charges[0] {
+			t.Errorf("compensations %v, re-runs %v: want one of each with the same key", compensations, charges)
+		}
+		if _, completed := journal(state); !completed["reserve"] {
+			t.Error("reconciled step should be journaled as complete")
+		}
+	})
+
+	t.Run("Completed steps are not run again", func(t *testing.T) {
+		charges = nil
+		state := &core.SessionState{SessionID: "done", LogicalFacts: []string{"intent(execute_txn)", "step(execute_txn)"}}
+		if err := NewStepRunner(sm).RunStep(ctx, state, 1, steps[1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewStepRunner(sm).Reconcile(ctx, state, steps); err != nil {
+			t.Fatal(err)
+		}
+		if len(charges) != 0 {
+			t.Errorf("completed step re-ran %d times", len(charges))
+		}
+	})
+}
+
+func newWorkflowTestRunner(t *testing.T, sm *SessionManager, steps []WorkflowStep) (*StepRunner, *WorkflowRunner) {
+	t.Helper()
+	ctx := context.Background()
+	client, err := sdk.NewClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Shutdown(ctx) })
+
+	runner := NewStepRunner(sm)
+	workflow, err := NewWorkflowRunner(ctx, client, runner, steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runner, workflow
+}
+
+func TestWorkflowRunner_ResumePointFromFacts(t *testing.T) {
+	steps := []WorkflowStep{
+		{Name: "Initialize Environment", Fact: "step(initialize)"},
+		{Name: "Load Configuration", Fact: "step(load_config)"},
+		{Name: "Validate Inputs", Fact: "step(validate)"},
+	}
+	_, workflow := newWorkflowTestRunner(t, NewSessionManager(NewInMemoryStateProvider()), steps)
+	ctx := context.Background()
+
+	cases := []struct {
+		facts []string
+		want  int
+	}{
+		{nil, 0},
+		{[]string{"intent(initialize)"}, 0},
+		{[]string{"intent(initialize)", "step(initialize)"}, 1},
+		{[]string{"intent(initialize)", "step(initialize)", "intent(load_config)", "step(load_config)"}, 2},
+		{[]string{"step(initialize)", "step(load_config)", "step(validate)"}, 3},
+	}
+	for _, tc := range cases {
+		state := &core.SessionState{SessionID: "resume-point", LogicalFacts: tc.facts}
+		got, err := workflow.ResumePoint(ctx, state)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.facts, err)
+		}
+		if got != tc.want {
+			t.Errorf("%v: resume point %d, want %d", tc.facts, got, tc.want)
+		}
+	}
+}
+
+// TestWorkflowRunner_CrashAtEveryBoundaryConverges crashes the workflow
+// after each step's action and after each step's checkpoint, resumes it
+// with a fresh runner, and checks every run ends in the same state.
+func TestWorkflowRunner_CrashAtEveryBoundaryConverges(t *testing.T) {
+	ctx := context.Background()
+
+	newSteps := func(ledger *Ledger) []WorkflowStep {
+		return []WorkflowStep{
+			{Name: "Initialize Environment", Fact: "step(initialize)", Metadata: map[string]any{"env": "production"}},
+			{Name: "Load Configuration", Fact: "step(load_config)", Metadata: map[string]any{"config_version": "2.1"}},
+			{Name: "Validate Inputs", Fact: "step(validate)", Metadata: map[string]any{"validated": true}},
+			{
+				Name: "Execute Transaction", Fact: "step(execute_txn)", Metadata: map[string]any{"txn_id": "TXN-001"},
+				Action: func(ctx context.Context, key string) error {
+					return ledger.Charge(ctx, key, "TXN-001")
+				},
+				CheckStatus: ledger.Status,
+			},
+			{Name: "Finalize and Report", Fact: "step(finalize)", Metadata: map[string]any{"report": "complete"}},
+		}
+	}
+	newState := func() *core.SessionState {
+		return &core.SessionState{
+			SessionID:      "converge",
+			ActiveEnvelope: core.Envelope{ID: uuid.New(), ContentType: core.TypeJSON, Metadata: map[string]any{}},
+		}
+	}
+
+	// Reference run without a crash
+	refLedger := NewLedger(filepath.Join(t.TempDir(), "ledger.json"))
+	refSteps := newSteps(refLedger)
+	_, refWorkflow := newWorkflowTestRunner(t, NewSessionManager(NewInMemoryStateProvider()), refSteps)
+	want := newState()
+	if err := refWorkflow.Run(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+
+	type crashPoint struct {
+		step     int
+		midStep  bool
+		describe string
+	}
+	var points []crashPoint
+	for i := range refSteps {
+		points = append(points,
+			crashPoint{i, true, fmt.Sprintf("after action of step %d", i+1)},
+			crashPoint{i, false, fmt.Sprintf("after checkpoint of step %d", i+1)},
+		)
+	}
+
+	for _, cp := range points {
+		t.Run(cp.describe, func(t *testing.T) {
+			provider := NewInMemoryStateProvider()
+			ledger := NewLedger(filepath.Join(t.TempDir(), "ledger.json"))
+			steps := newSteps(ledger)
+
+			sm := NewSessionManager(provider)
+			runner, workflow := newWorkflowTestRunner(t, sm, steps)
+			crash := func(i int, _ WorkflowStep) {
+				if i == cp.step {
+					panic("crash")
+				}
+			}
+			if cp.midStep {
+				runner.AfterAction = crash
+			} else {
+				runner.AfterCheckpoint = crash
+			}
+			func() {
+				defer func() { recover() }()
+				_ = workflow.Run(ctx, newState())
+			}()
+
+			// A new process: fresh manager, runner and client on the same state
+			sm2 := NewSessionManager(provider)
+			_, workflow2 := newWorkflowTestRunner(t, sm2, steps)
+			recovered, err := sm2.Hydrate(ctx, "converge")
+			if err != nil || recovered == nil {
+				t.Fatalf("Hydrate: %v, %v", recovered, err)
+			}
+			if err := workflow2.Run(ctx, recovered); err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(recovered.LogicalFacts, want.LogicalFacts) {
+				t.Errorf("facts:\n got %q\nwant %q", recovered.LogicalFacts, want.LogicalFacts)
+			}
+			got, wantHistory := recovered.ExecutionCtx.CurrentHistory, want.ExecutionCtx.CurrentHistory
+			if len(got) != len(wantHistory) {
+				t.Errorf("history: got %d messages, want %d", len(got), len(wantHistory))
+			}
+			for i := range min(len(got), len(wantHistory)) {
+				if got[i].Role != wantHistory[i].Role || got[i].Content != wantHistory[i].Content {
+					t.Errorf("history[%d]: got %s %q, want %s %q", i, got[i].Role, got[i].Content, wantHistory[i].Role, wantHistory[i].Content)
+				}
+			}
+			for k, v := range want.ActiveEnvelope.Metadata {
+				if got := recovered.ActiveEnvelope.Metadata[k]; fmt.Sprint(got) != fmt.Sprint(v) {
+					t.Errorf("metadata %s: got %v, want %v", k, got, v)
+				}
+			}
+			if entries, _ := ledger.Entries(); len(entries) != 1 {
+				t.Errorf("ledger has %d transactions, want exactly 1", len(entries))
+			}
+		})
+	}
+}
+
+func TestSessionManager_DetectsConcurrentCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	provider := NewInMemoryStateProvider()
+
+	seed := &core.SessionState{SessionID: "cas-test", LogicalFacts: []string{"step(init)"}}
+	if err := NewSessionManager(provider).Checkpoint(ctx, seed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two clients hydrate the same version
+	smA, smB := NewSessionManager(provider), NewSessionManager(provider)
+	a, _ := smA.Hydrate(ctx, "cas-test")
+	b, _ := smB.Hydrate(ctx, "cas-test")
+
+	a.LogicalFacts = append(a.LogicalFacts, "step(a)")
+	if err := smA.Checkpoint(ctx, a); err != nil {
+		t.Fatalf("first writer failed: %v", err)
+	}
+
+	b.LogicalFacts = append(b.LogicalFacts, "step(b)")
+	err := smB.Checkpoint(ctx, b)
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("second writer: got %v, want a *VersionConflictError", err)
+	}
+	if conflict.Expected != 1 || conflict.Actual != 2 {
+		t.Errorf("conflict versions: got expected=%d actual=%d, want 1 and 2", conflict.Expected, conflict.Actual)
+	}
+
+	// With the merge strategy the second writer folds in the first's facts
+	smB.OnConflict = ConflictMergeFacts
+	if err := smB.Checkpoint(ctx, b); err != nil {
+		t.Fatalf("merge checkpoint failed: %v", err)
+	}
+	final, _ := NewSessionManager(provider).Hydrate(ctx, "cas-test")
+	want := []string{"step(init)", "step(a)", "step(b)"}
+	if fmt.Sprint(final.LogicalFacts) != fmt.Sprint(want) {
+		t.Errorf("merged facts: got %v, want %v", final.LogicalFacts, want)
+	}
+
+	// A fresh state may not overwrite an existing session
+	if err := NewSessionManager(provider).Checkpoint(ctx, &core.SessionState{SessionID: "cas-test"}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("blind overwrite of existing session: got %v, want conflict", err)
+	}
+}
+
+// TestSessionManager_TracksVersionsBySession checks that versions follow
+// the session rather than the state value, are dropped with the session,
+// and that a manager never merges into a session it did not hydrate.
+func TestSessionManager_TracksVersionsBySession(t *testing.T) {
+	ctx := context.Background()
+	provider := NewInMemoryStateProvider()
+	sm := NewSessionManager(provider)
+	sm.OnConflict = ConflictMergeFacts
+
+	if err := sm.Checkpoint(ctx, &core.SessionState{SessionID: "by-id", LogicalFacts: []string{"step(init)"}}); err != nil {
+		t.Fatal(err)
+	}
+	// A copy of the hydrated state checkpoints on the same version
+	state, _ := sm.Hydrate(ctx, "by-id")
+	clone := *state
+	clone.LogicalFacts = append(clone.LogicalFacts, "step(next)")
+	if err := NewSessionManager(provider).Checkpoint(ctx, &clone); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("checkpoint by a manager that never hydrated the session: got %v, want conflict", err)
+	}
+	if err := sm.Checkpoint(ctx, &clone); err != nil {
+		t.Fatalf("checkpoint of a copy: %v", err)
+	}
+	if got, _ := sm.Hydrate(ctx, "by-id"); fmt.Sprint(got.LogicalFacts) != "[step(init) step(next)]" {
+		t.Errorf("facts: got %v", got.LogicalFacts)
+	}
+	if len(sm.versions) != 1 {
+		t.Errorf("tracked versions after two hydrates: got %d, want 1", len(sm.versions))
+	}
+
+	if err := sm.Delete(ctx, "by-id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(sm.versions) != 0 {
+		t.Errorf("tracked versions after delete: got %d, want 0", len(sm.versions))
+	}
+}
+
+// TestSessionManager_ConcurrentWritersStress runs many clients against one
+// session on every versioned provider; no writer's fact may be lost.
+func TestSessionManager_ConcurrentWritersStress(t *testing.T) {
+	ctx := context.Background()
+	providers := map[string]func(t *testing.T) VersionedStateProvider{
+		"memory": func(t *testing.T) VersionedStateProvider { return NewInMemoryStateProvider() },
+		"file": func(t *testing.T) VersionedStateProvider {
+			p, err := NewFileStateProvider(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p
+		},
+		"wal": func(t *testing.T) VersionedStateProvider {
+			p, err := NewWALStateProvider(t.TempDir(), 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p
+		},
+	}
+
+	for name, newProvider := range providers {
+		t.Run(name, func(t *testing.T) {
+			provider := newProvider(t)
+			seed := &core.SessionState{SessionID: "stress", ActiveEnvelope: core.Envelope{ID: uuid.New(), ContentType: core.TypeJSON}}
+			if err := NewSessionManager(provider).Checkpoint(ctx, seed); err != nil {
+				t.Fatal(err)
+			}
+
+			const writers = 32
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(idx int) {
+					defer wg.Done()
+					sm := NewSessionManager(provider)
+					sm.OnConflict = ConflictMergeFacts
+					sm.MaxMergeRetries = 1000
+
+					state, err := sm.Hydrate(ctx, "stress")
+					if err != nil || state == nil {
+						t.Errorf("writer %d: hydrate: %v", idx, err)
+						return
+					}
+					state.LogicalFacts = append(state.LogicalFacts, fmt.Sprintf("writer(%d)", idx))
+					if err := sm.Checkpoint(ctx, state); err != nil {
+						t.Errorf("writer %d: checkpoint: %v", idx, err)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			final, err := NewSessionManager(provider).Hydrate(ctx, "stress")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(final.LogicalFacts) != writers {
+				t.Errorf("got %d facts, want %d (lost updates)", len(final.LogicalFacts), writers)
+			}
+			_, version, _ := provider.GetVersioned(ctx, "stress")
+			if version < writers+1 {
+				t.Errorf("version %d, want at least %d", version, writers+1)
+			}
+		})
+	}
+}
+
+// fakeClock is a Clock that only moves when the test advances it.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type clockedStore interface {
+	SessionStore
+	SetClock(Clock)
+}
+
+func sessionStores() map[string]func(t *testing.T) clockedStore {
+	return map[string]func(t *testing.T) clockedStore{
+		"memory": func(t *testing.T) clockedStore { return NewInMemoryStateProvider() },
+		"file": func(t *testing.T) clockedStore {
+			p, err := NewFileStateProvider(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p
+		},
+		"wal": func(t *testing.T) clockedStore {
+			p, err := NewWALStateProvider(t.TempDir(), 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p
+		},
+	}
+}
+
+func sessionIDs(infos []SessionInfo) []string {
+	ids := make([]string, len(infos))
+	for i, info := range infos {
+		ids[i] = info.SessionID
+	}
+	return ids
+}
+
+func TestSessionStore_ListFilters(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for name, newStore := range sessionStores() {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			clock := &fakeClock{now: start}
+			store.SetClock(clock)
+
+			// order-1 at 0h, order-2 at 1h, batch-1 at 2h; order-1 rewritten at 3h
+			for _, id := range []string{"order-1", "order-2", "batch-1"} {
+				if err := store.Set(ctx, id, newWALState(id)); err != nil {
+					t.Fatal(err)
+				}
+				clock.Advance(time.Hour)
+			}
+			state := newWALState("order-1")
+			walStep(state, 0)
+			if err := store.Set(ctx, "order-1", state); err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name   string
+				filter SessionFilter
+				want   []string
+			}{
+				{"all", SessionFilter{}, []string{"batch-1", "order-1", "order-2"}},
+				{"prefix", SessionFilter{Prefix: "order-"}, []string{"order-1", "order-2"}},
+				{"created after", SessionFilter{CreatedAfter: start.Add(30 * time.Minute)}, []string{"batch-1", "order-2"}},
+				{"created before", SessionFilter{CreatedBefore: start.Add(90 * time.Minute)}, []string{"order-1", "order-2"}},
+				{"updated after", SessionFilter{UpdatedAfter: start.Add(150 * time.Minute)}, []string{"order-1"}},
+				{"prefix and updated before", SessionFilter{Prefix: "order-", UpdatedBefore: start.Add(150 * time.Minute)}, []string{"order-2"}},
+			}
+			for _, tt := range tests {
+				infos, err := store.List(ctx, tt.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := sessionIDs(infos); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+					t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				}
+			}
+
+			infos, _ := store.List(ctx, SessionFilter{Prefix: "order-1"})
+			if len(infos) != 1 || !infos[0].Created.Equal(start) || !infos[0].Updated.Equal(start.Add(3*time.Hour)) || infos[0].Version != 2 {
+				t.Errorf("order-1 info: got %+v", infos)
+			}
+		})
+	}
+}
+
+func TestReaper_EvictsExpiredSessions(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newStore := range sessionStores() {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+			store.SetClock(clock)
+
+			for _, id := range []string{"short", "long", "forever"} {
+				if err := store.Set(ctx, id, newWALState(id)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.SetTTL(ctx, "short", time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetTTL(ctx, "long", time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetTTL(ctx, "missing", time.Hour); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("SetTTL on missing session: got %v, want ErrSessionNotFound", err)
+			}
+
+			var reported []string
+			reaper := &Reaper{Store: store, Clock: clock, OnReap: func(removed []SessionInfo) {
+				reported = append(reported, sessionIDs(removed)...)
+			}}
+
+			clock.Advance(59 * time.Second)
+			if removed, err := reaper.RunOnce(ctx); err != nil || len(removed) != 0 {
+				t.Fatalf("before expiry: removed %v, err %v", sessionIDs(removed), err)
+			}
+
+			clock.Advance(time.Second)
+			removed, err := reaper.RunOnce(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sessionIDs(removed); fmt.Sprint(got) != "[short]" {
+				t.Errorf("after a minute: removed %v, want [short]", got)
+			}
+			if raw, _ := store.Get(ctx, "short"); raw != nil {
+				t.Error("evicted session is still readable")
+			}
+
+			// A write resets the idle time, so "long" survives its original deadline
+			clock.Advance(30 * time.Minute)
+			state := newWALState("long")
+			walStep(state, 0)
+			if err := store.Set(ctx, "long", state); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(45 * time.Minute)
+			if removed, _ := reaper.RunOnce(ctx); len(removed) != 0 {
+				t.Errorf("written session evicted early: %v", sessionIDs(removed))
+			}
+			clock.Advance(15 * time.Minute)
+			if removed, _ := reaper.RunOnce(ctx); fmt.Sprint(sessionIDs(removed)) != "[long]" {
+				t.Errorf("after idle hour: removed %v, want [long]", sessionIDs(removed))
+			}
+
+			if fmt.Sprint(reported) != "[short long]" {
+				t.Errorf("OnReap reported %v, want [short long]", reported)
+			}
+			infos, _ := store.List(ctx, SessionFilter{})
+			if got := sessionIDs(infos); fmt.Sprint(got) != "[forever]" {
+				t.Errorf("remaining sessions: got %v, want [forever]", got)
+			}
+		})
+	}
+}
+
+func TestReaper_StartEvictsInBackground(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryStateProvider()
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store.SetClock(clock)
+
+	if err := store.Set(ctx, "idle", newWALState("idle")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetTTL(ctx, "idle", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+
+	reaped := make(chan []SessionInfo, 1)
+	reaper := &Reaper{Store: store, Clock: clock, Interval: time.Millisecond, OnReap: func(removed []SessionInfo) {
+		reaped <- removed
+	}}
+	stop := reaper.Start(ctx)
+	defer stop()
+
+	select {
+	case removed := <-reaped:
+		if fmt.Sprint(sessionIDs(removed)) != "[idle]" {
+			t.Errorf("removed %v, want [idle]", sessionIDs(removed))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("background reaper never evicted the session")
+	}
+}
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestEncryptedStateProvider_SealsAndRotates(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	inner, err := NewFileStateProvider(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewStaticKeyring("k1", testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := NewEncryptedStateProvider(inner, keys)
+	sm := NewSessionManager(provider)
+
+	state := newWALState("sealed")
+	state.ActiveEnvelope.Payload = map[string]any{"customer": "alice@example.com"}
+	walStep(state, 0)
+	if err := sm.Checkpoint(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+
+	onDisk, err := os.ReadFile(filepath.Join(dir, "sealed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"alice@example.com", "step(0)"} {
+		if bytes.Contains(onDisk, []byte(secret)) {
+			t.Errorf("checkpoint on disk contains %q in plaintext", secret)
+		}
+	}
+
+	recovered, err := NewSessionManager(provider).Hydrate(ctx, "sealed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(recovered.LogicalFacts) != "[step(0)]" || recovered.ActiveEnvelope.Payload.(map[string]any)["customer"] != "alice@example.com" {
+		t.Errorf("recovered state does not match: %+v", recovered)
+	}
+
+	// After rotation the old session still opens and is re-sealed on write
+	if err := keys.Rotate("k2", testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	if keyID, _ := provider.KeyID(ctx, "sealed"); keyID != "k1" {
+		t.Errorf("before rewrite: sealed under %q, want k1", keyID)
+	}
+	walStep(state, 1)
+	if err := sm.Checkpoint(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+	if keyID, _ := provider.KeyID(ctx, "sealed"); keyID != "k2" {
+		t.Errorf("after rewrite: sealed under %q, want k2", keyID)
+	}
+
+	// Once k1 is retired the re-sealed session still opens
+	newKeys, _ := NewStaticKeyring("k2", testKey(2))
+	if _, err := NewSessionManager(NewEncryptedStateProvider(inner, newKeys)).Hydrate(ctx, "sealed"); err != nil {
+		t.Errorf("hydrate with only the current key: %v", err)
+	}
+}
+
+func TestEncryptedStateProvider_FailsClosedOnTampering(t *testing.T) {
+	ctx := context.Background()
+	keys, _ := NewStaticKeyring("k1", testKey(1))
+
+	seal := func(t *testing.T) (*InMemoryStateProvider, *EncryptedStateProvider) {
+		inner := NewInMemoryStateProvider()
+		provider := NewEncryptedStateProvider(inner, keys)
+		if err := provider.Set(ctx, "victim", newWALState("victim")); err != nil {
+			t.Fatal(err)
+		}
+		return inner, provider
+	}
+	// reseal rewrites a stored carrier's sealed payload in place
+	reseal := func(t *testing.T, inner *InMemoryStateProvider, from, to string, edit func(*sealedPayload)) {
+		raw, _ := inner.Get(ctx, from)
+		carrier, err := decodeState(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(carrier.ActiveEnvelope.Payload)
+		var sealed sealedPayload
+		if err := json.Unmarshal(data, &sealed); err != nil {
+			t.Fatal(err)
+		}
+		edit(&sealed)
+		carrier.SessionID = to
+		carrier.ActiveEnvelope.Payload = sealed
+		if err := inner.Set(ctx, to, carrier); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, inner *InMemoryStateProvider)
+	}{
+		{"flipped ciphertext byte", func(t *testing.T, inner *InMemoryStateProvider) {
+			reseal(t, inner, "victim", "victim", func(sealed *sealedPayload) {
+				ct, _ := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+				ct[0] ^= 0xff
+				sealed.Ciphertext = base64.StdEncoding.EncodeToString(ct)
+			})
+		}},
+		{"relabelled key", func(t *testing.T, inner *InMemoryStateProvider) {
+			_ = keys.Add("k0", testKey(1))
+			reseal(t, inner, "victim", "victim", func(sealed *sealedPayload) { sealed.KeyID = "k0" })
+		}},
+		{"plaintext substituted", func(t *testing.T, inner *InMemoryStateProvider) {
+			_ = inner.Set(ctx, "victim", &core.SessionState{SessionID: "victim", LogicalFacts: []string{"step(forged)"}})
+		}},
+		{"sealed state from another session", func(t *testing.T, inner *InMemoryStateProvider) {
+			_ = NewEncryptedStateProvider(inner, keys).Set(ctx, "other", newWALState("other"))
+			reseal(t, inner, "other", "victim", func(*sealedPayload) {})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner, provider := seal(t)
+			tt.tamper(t, inner)
+
+			state, err := NewSessionManager(provider).Hydrate(ctx, "victim")
+			if !errors.Is(err, ErrStateTampered) {
+				t.Errorf("got %v, want ErrStateTampered", err)
+			}
+			if state != nil {
+				t.Errorf("tampered session returned state %+v", state)
+			}
+		})
+	}
+
+	inner, _ := seal(t)
+	other, _ := NewStaticKeyring("k9", testKey(9))
+	if _, err := NewEncryptedStateProvider(inner, other).Get(ctx, "victim"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key: got %v, want ErrUnknownKey", err)
+	}
+}
+
+// installFixture copies a golden checkpoint from testdata/schema into dir
+// under the name a provider expects.
+func installFixture(t *testing.T, fixture, dir, name string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "schema", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storedSchemaVersion reads the schema_version a file checkpoint was
+// written with, or 1 for a bare state.
+func storedSchemaVersion(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec fileRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	version, _, err := splitEnvelope(rec.State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestSchemaMigration_HydratesGoldenCheckpoints(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("file checkpoints", func(t *testing.T) {
+		tests := []struct {
+			fixture   string
+			sessionID string
+			facts     int
+			history   int
+		}{
+			{"v1_file_checkpoint.json", "legacy-order-7", 4, 2},
+			{"v2_file_checkpoint.json", "order-9", 6, 3},
+		}
+		for _, tt := range tests {
+			t.Run(tt.fixture, func(t *testing.T) {
+				dir := t.TempDir()
+				installFixture(t, tt.fixture, dir, tt.sessionID+".json")
+				provider, err := NewFileStateProvider(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sm := NewSessionManager(provider)
+
+				state, err := sm.Hydrate(ctx, tt.sessionID)
+				if err != nil {
+					t.Fatalf("hydrate: %v", err)
+				}
+				if state.SessionID != tt.sessionID || len(state.LogicalFacts) != tt.facts || len(state.ExecutionCtx.CurrentHistory) != tt.history {
+					t.Errorf("got session %q with %d facts and %d messages, want %q, %d and %d",
+						state.SessionID, len(state.LogicalFacts), len(state.ExecutionCtx.CurrentHistory), tt.sessionID, tt.facts, tt.history)
+				}
+				if state.ActiveEnvelope.Metadata["env"] != "production" {
+					t.Errorf("metadata lost: %v", state.ActiveEnvelope.Metadata)
+				}
+
+				// The next checkpoint rewrites it under the current schema
+				executeStep(state, WorkflowStep{Name: "Execute Transaction", Fact: "step(execute_txn)"})
+				if err := sm.Checkpoint(ctx, state); err != nil {
+					t.Fatal(err)
+				}
+				if v := storedSchemaVersion(t, filepath.Join(dir, tt.sessionID+".json")); v != CurrentSchemaVersion {
+					t.Errorf("rewritten checkpoint has schema v%d, want v%d", v, CurrentSchemaVersion)
+				}
+			})
+		}
+	})
+
+	t.Run("wal snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		installFixture(t, "v1_wal.snap", dir, "legacy-order-8.snap")
+		provider, err := NewWALStateProvider(dir, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm := NewSessionManager(provider)
+
+		state, err := sm.Hydrate(ctx, "legacy-order-8")
+		if err != nil {
+			t.Fatalf("hydrate: %v", err)
+		}
+		if fmt.Sprint(state.LogicalFacts) != "[intent(initialize) step(initialize)]" {
+			t.Errorf("facts: got %v", state.LogicalFacts)
+		}
+
+		executeStep(state, WorkflowStep{Name: "Load Configuration", Fact: "step(load_config)"})
+		if err := sm.Checkpoint(ctx, state); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "legacy-order-8.snap"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var snap walSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			t.Fatal(err)
+		}
+		if v, _, _ := splitEnvelope(snap.State); v != CurrentSchemaVersion {
+			t.Errorf("rewritten snapshot has schema v%d, want v%d", v, CurrentSchemaVersion)
+		}
+	})
+
+	t.Run("newer schema fails", func(t *testing.T) {
+		dir := t.TempDir()
+		installFixture(t, "v3_file_checkpoint.json", dir, "future-order.json")
+		provider, err := NewFileStateProvider(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewSessionManager(provider).Hydrate(ctx, "future-order"); !errors.Is(err, ErrSchemaTooNew) {
+			t.Errorf("got %v, want ErrSchemaTooNew", err)
+		}
+	})
+}
+
+// TestMigrationRegistry_UpgradesStepByStep chains migrations through a
+// registry with a made-up history: v1 called the facts "facts_log", v2
+// renamed them, and v3 started journaling a marker fact.
+func TestMigrationRegistry_UpgradesStepByStep(t *testing.T) {
+	registry := NewMigrationRegistry(3)
+	var applied []int
+	registry.Register(1, func(doc map[string]any) (map[string]any, error) {
+		applied = append(applied, 1)
+		doc["logical_facts"] = doc["facts_log"]
+		delete(doc, "facts_log")
+		return doc, nil
+	})
+	registry.Register(2, func(doc map[string]any) (map[string]any, error) {
+		applied = append(applied, 2)
+		facts, _ := doc["logical_facts"].([]any)
+		doc["logical_facts"] = append(facts, "schema(v3)")
+		return doc, nil
+	})
+
+	v1 := []byte(`{"session_id": "s", "facts_log": ["step(initialize)"]}`)
+	state, err := registry.Upgrade(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(applied) != "[1 2]" {
+		t.Errorf("applied migrations %v, want [1 2]", applied)
+	}
+	if fmt.Sprint(state.LogicalFacts) != "[step(initialize) schema(v3)]" {
+		t.Errorf("facts: got %v", state.LogicalFacts)
+	}
+
+	// A v2 envelope only runs the v2 → v3 step
+	applied = nil
+	v2 := []byte(`{"schema_version": 2, "state": {"session_id": "s", "logical_facts": ["step(initialize)"]}}`)
+	if _, err := registry.Upgrade(v2); err != nil || fmt.Sprint(applied) != "[2]" {
+		t.Errorf("v2: applied %v, err %v", applied, err)
+	}
+
+	// Without the rename, the old field would be silently dropped
+	partial := NewMigrationRegistry(2)
+	partial.Register(1, func(doc map[string]any) (map[string]any, error) { return doc, nil })
+	if _, err := partial.Upgrade(v1); err == nil {
+		t.Error("upgrade that drops facts_log should fail")
+	}
+
+	if _, err := NewMigrationRegistry(3).Upgrade(v1); err == nil {
+		t.Error("upgrade with no registered migrations should fail")
+	}
+}
+
+// recordingLoader is a FactLoader that remembers what it was given.
+type recordingLoader struct{ facts []string }
+
+func (l *recordingLoader) LoadFacts(facts []string) error {
+	l.facts = append(l.facts, facts...)
+	return nil
+}
+
+// echoLLM replies with the prompt it received.
+type echoLLM struct{}
+
+func (echoLLM) Complete(ctx context.Context, prompt string) (string, error) { return prompt, nil }
+
+func (echoLLM) Generate(ctx context.Context, prompt string, opts ...core.GenerateOption) (*core.LLMResponse, error) {
+	return &core.LLMResponse{Text: prompt}, nil
+}
+
+func (echoLLM) Stream(ctx context.Context, prompt string) (<-chan core.StreamChunk, error) {
+	ch := make(chan core.StreamChunk)
+	close(ch)
+	return ch, nil
+}
+
+func TestEngineFacts(t *testing.T) {
+	got := engineFacts([]string{"step(initialize)", "intent(execute_txn)", `owner("alice", "doc")`, "flag(/ready)"})
+	want := []string{`step("initialize")`, `intent("execute_txn")`, `owner("alice", "doc")`, "flag(/ready)"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestStepRunner_LoadsJournalFactsIntoEngine(t *testing.T) {
+	ctx := context.Background()
+	loader := &recordingLoader{}
+	runner := NewStepRunner(NewSessionManager(NewInMemoryStateProvider()))
+	runner.Facts = loader
+
+	state := &core.SessionState{SessionID: "engine-facts"}
+	for i, step := range []WorkflowStep{
+		{Name: "Validate Inputs", Fact: "step(validate)"},
+		{Name: "Execute Transaction", Fact: "step(execute_txn)"},
+	} {
+		if err := runner.RunStep(ctx, state, i, step); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The engine sees exactly the session's journal, in Datalog form
+	if fmt.Sprint(loader.facts) != fmt.Sprint(engineFacts(state.LogicalFacts)) {
+		t.Errorf("engine got %v, session journal is %v", loader.facts, state.LogicalFacts)
+	}
+}
+
+func TestHistoryLLM_ContinuesRecoveredConversation(t *testing.T) {
+	ctx := context.Background()
+	llm := NewHistoryLLM(echoLLM{}, []core.Message{
+		{Role: "system", Content: "Executed: Initialize Environment"},
+		{Role: "system", Content: "Executed: Load Configuration"},
+	})
+
+	reply, err := llm.Complete(ctx, "What ran?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "system: Executed: Initialize Environment\nsystem: Executed: Load Configuration\nuser: What ran?"
+	if reply != want {
+		t.Errorf("prompt sent to LLM:\n%s\nwant:\n%s", reply, want)
+	}
+
+	if _, err := llm.Generate(ctx, "And next?"); err != nil {
+		t.Fatal(err)
+	}
+	msgs := llm.Messages()
+	if len(msgs) != 6 || msgs[2].Content != "What ran?" || msgs[3].Role != "assistant" || msgs[4].Content != "And next?" {
+		t.Errorf("history after two exchanges: %+v", msgs)
+	}
+}
+
+// TestResumeSession_PolicySeesRecoveredFacts crashes a workflow before
+// its transaction, resumes it on a fresh client and checks that the
+// session policy evaluates the same facts there as on the original one.
+func TestResumeSession_PolicySeesRecoveredFacts(t *testing.T) {
+	ctx := context.Background()
+	provider := NewInMemoryStateProvider()
+	steps := []WorkflowStep{
+		{Name: "Validate Inputs", Fact: "step(validate)"},
+		{Name: "Execute Transaction", Fact: "step(execute_txn)"},
+	}
+
+	newClient := func(t *testing.T) *sdk.Client {
+		client, err := sdk.NewClient(ctx, sdk.WithStateProvider(provider))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Shutdown(ctx) })
+		if err := LoadSessionPolicy(ctx, client); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	before := newClient(t)
+	runner := NewStepRunner(NewSessionManager(provider))
+	runner.Facts = before
+	state := &core.SessionState{SessionID: "resume-policy"}
+	for i, step := range steps {
+		if err := runner.RunStep(ctx, state, i, step); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := MayRun(ctx, before, "finalize"); err != nil || !ok {
+		t.Fatalf("before the crash: may_run(finalize) = %v, %v", ok, err)
+	}
+
+	after := newClient(t)
+	if ok, _ := MayRun(ctx, after, "finalize"); ok {
+		t.Fatal("a fresh client should not know the session's facts yet")
+	}
+	resumed, err := ResumeSession(ctx, after, NewSessionManager(provider), "resume-policy", echoLLM{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := MayRun(ctx, after, "finalize"); err != nil || !ok {
+		t.Errorf("after resume: may_run(finalize) = %v, %v", ok, err)
+	}
+	if len(resumed.LLM.Messages()) != len(state.ExecutionCtx.CurrentHistory) {
+		t.Errorf("LLM history: got %d messages, want %d", len(resumed.LLM.Messages()), len(state.ExecutionCtx.CurrentHistory))
+	}
+}
+
+// TestCrashHarness_EveryInjectionConverges runs the five-step workflow
+// under every injection point on every provider. Each run must end with
+// every step journaled exactly once, in order, and one ledger charge.
+func TestCrashHarness_EveryInjectionConverges(t *testing.T) {
+	ctx := context.Background()
+	for name, newStore := range sessionStores() {
+		t.Run(name, func(t *testing.T) {
+			for _, injection := range AllInjections(5) {
+				t.Run(injection.String(), func(t *testing.T) {
+					ledger := NewLedger(filepath.Join(t.TempDir(), "ledger.json"))
+					harness := &CrashHarness{
+						Steps: []WorkflowStep{
+							{Name: "Initialize Environment", Fact: "step(initialize)"},
+							{Name: "Load Configuration", Fact: "step(load_config)"},
+							{Name: "Validate Inputs", Fact: "step(validate)"},
+							{
+								Name: "Execute Transaction", Fact: "step(execute_txn)",
+								Action: func(ctx context.Context, key string) error {
+									return ledger.Charge(ctx, key, "TXN-001")
+								},
+								CheckStatus: ledger.Status,
+							},
+							{Name: "Finalize and Report", Fact: "step(finalize)"},
+						},
+						NewState: newWALState,
+					}
+
+					result, err := harness.RunWith(ctx, newStore(t), "harness", injection)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !result.Fired {
+						t.Errorf("%s never fired", injection)
+					}
+					if result.Restarts == 0 && injection.Fault != FaultDuplicateDelivery {
+						t.Errorf("%s: expected at least one restart", injection)
+					}
+					entries, err := ledger.Entries()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(entries) != 1 {
+						t.Errorf("%s: ledger has %d charges, want 1", injection, len(entries))
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestCheckJournal_RejectsBrokenJournals(t *testing.T) {
+	steps := []WorkflowStep{{Fact: "step(a)"}, {Fact: "step(b)"}}
+	tests := []struct {
+		name  string
+		facts []string
+		ok    bool
+	}{
+		{"complete", []string{"intent(a)", "step(a)", "intent(b)", "step(b)"}, true},
+		{"missing step", []string{"intent(a)", "step(a)", "intent(b)"}, false},
+		{"duplicated step", []string{"intent(a)", "step(a)", "step(a)", "intent(b)", "step(b)"}, false},
+		{"out of order", []string{"intent(b)", "step(b)", "intent(a)", "step(a)"}, false},
+		{"completion without intent", []string{"step(a)", "intent(b)", "step(b)"}, false},
+	}
+	for _, tt := range tests {
+		err := CheckJournal(&core.SessionState{SessionID: "j", LogicalFacts: tt.facts}, steps)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/duynguyendang/manglekit/core"
+	"github.com/duynguyendang/manglekit/sdk"
+)
+
+// --- Reattaching a recovered session to a new client ---
+//
+// A checkpoint only helps if the process that resumes it sees the same
+// world as the one that crashed. ResumeSession hydrates a session and
+// hands it back to the client: the recovered LogicalFacts are loaded into
+// the client's policy engine, and the ExecutionCtx history becomes the
+// conversation the client's LLM continues from. While the workflow runs,
+// StepRunner loads every journal fact into the engine as it is
+// checkpointed, so policies see the same facts before and after a crash.
+
+// FactLoader receives facts for the policy engine; *sdk.Client is one.
+type FactLoader interface {
+	LoadFacts(facts []string) error
+}
+
+// bareArgFact matches journal entries such as step(initialize), whose
+// argument is an identifier rather than a Datalog constant.
+var bareArgFact = regexp.MustCompile(`^([a-z_][a-zA-Z0-9_]*)\(([a-z_][a-zA-Z0-9_]*)\)$`)
+
+// engineFacts renders LogicalFacts as Datalog: journal entries get their
+// argument quoted, step(initialize) becoming step("initialize"); anything
+// else is passed through unchanged.
+func engineFacts(facts []string) []string {
+	out := make([]string, len(facts))
+	for i, f := range facts {
+		if m := bareArgFact.FindStringSubmatch(f); m != nil {
+			out[i] = fmt.Sprintf("%s(%q)", m[1], m[2])
+		} else {
+			out[i] = f
+		}
+	}
+	return out
+}
+
+// LoadSessionPolicy loads session_policy.dl, the rules evaluated against
+// the facts a session has loaded into the client's engine.
+func LoadSessionPolicy(ctx context.Context, client *sdk.Client) error {
+	policy, err := os.ReadFile(filepath.Join(exampleDir(), "session_policy.dl"))
+	if err != nil {
+		return fmt.Errorf("failed to read session_policy.dl: %w", err)
+	}
+	if err := client.Engine().LoadPolicy(ctx, string(policy)); err != nil {
+		return fmt.Errorf("failed to load session policy: %w", err)
+	}
+	return nil
+}
+
+// MayRun reports whether session_policy.dl allows stepID given the facts
+// loaded into the client's engine.
+func MayRun(ctx context.Context, client *sdk.Client, stepID string) (bool, error) {
+	solutions, err := client.Engine().Query(ctx, nil, `may_run(S)`)
+	if err != nil {
+		return false, fmt.Errorf("query may_run failed: %w", err)
+	}
+	for _, sol := range solutions {
+		if strings.Trim(sol["S"], `"`) == stepID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ResumedSession is a session reattached to a client.
+type ResumedSession struct {
+	State *core.SessionState
+	// LLM carries the recovered history; nil if no LLM was given.
+	LLM *HistoryLLM
+}
+
+// ResumeSession hydrates sessionID and reattaches it to client. Its
+// LogicalFacts are loaded into the client's engine and, when llm is set,
+// the client's LLM is replaced by a HistoryLLM around it seeded with the
+// session's history. It returns nil if the session has no checkpoint.
+func ResumeSession(ctx context.Context, client *sdk.Client, sm *SessionManager, sessionID string, llm core.TextGenerator) (*ResumedSession, error) {
+	state, err := sm.Hydrate(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hydrate session: %w", err)
+	}
+	if state == nil {
+		return nil, nil
+	}
+
+	if len(state.LogicalFacts) > 0 {
+		if err := client.LoadFacts(engineFacts(state.LogicalFacts)); err != nil {
+			return nil, fmt.Errorf("failed to load recovered facts: %w", err)
+		}
+	}
+
+	resumed := &ResumedSession{State: state}
+	if llm != nil {
+		resumed.LLM = NewHistoryLLM(llm, state.ExecutionCtx.CurrentHistory)
+		client.SetLLM(resumed.LLM)
+	}
+	return resumed, nil
+}
+
+// HistoryLLM gives a TextGenerator a conversation: every prompt is sent
+// after the history so far, and each completed exchange is added to it.
+// Streamed replies are not recorded.
+type HistoryLLM struct {
+	inner core.TextGenerator
+
+	mu      sync.Mutex
+	history []core.Message
+}
+
+func NewHistoryLLM(inner core.TextGenerator, history []core.Message) *HistoryLLM {
+	return &HistoryLLM{inner: inner, history: append([]core.Message(nil), history...)}
+}
+
+// Messages returns the conversation so far, to be written back into
+// ExecutionCtx.CurrentHistory before the next checkpoint.
+func (h *HistoryLLM) Messages() []core.Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]core.Message(nil), h.history...)
+}
+
+// withHistory renders the conversation followed by the new prompt.
+func (h *HistoryLLM) withHistory(prompt string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.history) == 0 {
+		return prompt
+	}
+	var b strings.Builder
+	for _, msg := range h.history {
+		fmt.Fprintf(&b, "%s: %s\n", msg.Role, msg.Content)
+	}
+	fmt.Fprintf(&b, "user: %s", prompt)
+	return b.String()
+}
+
+func (h *HistoryLLM) record(prompt, reply string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = append(h.history,
+		core.Message{Role: "user", Content: prompt},
+		core.Message{Role: "assistant", Content: reply},
+	)
+}
+
+func (h *HistoryLLM) Complete(ctx context.Context, prompt string) (string, error) {
+	reply, err := h.inner.Complete(ctx, h.withHistory(prompt))
+	if err != nil {
+		return "", err
+	}
+	h.record(prompt, reply)
+	return reply, nil
+}
+
+func (h *HistoryLLM) Generate(ctx context.Context, prompt string, opts ...core.GenerateOption) (*core.LLMResponse, error) {
+	resp, err := h.inner.Generate(ctx, h.withHistory(prompt), opts...)
+	if err != nil {
+		return nil, err
+	}
+	h.record(prompt, resp.Text)
+	return resp, nil
+}
+
+func (h *HistoryLLM) Stream(ctx context.Context, prompt string) (<-chan core.StreamChunk, error) {
+	return h.inner.Stream(ctx, h.withHistory(prompt))
+}
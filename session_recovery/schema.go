@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/duynguyendang/manglekit/core"
+)
+
+// --- Schema versioning for persisted session state ---
+//
+// Every provider that writes session state to disk stores it as
+// {schema_version, state}. On read, a checkpoint written under an older
+// schema is upgraded one version at a time by the migrations registered
+// for it, and only then decoded into core.SessionState. Get still returns
+// the bare SessionState JSON of the running build, which is what the SDK
+// and SessionManager.Hydrate unmarshal. When the SDK adds or renames a field, bump
+// CurrentSchemaVersion and register a migration that rewrites the old
+// document; checkpoints already on disk keep hydrating.
+//
+// Schema history:
+//
+//	1  the bare SessionState JSON, written before the envelope existed
+//	2  {schema_version, state} around the same SessionState JSON
+
+// CurrentSchemaVersion is the schema every checkpoint is written with.
+const CurrentSchemaVersion = 2
+
+// ErrSchemaTooNew is returned for a checkpoint written by a newer build
+// than this one, which cannot be read without losing data.
+var ErrSchemaTooNew = errors.New("session schema is newer than this build")
+
+// persistedState is the envelope persisted state is stored in.
+type persistedState struct {
+	SchemaVersion int             `json:"schema_version"`
+	State         json.RawMessage `json:"state"`
+}
+
+// Migration upgrades a state document by exactly one schema version.
+type Migration func(doc map[string]any) (map[string]any, error)
+
+// MigrationRegistry upgrades state documents to its current schema.
+type MigrationRegistry struct {
+	current int
+
+	mu    sync.RWMutex
+	steps map[int]Migration
+}
+
+// NewMigrationRegistry returns a registry that upgrades to current.
+func NewMigrationRegistry(current int) *MigrationRegistry {
+	return &MigrationRegistry{current: current, steps: make(map[int]Migration)}
+}
+
+// Register sets the migration from schema version from to from+1.
+func (r *MigrationRegistry) Register(from int, m Migration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.steps[from] = m
+}
+
+// Upgrade decodes a persisted state of any known schema version, applying
+// each migration between its version and the current one in turn.
+func (r *MigrationRegistry) Upgrade(data []byte) (*core.SessionState, error) {
+	version, doc, err := splitEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if version > r.current {
+		return nil, fmt.Errorf("%w: stored v%d, this build reads up to v%d", ErrSchemaTooNew, version, r.current)
+	}
+
+	for ; version < r.current; version++ {
+		r.mu.RLock()
+		migrate, ok := r.steps[version]
+		r.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("no migration registered from schema v%d to v%d", version, version+1)
+		}
+		if doc, err = migrate(doc); err != nil {
+			return nil, fmt.Errorf("failed to migrate state from schema v%d to v%d: %w", version, version+1, err)
+		}
+	}
+
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal migrated state: %w", err)
+	}
+	var state core.SessionState
+	if err := json.Unmarshal(upgraded, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+	if err := checkNothingDropped(doc, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Encode wraps state in an envelope at the registry's current version.
+func (r *MigrationRegistry) Encode(state *core.SessionState) ([]byte, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal state: %w", err)
+	}
+	return json.Marshal(persistedState{SchemaVersion: r.current, State: data})
+}
+
+// splitEnvelope returns a persisted state's schema version and state
+// document. A document without schema_version is a v1 bare state.
+func splitEnvelope(data []byte) (int, map[string]any, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return 0, nil, fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+
+	version, body := 1, data
+	if _, ok := probe["schema_version"]; ok {
+		var env persistedState
+		if err := json.Unmarshal(data, &env); err != nil {
+			return 0, nil, fmt.Errorf("failed to unmarshal state envelope: %w", err)
+		}
+		if env.SchemaVersion < 1 {
+			return 0, nil, fmt.Errorf("invalid schema version %d", env.SchemaVersion)
+		}
+		version, body = env.SchemaVersion, env.State
+	}
+
+	// UseNumber keeps numbers exact through the migrations
+	var doc map[string]any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return 0, nil, fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+	return version, doc, nil
+}
+
+// checkNothingDropped fails if the migrated document has a top-level
+// field with a value that core.SessionState does not keep, which means a
+// rename is missing a migration and hydrating would silently lose data.
+func checkNothingDropped(doc map[string]any, state *core.SessionState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+	var kept map[string]json.RawMessage
+	if err := json.Unmarshal(data, &kept); err != nil {
+		return fmt.Errorf("failed to unmarshal state: %w", err)
+	}
+
+	var dropped []string
+	for key, value := range doc {
+		if _, ok := kept[key]; ok || isZeroJSON(value) {
+			continue
+		}
+		dropped = append(dropped, key)
+	}
+	if len(dropped) > 0 {
+		sort.Strings(dropped)
+		return fmt.Errorf("state has fields %v that SessionState does not know; a schema migration is missing", dropped)
+	}
+	return nil
+}
+
+// isZeroJSON reports whether a decoded JSON value carries no data.
+func isZeroJSON(v any) bool {
+	data, _ := json.Marshal(v)
+	for _, zero := range []string{`null`, `""`, `0`, `false`, `[]`, `{}`} {
+		if bytes.Equal(data, []byte(zero)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Migrations is the registry every provider reads checkpoints through.
+var Migrations = NewMigrationRegistry(CurrentSchemaVersion)
+
+func init() {
+	// v1 → v2 only introduced the envelope; the state itself is unchanged
+	Migrations.Register(1, func(doc map[string]any) (map[string]any, error) {
+		return doc, nil
+	})
+}
+
+// upgradeState converts persisted state of any schema version into the
+// bare SessionState JSON that Get returns.
+func upgradeState(data []byte) ([]byte, error) {
+	state, err := Migrations.Upgrade(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(state)
+}
+
+// encodeState converts any accepted state representation into the
+// current persisted form.
+func encodeState(state any) ([]byte, error) {
+	parsed, err := parseState(state)
+	if err != nil {
+		return nil, err
+	}
+	return Migrations.Encode(parsed)
+}
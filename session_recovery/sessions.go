@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/duynguyendang/manglekit/core"
+)
+
+// --- Session listing, TTLs and garbage collection ---
+//
+// Every provider records when a session was created and last written and
+// an optional TTL. A session expires once it has not been written for its
+// TTL; expired sessions stay readable until a Reaper evicts them. Times
+// come from an injectable Clock so expiry can be tested without sleeping.
+
+// ErrSessionNotFound is returned by SetTTL for an unknown session.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Clock tells providers and reapers the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock is the wall clock every provider uses by default.
+var SystemClock Clock = systemClock{}
+
+// SessionInfo describes a stored session without loading its state.
+type SessionInfo struct {
+	SessionID string
+	Created   time.Time
+	Updated   time.Time
+	TTL       time.Duration // 0 means the session never expires
+	Version   uint64
+}
+
+// ExpiresAt returns when the session expires, or the zero time if never.
+func (i SessionInfo) ExpiresAt() time.Time {
+	if i.TTL <= 0 {
+		return time.Time{}
+	}
+	return i.Updated.Add(i.TTL)
+}
+
+// Expired reports whether the session's TTL has run out at now.
+func (i SessionInfo) Expired(now time.Time) bool {
+	exp := i.ExpiresAt()
+	return !exp.IsZero() && !now.Before(exp)
+}
+
+// SessionFilter selects sessions in List. Zero fields match everything.
+type SessionFilter struct {
+	Prefix        string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+}
+
+// Match reports whether info passes every set field of the filter.
+func (f SessionFilter) Match(info SessionInfo) bool {
+	switch {
+	case !strings.HasPrefix(info.SessionID, f.Prefix):
+		return false
+	case !f.CreatedAfter.IsZero() && !info.Created.After(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !info.Created.Before(f.CreatedBefore):
+		return false
+	case !f.UpdatedAfter.IsZero() && !info.Updated.After(f.UpdatedAfter):
+		return false
+	case !f.UpdatedBefore.IsZero() && !info.Updated.Before(f.UpdatedBefore):
+		return false
+	}
+	return true
+}
+
+// SessionStore is a core.StateProvider that can enumerate its sessions
+// and expire them.
+type SessionStore interface {
+	core.StateProvider
+
+	// List returns the sessions matching filter, ordered by session ID.
+	List(ctx context.Context, filter SessionFilter) ([]SessionInfo, error)
+
+	// SetTTL sets how long the session may go unwritten before it
+	// expires; 0 clears the TTL.
+	SetTTL(ctx context.Context, sessionID string, ttl time.Duration) error
+
+	// EvictIfExpired deletes the session only if it is still expired at
+	// now, so a write racing with the reaper is never lost.
+	EvictIfExpired(ctx context.Context, sessionID string, now time.Time) (bool, error)
+}
+
+// sortSessions orders List results by session ID.
+func sortSessions(infos []SessionInfo) {
+	sort.Slice(infos, func(i, j int) bool { return infos[i].SessionID < infos[j].SessionID })
+}
+
+// Reaper evicts expired sessions from a store.
+type Reaper struct {
+	Store    SessionStore
+	Clock    Clock
+	Interval time.Duration
+
+	// OnReap, when set, receives the sessions each pass removed.
+	OnReap func(removed []SessionInfo)
+	// OnError, when set, receives errors from background passes.
+	OnError func(err error)
+}
+
+// RunOnce evicts every session that has expired by now and returns them.
+func (r *Reaper) RunOnce(ctx context.Context) ([]SessionInfo, error) {
+	clock := r.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	now := clock.Now()
+
+	infos, err := r.Store.List(ctx, SessionFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	var removed []SessionInfo
+	for _, info := range infos {
+		if !info.Expired(now) {
+			continue
+		}
+		evicted, err := r.Store.EvictIfExpired(ctx, info.SessionID, now)
+		if err != nil {
+			return removed, fmt.Errorf("failed to evict session %s: %w", info.SessionID, err)
+		}
+		if evicted {
+			removed = append(removed, info)
+		}
+	}
+	if len(removed) > 0 && r.OnReap != nil {
+		r.OnReap(removed)
+	}
+	return removed, nil
+}
+
+// Start runs RunOnce every Interval in the background until ctx is done
+// or the returned stop function is called. stop waits for the reaper to
+// exit.
+func (r *Reaper) Start(ctx context.Context) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := r.RunOnce(ctx); err != nil && r.OnError != nil {
+					r.OnError(err)
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+var (
+	_ SessionStore = (*InMemoryStateProvider)(nil)
+	_ SessionStore = (*FileStateProvider)(nil)
+	_ SessionStore = (*WALStateProvider)(nil)
+)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/duynguyendang/manglekit/core"
+)
+
+// --- Optimistic concurrency for session checkpoints ---
+//
+// Every stored session has a version that each successful write bumps;
+// version 0 means the session does not exist. A writer reads the session
+// with its version and writes back with CompareAndSet, which fails with a
+// *VersionConflictError if another writer got there first. Plain Set stays
+// unconditional so the SDK can keep using the provider as a
+// core.StateProvider.
+
+// VersionedStateProvider is a core.StateProvider with versioned reads and
+// compare-and-set writes.
+type VersionedStateProvider interface {
+	core.StateProvider
+
+	// GetVersioned returns the session's state and version, or nil and 0
+	// if it does not exist.
+	GetVersioned(ctx context.Context, sessionID string) (any, uint64, error)
+
+	// CompareAndSet stores state only if the session is still at version
+	// expected, and returns the new version.
+	CompareAndSet(ctx context.Context, sessionID string, state any, expected uint64) (uint64, error)
+}
+
+// ErrVersionConflict matches every *VersionConflictError with errors.Is.
+var ErrVersionConflict = errors.New("session version conflict")
+
+// VersionConflictError reports a CompareAndSet against a stale version.
+type VersionConflictError struct {
+	SessionID string
+	Expected  uint64
+	Actual    uint64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("session %s: version conflict (expected %d, stored %d)", e.SessionID, e.Expected, e.Actual)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// ConflictStrategy decides what SessionManager.Checkpoint does when the
+// stored session moved on since the state was hydrated.
+type ConflictStrategy int
+
+const (
+	// ConflictFail returns the *VersionConflictError to the caller.
+	ConflictFail ConflictStrategy = iota
+	// ConflictMergeFacts re-reads the session, merges its LogicalFacts
+	// into the state as a set union and retries. Every other field is
+	// taken from the state being checkpointed.
+	ConflictMergeFacts
+)
+
+// mergeFacts returns the union of stored and local facts: the stored facts
+// in their order, followed by local facts the store does not have yet.
+func mergeFacts(stored, local []string) []string {
+	seen := make(map[string]bool, len(stored)+len(local))
+	merged := make([]string, 0, len(stored)+len(local))
+	for _, facts := range [][]string{stored, local} {
+		for _, f := range facts {
+			if !seen[f] {
+				seen[f] = true
+				merged = append(merged, f)
+			}
+		}
+	}
+	return merged
+}
+
+var (
+	_ VersionedStateProvider = (*InMemoryStateProvider)(nil)
+	_ VersionedStateProvider = (*FileStateProvider)(nil)
+	_ VersionedStateProvider = (*WALStateProvider)(nil)
+)
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/duynguyendang/manglekit/core"
+)
+
+// WALStateProvider implements VersionedStateProvider as an append-only
+// write-ahead log per session plus a periodic snapshot.
+//
+// Set does not rewrite the whole SessionState: it appends one record with
+// the delta against the previous state (facts added, messages appended,
+// metadata keys set or removed), so the bytes written and fsynced per
+// checkpoint are proportional to the step, not to the session. Finding
+// the delta is not: Set still normalizes the whole incoming state and
+// compares it with the cached one, so its CPU cost grows with the
+// session. Every snapshotEvery records the current state is written to
+// <session>.snap, and once the snapshot and the directory are fsynced the
+// log is truncated. Get replays the snapshot plus the log tail.
+//
+// Each record is framed as a 4-byte length, a 4-byte CRC32 and the JSON
+// body. A record cut short by a crash mid-append fails the length or CRC
+// check; it and anything after it are dropped and the log is truncated
+// back to the last complete record before the next append.
+type WALStateProvider struct {
+	dir           string
+	snapshotEvery int
+	clock         Clock
+
+	mu       sync.Mutex
+	sessions map[string]*walSession
+}
+
+// walSession is the replayed state of one session's log.
+type walSession struct {
+	state   core.SessionState
+	seq     uint64 // sequence number of the last applied record
+	pending int    // records appended since the last snapshot
+	size    int64  // length of the log's valid prefix
+
+	created time.Time
+	updated time.Time
+	ttl     time.Duration
+}
+
+func (s *walSession) info(sessionID string) SessionInfo {
+	return SessionInfo{SessionID: sessionID, Created: s.created, Updated: s.updated, TTL: s.ttl, Version: s.seq}
+}
+
+// walRecord is one logged delta. Full replaces the state outright when a
+// change is not an extension of the previous state (e.g. a new payload).
+type walRecord struct {
+	Seq      uint64          `json:"seq"`
+	At       time.Time       `json:"at"`
+	Facts    []string        `json:"facts,omitempty"`
+	Messages []core.Message  `json:"messages,omitempty"`
+	SetMeta  map[string]any  `json:"set_meta,omitempty"`
+	DelMeta  []string        `json:"del_meta,omitempty"`
+	Full     json.RawMessage `json:"full,omitempty"`
+}
+
+// walSnapshot is the compacted state of a session up to Seq.
+type walSnapshot struct {
+	Seq     uint64          `json:"seq"`
+	Created time.Time       `json:"created"`
+	Updated time.Time       `json:"updated"`
+	TTL     time.Duration   `json:"ttl,omitempty"`
+	State   json.RawMessage `json:"state"`
+}
+
+const walHeaderSize = 8
+
+func NewWALStateProvider(dir string, snapshotEvery int) (*WALStateProvider, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state dir: %w", err)
+	}
+	if snapshotEvery < 1 {
+		snapshotEvery = 1
+	}
+	return &WALStateProvider{
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+		clock:         SystemClock,
+		sessions:      make(map[string]*walSession),
+	}, nil
+}
+
+// SetClock replaces the clock used for session timestamps.
+func (p *WALStateProvider) SetClock(c Clock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clock = c
+}
+
+func (p *WALStateProvider) walPath(sessionID string) string {
+	return filepath.Join(p.dir, sessionID+".wal")
+}
+
+func (p *WALStateProvider) snapPath(sessionID string) string {
+	return filepath.Join(p.dir, sessionID+".snap")
+}
+
+func (p *WALStateProvider) Get(ctx context.Context, sessionID string) (any, error) {
+	state, _, err := p.GetVersioned(ctx, sessionID)
+	return state, err
+}
+
+func (p *WALStateProvider) GetVersioned(ctx context.Context, sessionID string) (any, uint64, error) {
+	if err := checkSessionID(sessionID); err != nil {
+		return nil, 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, err := p.load(sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if s == nil {
+		return nil, 0, nil
+	}
+	data, err := json.Marshal(&s.state)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to marshal state: %w", err)
+	}
+	return data, s.seq, nil
+}
+
+func (p *WALStateProvider) Set(ctx context.Context, sessionID string, state any) error {
+	_, err := p.write(sessionID, state, nil)
+	return err
+}
+
+// CompareAndSet appends the state's delta only if the session's last
+// sequence number, which serves as its version, still matches expected.
+func (p *WALStateProvider) CompareAndSet(ctx context.Context, sessionID string, state any, expected uint64) (uint64, error) {
+	return p.write(sessionID, state, &expected)
+}
+
+func (p *WALStateProvider) write(sessionID string, state any, expected *uint64) (uint64, error) {
+	if err := checkSessionID(sessionID); err != nil {
+		return 0, err
+	}
+	next, err := normalizeState(state)
+	if err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, err := p.load(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	if s == nil {
+		s = &walSession{}
+	}
+	if expected != nil && *expected != s.seq {
+		return 0, &VersionConflictError{SessionID: sessionID, Expected: *expected, Actual: s.seq}
+	}
+
+	rec, err := diffState(&s.state, next)
+	if err != nil {
+		return 0, err
+	}
+	rec.Seq = s.seq + 1
+	rec.At = p.clock.Now()
+
+	n, err := p.appendRecord(sessionID, s.size, rec)
+	if err != nil {
+		return 0, err
+	}
+	// only a session with a record on disk is cached, or a failed first
+	// append would make Get return an empty state instead of nil
+	p.sessions[sessionID] = s
+	s.state = *next
+	s.seq = rec.Seq
+	if s.created.IsZero() {
+		s.created = rec.At
+	}
+	s.updated = rec.At
+	s.size += n
+	s.pending++
+
+	if s.pending >= p.snapshotEvery {
+		if err := p.compact(sessionID, s); err != nil {
+			return 0, err
+		}
+	}
+	return s.seq, nil
+}
+
+func (p *WALStateProvider) Delete(ctx context.Context, sessionID string) error {
+	if err := checkSessionID(sessionID); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.remove(sessionID)
+}
+
+// Close drops the replay cache; every Set is already durable on disk.
+func (p *WALStateProvider) Close(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sessions = make(map[string]*walSession)
+	return nil
+}
+
+func (p *WALStateProvider) List(ctx context.Context, filter SessionFilter) ([]SessionInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var infos []SessionInfo
+	for _, pattern := range []string{"*.wal", "*.snap"} {
+		paths, err := filepath.Glob(filepath.Join(p.dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			sessionID := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			if seen[sessionID] {
+				continue
+			}
+			seen[sessionID] = true
+
+			s, err := p.load(sessionID)
+			if err != nil {
+				return nil, err
+			}
+			if s == nil {
+				continue
+			}
+			if info := s.info(sessionID); filter.Match(info) {
+				infos = append(infos, info)
+			}
+		}
+	}
+	sortSessions(infos)
+	return infos, nil
+}
+
+// SetTTL records the TTL by compacting the session into a snapshot that
+// carries it; the sequence number, and so the version, does not change.
+func (p *WALStateProvider) SetTTL(ctx context.Context, sessionID string, ttl time.Duration) error {
+	if err := checkSessionID(sessionID); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, err := p.load(sessionID)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, sessionID)
+	}
+	s.ttl = ttl
+	return p.compact(sessionID, s)
+}
+
+func (p *WALStateProvider) EvictIfExpired(ctx context.Context, sessionID string, now time.Time) (bool, error) {
+	if err := checkSessionID(sessionID); err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, err := p.load(sessionID)
+	if err != nil || s == nil || !s.info(sessionID).Expired(now) {
+		return false, err
+	}
+	if err := p.remove(sessionID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// remove deletes the session's files and cache entry; p.mu must be held.
+func (p *WALStateProvider) remove(sessionID string) error {
+	delete(p.sessions, sessionID)
+	for _, path := range []string{p.walPath(sessionID), p.snapPath(sessionID)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete state: %w", err)
+		}
+	}
+	return nil
+}
+
+// load returns the cached session, replaying it from disk on first use.
+// It returns nil when the session has neither a snapshot nor a log.
+func (p *WALStateProvider) load(sessionID string) (*walSession, error) {
+	if s, ok := p.sessions[sessionID]; ok {
+		return s, nil
+	}
+
+	s := &walSession{}
+	found := false
+
+	data, err := os.ReadFile(p.snapPath(sessionID))
+	switch {
+	case err == nil:
+		var snap walSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+		}
+		state, err := Migrations.Upgrade(snap.State)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+		}
+		s.state, s.seq = *state, snap.Seq
+		s.created, s.updated, s.ttl = snap.Created, snap.Updated, snap.TTL
+		found = true
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	walData, err := os.ReadFile(p.walPath(sessionID))
+	switch {
+	case err == nil:
+		records, valid := decodeRecords(walData)
+		for _, rec := range records {
+			// Records already folded into the snapshot survive a crash
+			// between writing the snapshot and truncating the log.
+			if rec.Seq <= s.seq {
+				continue
+			}
+			if err := applyRecord(&s.state, rec); err != nil {
+				return nil, fmt.Errorf("failed to replay log record %d: %w", rec.Seq, err)
+			}
+			s.seq = rec.Seq
+			if s.created.IsZero() {
+				s.created = rec.At
+			}
+			s.updated = rec.At
+			s.pending++
+			found = true
+		}
+		s.size = valid
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read log: %w", err)
+	}
+
+	if !found {
+		return nil, nil
+	}
+	p.sessions[sessionID] = s
+	return s, nil
+}
+
+// decodeRecords parses framed records and returns them with the length of
+// the valid prefix. Decoding stops at the first short or corrupt record.
+func decodeRecords(data []byte) ([]walRecord, int64) {
+	var records []walRecord
+	var off int64
+	for int64(len(data))-off >= walHeaderSize {
+		n := int64(binary.BigEndian.Uint32(data[off:]))
+		sum := binary.BigEndian.Uint32(data[off+4:])
+		body := data[off+walHeaderSize:]
+		if int64(len(body)) < n || crc32.ChecksumIEEE(body[:n]) != sum {
+			break
+		}
+		var rec walRecord
+		if err := json.Unmarshal(body[:n], &rec); err != nil {
+			break
+		}
+		records = append(records, rec)
+		off += walHeaderSize + n
+	}
+	return records, off
+}
+
+// appendRecord writes one framed record at offset valid, cutting off any torn
+// tail left by a crash, and fsyncs it. It returns the bytes written.
+func (p *WALStateProvider) appendRecord(sessionID string, valid int64, rec *walRecord) (int64, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal log record: %w", err)
+	}
+	frame := make([]byte, walHeaderSize, walHeaderSize+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	binary.BigEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(body))
+	frame = append(frame, body...)
+
+	// a new log's directory entry must be durable along with its record
+	_, statErr := os.Stat(p.walPath(sessionID))
+	created := os.IsNotExist(statErr)
+
+	f, err := os.OpenFile(p.walPath(sessionID), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open log: %w", err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(valid); err != nil {
+		return 0, fmt.Errorf("failed to truncate log: %w", err)
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek log: %w", err)
+	}
+	if _, err := f.Write(frame); err != nil {
+		return 0, fmt.Errorf("failed to append log record: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync log: %w", err)
+	}
+	if created {
+		if err := syncDir(p.dir); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(frame)), nil
+}
+
+// compact writes the session's state as a snapshot, fsyncs it and the
+// directory, then empties the log.
+func (p *WALStateProvider) compact(sessionID string, s *walSession) error {
+	state, err := Migrations.Encode(&s.state)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(walSnapshot{
+		Seq:     s.seq,
+		Created: s.created,
+		Updated: s.updated,
+		TTL:     s.ttl,
+		State:   state,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(p.dir, sessionID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p.snapPath(sessionID)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to commit snapshot: %w", err)
+	}
+	// the snapshot must be durable before the records it replaces are gone
+	if err := syncDir(p.dir); err != nil {
+		return err
+	}
+
+	if err := os.Truncate(p.walPath(sessionID), 0); err != nil {
+		return fmt.Errorf("failed to truncate log: %w", err)
+	}
+	s.size = 0
+	s.pending = 0
+	return nil
+}
+
+// normalizeState round-trips any accepted state through its persisted
+// form, so deltas compare values the same way they will look after a
+// replay.
+func normalizeState(state any) (*core.SessionState, error) {
+	data, err := encodeState(state)
+	if err != nil {
+		return nil, err
+	}
+	return Migrations.Upgrade(data)
+}
+
+// diffState returns the record that turns prev into next: appended facts
+// and messages plus metadata changes when next only grew prev, otherwise
+// a full-state record.
+func diffState(prev, next *core.SessionState) (*walRecord, error) {
+	sameRest, err := sameExceptGrowth(prev, next)
+	if err != nil {
+		return nil, err
+	}
+	if !sameRest ||
+		!hasPrefix(next.LogicalFacts, prev.LogicalFacts) ||
+		!hasMessagePrefix(next.ExecutionCtx.CurrentHistory, prev.ExecutionCtx.CurrentHistory) {
+		full, err := Migrations.Encode(next)
+		if err != nil {
+			return nil, err
+		}
+		return &walRecord{Full: full}, nil
+	}
+
+	rec := &walRecord{
+		Facts:    next.LogicalFacts[len(prev.LogicalFacts):],
+		Messages: next.ExecutionCtx.CurrentHistory[len(prev.ExecutionCtx.CurrentHistory):],
+	}
+	for k, v := range next.ActiveEnvelope.Metadata {
+		old, ok := prev.ActiveEnvelope.Metadata[k]
+		if ok {
+			same, err := jsonEqual(old, v)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				continue
+			}
+		}
+		if rec.SetMeta == nil {
+			rec.SetMeta = make(map[string]any)
+		}
+		rec.SetMeta[k] = v
+	}
+	for k := range prev.ActiveEnvelope.Metadata {
+		if _, ok := next.ActiveEnvelope.Metadata[k]; !ok {
+			rec.DelMeta = append(rec.DelMeta, k)
+		}
+	}
+	return rec, nil
+}
+
+// sameExceptGrowth reports whether the two states are equal once facts,
+// history and metadata, which deltas carry, are set aside.
+func sameExceptGrowth(a, b *core.SessionState) (bool, error) {
+	strip := func(s core.SessionState) core.SessionState {
+		s.LogicalFacts = nil
+		s.ExecutionCtx.CurrentHistory = nil
+		s.ActiveEnvelope.Metadata = nil
+		return s
+	}
+	return jsonEqual(strip(*a), strip(*b))
+}
+
+func jsonEqual(a, b any) (bool, error) {
+	da, err := json.Marshal(a)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal state: %w", err)
+	}
+	db, err := json.Marshal(b)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal state: %w", err)
+	}
+	return bytes.Equal(da, db), nil
+}
+
+func hasPrefix(s, prefix []string) bool {
+	if len(prefix) > len(s) {
+		return false
+	}
+	for i := range prefix {
+		if s[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func hasMessagePrefix(s, prefix []core.Message) bool {
+	if len(prefix) > len(s) {
+		return false
+	}
+	for i := range prefix {
+		if same, err := jsonEqual(s[i], prefix[i]); err != nil || !same {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRecord replays one logged delta onto state.
+func applyRecord(state *core.SessionState, rec walRecord) error {
+	if rec.Full != nil {
+		full, err := Migrations.Upgrade(rec.Full)
+		if err != nil {
+			return err
+		}
+		*state = *full
+		return nil
+	}
+	state.LogicalFacts = append(state.LogicalFacts, rec.Facts...)
+	state.ExecutionCtx.CurrentHistory = append(state.ExecutionCtx.CurrentHistory, rec.Messages...)
+	if len(rec.SetMeta) > 0 && state.ActiveEnvelope.Metadata == nil {
+		state.ActiveEnvelope.Metadata = make(map[string]any)
+	}
+	for k, v := range rec.SetMeta {
+		state.ActiveEnvelope.Metadata[k] = v
+	}
+	for _, k := range rec.DelMeta {
+		delete(state.ActiveEnvelope.Metadata, k)
+	}
+	return nil
+}
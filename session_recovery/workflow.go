@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/duynguyendang/manglekit/core"
+	"github.com/duynguyendang/manglekit/sdk"
+)
+
+// WorkflowRunner drives a workflow from whatever its journal says is done.
+// It does not track a step index: after recovery (and after every step) it
+// hands the session's step/intent facts plus the workflow's step order to
+// the rules in workflow.dl and asks which steps were interrupted and which
+// step is next.
+type WorkflowRunner struct {
+	client *sdk.Client
+	steps  []WorkflowStep
+	runner *StepRunner
+}
+
+// NewWorkflowRunner loads workflow.dl into the client's engine.
+func NewWorkflowRunner(ctx context.Context, client *sdk.Client, runner *StepRunner, steps []WorkflowStep) (*WorkflowRunner, error) {
+	policy, err := os.ReadFile(filepath.Join(exampleDir(), "workflow.dl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workflow.dl: %w", err)
+	}
+	if err := client.Engine().LoadPolicy(ctx, string(policy)); err != nil {
+		return nil, fmt.Errorf("failed to load workflow rules: %w", err)
+	}
+	return &WorkflowRunner{client: client, steps: steps, runner: runner}, nil
+}
+
+// facts renders the workflow's step order and the session's journal as
+// Datalog facts.
+func (w *WorkflowRunner) facts(state *core.SessionState) []string {
+	var facts []string
+	for i, step := range w.steps {
+		facts = append(facts, fmt.Sprintf(`step_order(%q, %d)`, step.ID(), i+1))
+		if i > 0 {
+			facts = append(facts, fmt.Sprintf(`follows(%q, %q)`, step.ID(), w.steps[i-1].ID()))
+		}
+	}
+	intended, completed := journal(state)
+	for _, step := range w.steps {
+		if intended[step.ID()] {
+			facts = append(facts, fmt.Sprintf(`intent(%q)`, step.ID()))
+		}
+		if completed[step.ID()] {
+			facts = append(facts, fmt.Sprintf(`step(%q)`, step.ID()))
+		}
+	}
+	return facts
+}
+
+// query returns the step indexes bound to S by a one-variable goal.
+func (w *WorkflowRunner) query(ctx context.Context, state *core.SessionState, goal string) ([]int, error) {
+	solutions, err := w.client.Engine().Query(ctx, w.facts(state), goal)
+	if err != nil {
+		return nil, fmt.Errorf("query %s failed: %w", goal, err)
+	}
+	var indexes []int
+	for i, step := range w.steps {
+		for _, sol := range solutions {
+			if strings.Trim(sol["S"], `"`) == step.ID() {
+				indexes = append(indexes, i)
+				break
+			}
+		}
+	}
+	return indexes, nil
+}
+
+// ResumePoint asks Datalog for the next step to run. It returns
+// len(steps) once no step is pending.
+func (w *WorkflowRunner) ResumePoint(ctx context.Context, state *core.SessionState) (int, error) {
+	next, err := w.query(ctx, state, `next_step(S)`)
+	if err != nil {
+		return 0, err
+	}
+	if len(next) > 0 {
+		return next[0], nil
+	}
+
+	pending, err := w.query(ctx, state, `pending(S)`)
+	if err != nil {
+		return 0, err
+	}
+	if len(pending) > 0 {
+		return 0, fmt.Errorf("journal is inconsistent: step %q is pending but no step is next", w.steps[pending[0]].ID())
+	}
+	return len(w.steps), nil
+}
+
+// Run reconciles interrupted steps, then runs the workflow to completion
+// from the resume point Datalog derives.
+func (w *WorkflowRunner) Run(ctx context.Context, state *core.SessionState) error {
+	interrupted, err := w.query(ctx, state, `interrupted(S)`)
+	if err != nil {
+		return err
+	}
+	for _, i := range interrupted {
+		if err := w.runner.ReconcileStep(ctx, state, i, w.steps[i]); err != nil {
+			return fmt.Errorf("failed to reconcile interrupted steps: %w", err)
+		}
+	}
+
+	for {
+		i, err := w.ResumePoint(ctx, state)
+		if err != nil {
+			return err
+		}
+		if i == len(w.steps) {
+			return nil
+		}
+		if err := w.runner.RunStep(ctx, state, i, w.steps[i]); err != nil {
+			return err
+		}
+	}
+}